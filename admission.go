@@ -0,0 +1,144 @@
+package mrcp
+
+import (
+	"errors"
+	"fmt"
+	"github.com/emiago/sipgo/sip"
+	"net"
+	"sync"
+	"time"
+)
+
+var errOverCapacity = errors.New("server over capacity")
+
+// Utilization the dialogs admitted by a Server and its limits, a limit of 0 is unlimited
+type Utilization struct {
+	// Dialogs the dialogs being created or established
+	Dialogs int
+	// MaxDialogs the limit of Dialogs
+	MaxDialogs int
+	// Resources the dialogs per resource
+	Resources map[Resource]int
+	// Sources the dialogs per source IP
+	Sources map[string]int
+	// AvailablePorts the free RTP and RTCP port pairs
+	AvailablePorts int
+	// Rejected the INVITEs rejected by the limits since the server started
+	Rejected uint64
+}
+
+// admission counts the dialogs of a Server against its limits.
+type admission struct {
+	mu        sync.Mutex
+	dialogs   int
+	resources map[Resource]int
+	sources   map[string]int
+	rejected  uint64
+	// tokens the token bucket of the INVITE rate
+	tokens float64
+	last   time.Time
+}
+
+// allowInvite takes a token of the INVITE rate limit, returns false if the rate is exceeded.
+func (s *Server) allowInvite(now time.Time) bool {
+	if s.InviteRate <= 0 {
+		return true
+	}
+	a := &s.admission
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	burst := float64(s.InviteBurst)
+	if a.last.IsZero() {
+		a.tokens = burst
+	} else {
+		a.tokens = min(burst, a.tokens+now.Sub(a.last).Seconds()*s.InviteRate)
+	}
+	a.last = now
+	if a.tokens < 1 {
+		a.rejected++
+		return false
+	}
+	a.tokens--
+	return true
+}
+
+// admit counts a dialog of a resource from a source IP,
+// returns an error wrapping errOverCapacity if a limit is reached.
+func (s *Server) admit(resource Resource, source string) error {
+	a := &s.admission
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var err error
+	switch {
+	case s.MaxDialogs > 0 && a.dialogs >= s.MaxDialogs:
+		err = fmt.Errorf("%w: %d dialogs", errOverCapacity, a.dialogs)
+	case s.MaxDialogsPerResource[resource] > 0 && a.resources[resource] >= s.MaxDialogsPerResource[resource]:
+		err = fmt.Errorf("%w: %d %s dialogs", errOverCapacity, a.resources[resource], resource)
+	case s.MaxDialogsPerSource > 0 && a.sources[source] >= s.MaxDialogsPerSource:
+		err = fmt.Errorf("%w: %d dialogs from %s", errOverCapacity, a.sources[source], source)
+	}
+	if err != nil {
+		a.rejected++
+		return err
+	}
+
+	if a.resources == nil {
+		a.resources = make(map[Resource]int)
+		a.sources = make(map[string]int)
+	}
+	a.dialogs++
+	a.resources[resource]++
+	a.sources[source]++
+	return nil
+}
+
+// release uncounts a dialog admitted.
+func (s *Server) release(resource Resource, source string) {
+	a := &s.admission
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.dialogs--
+	if a.resources[resource]--; a.resources[resource] <= 0 {
+		delete(a.resources, resource)
+	}
+	if a.sources[source]--; a.sources[source] <= 0 {
+		delete(a.sources, source)
+	}
+}
+
+// Utilization returns the dialogs admitted by the server and its limits.
+func (s *Server) Utilization() Utilization {
+	a := &s.admission
+	a.mu.Lock()
+	u := Utilization{
+		Dialogs:    a.dialogs,
+		MaxDialogs: s.MaxDialogs,
+		Resources:  make(map[Resource]int, len(a.resources)),
+		Sources:    make(map[string]int, len(a.sources)),
+		Rejected:   a.rejected,
+	}
+	for resource, n := range a.resources {
+		u.Resources[resource] = n
+	}
+	for source, n := range a.sources {
+		u.Sources[source] = n
+	}
+	a.mu.Unlock()
+
+	if s.porter != nil {
+		u.AvailablePorts = s.porter.available()
+	}
+	return u
+}
+
+// sourceIP returns the IP of the source of a request.
+func sourceIP(req *sip.Request) string {
+	host, _, err := net.SplitHostPort(req.Source())
+	if err != nil {
+		return req.Source()
+	}
+	return host
+}
@@ -0,0 +1,137 @@
+package mrcp
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServer_admit(t *testing.T) {
+	type dialog struct {
+		resource Resource
+		source   string
+	}
+	tests := []struct {
+		name    string
+		server  *Server
+		dialogs []dialog
+		want    []bool
+	}{
+		{
+			name:   "unlimited",
+			server: &Server{},
+			dialogs: []dialog{
+				{ResourceSpeechsynth, "10.0.0.1"},
+				{ResourceSpeechsynth, "10.0.0.1"},
+			},
+			want: []bool{true, true},
+		},
+		{
+			name:   "max dialogs",
+			server: &Server{MaxDialogs: 2},
+			dialogs: []dialog{
+				{ResourceSpeechsynth, "10.0.0.1"},
+				{ResourceSpeechrecog, "10.0.0.2"},
+				{ResourceRecorder, "10.0.0.3"},
+			},
+			want: []bool{true, true, false},
+		},
+		{
+			name:   "max dialogs per resource",
+			server: &Server{MaxDialogsPerResource: map[Resource]int{ResourceSpeechrecog: 1}},
+			dialogs: []dialog{
+				{ResourceSpeechrecog, "10.0.0.1"},
+				{ResourceSpeechrecog, "10.0.0.2"},
+				{ResourceSpeechsynth, "10.0.0.3"},
+			},
+			want: []bool{true, false, true},
+		},
+		{
+			name:   "max dialogs per source",
+			server: &Server{MaxDialogsPerSource: 1},
+			dialogs: []dialog{
+				{ResourceSpeechsynth, "10.0.0.1"},
+				{ResourceSpeechrecog, "10.0.0.1"},
+				{ResourceSpeechsynth, "10.0.0.2"},
+			},
+			want: []bool{true, false, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []bool
+			for _, d := range tt.dialogs {
+				err := tt.server.admit(d.resource, d.source)
+				if err != nil && !errors.Is(err, errOverCapacity) {
+					t.Errorf("admit() error = %v, want %v", err, errOverCapacity)
+				}
+				got = append(got, err == nil)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("admit() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_release(t *testing.T) {
+	s := &Server{MaxDialogs: 1}
+	if err := s.admit(ResourceSpeechsynth, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.admit(ResourceSpeechsynth, "10.0.0.1"); err == nil {
+		t.Errorf("admit() error = %v, wantErr %v", err, true)
+	}
+	want := Utilization{
+		Dialogs:    1,
+		MaxDialogs: 1,
+		Resources:  map[Resource]int{ResourceSpeechsynth: 1},
+		Sources:    map[string]int{"10.0.0.1": 1},
+		Rejected:   1,
+	}
+	if got := s.Utilization(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Utilization() got = %v, want %v", got, want)
+	}
+	if got := s.Capacity().AvailableDialogs; got != 0 {
+		t.Errorf("Capacity() got = %v, want %v", got, 0)
+	}
+
+	s.release(ResourceSpeechsynth, "10.0.0.1")
+	want = Utilization{
+		MaxDialogs: 1,
+		Resources:  map[Resource]int{},
+		Sources:    map[string]int{},
+		Rejected:   1,
+	}
+	if got := s.Utilization(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Utilization() got = %v, want %v", got, want)
+	}
+	if err := s.admit(ResourceSpeechsynth, "10.0.0.1"); err != nil {
+		t.Errorf("admit() error = %v, wantErr %v", err, false)
+	}
+}
+
+func TestServer_allowInvite(t *testing.T) {
+	s := &Server{InviteRate: 2, InviteBurst: 2}
+	now := time.Now()
+	tests := []struct {
+		after time.Duration
+		want  bool
+	}{
+		{after: 0, want: true},
+		{after: 0, want: true},
+		{after: 0, want: false},
+		{after: 250 * time.Millisecond, want: false},
+		{after: 500 * time.Millisecond, want: true},
+		{after: 500 * time.Millisecond, want: false},
+		{after: 5 * time.Second, want: true},
+		{after: 5 * time.Second, want: true},
+		{after: 5 * time.Second, want: false},
+	}
+	for i, tt := range tests {
+		if got := s.allowInvite(now.Add(tt.after)); got != tt.want {
+			t.Errorf("allowInvite() #%d got = %v, want %v", i, got, tt.want)
+		}
+	}
+}
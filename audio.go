@@ -0,0 +1,408 @@
+package mrcp
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hateeyan/go-mrcp/pkg/pcm"
+	"io"
+	"sync"
+)
+
+const (
+	// audioWriterBuffer the maximum duration of audio buffered by AudioWriter in milliseconds
+	audioWriterBuffer = 1000
+	// audioReaderBuffer the maximum duration of audio buffered by AudioReader in milliseconds
+	audioReaderBuffer = 10000
+)
+
+// audioTranscoder converts between 16-bit little-endian PCM and the payload of an audio codec.
+type audioTranscoder interface {
+	Encode(samples []byte) ([]byte, error)
+	Decode(payload []byte) ([]byte, error)
+}
+
+func newAudioTranscoder(codec CodecDesc) (audioTranscoder, error) {
+	switch codec.Name {
+	case "PCMU":
+		return g711Transcoder{encode: pcm.LinearToMuLaw, decode: pcm.MuLawToLiner}, nil
+	case "PCMA":
+		return g711Transcoder{encode: pcm.LinearToALaw, decode: pcm.ALawToLiner}, nil
+	case "L16":
+		return l16Transcoder{}, nil
+	case "G722":
+		return &g722Transcoder{encoder: pcm.NewG722Encoder(), decoder: pcm.NewG722Decoder()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported audio codec: %s", codec.Name)
+	}
+}
+
+type g711Transcoder struct {
+	encode func(samples []byte, payload []byte) error
+	decode func(payload []byte, samples []byte) error
+}
+
+func (t g711Transcoder) Encode(samples []byte) ([]byte, error) {
+	payload := make([]byte, len(samples)/2)
+	if err := t.encode(samples[:2*len(payload)], payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func (t g711Transcoder) Decode(payload []byte) ([]byte, error) {
+	samples := make([]byte, 2*len(payload))
+	if err := t.decode(payload, samples); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+type l16Transcoder struct{}
+
+func (l16Transcoder) Encode(samples []byte) ([]byte, error) {
+	payload := make([]byte, len(samples)&^1)
+	if err := pcm.LinearToL16(samples, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func (l16Transcoder) Decode(payload []byte) ([]byte, error) {
+	samples := make([]byte, len(payload)&^1)
+	if err := pcm.L16ToLinear(payload, samples); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// g722Transcoder the encoder and decoder keep state across frames,
+// a transcoder is used for one direction of a stream only.
+type g722Transcoder struct {
+	encoder *pcm.G722Encoder
+	decoder *pcm.G722Decoder
+}
+
+func (t *g722Transcoder) Encode(samples []byte) ([]byte, error) {
+	payload := make([]byte, len(samples)/4)
+	if err := t.encoder.Encode(samples, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func (t *g722Transcoder) Decode(payload []byte) ([]byte, error) {
+	samples := make([]byte, 4*len(payload))
+	if err := t.decoder.Decode(payload, samples); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// audioPipe a bounded buffer of PCM shared by a producer and a consumer.
+type audioPipe struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newAudioPipe() *audioPipe {
+	p := &audioPipe{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *audioPipe) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// AudioReader reads the received audio as 16-bit little-endian PCM
+// at the sample rate returned by Media.AudioRate.
+type AudioReader struct {
+	pipe *audioPipe
+	// limit the maximum number of buffered bytes, the oldest audio is discarded
+	limit int
+}
+
+// Read reads decoded PCM, blocks until audio is received, returns io.EOF after the media is closed.
+func (r *AudioReader) Read(p []byte) (int, error) {
+	r.pipe.mu.Lock()
+	defer r.pipe.mu.Unlock()
+	for r.pipe.buf.Len() == 0 {
+		if r.pipe.closed {
+			return 0, io.EOF
+		}
+		r.pipe.cond.Wait()
+	}
+	return r.pipe.buf.Read(p)
+}
+
+func (r *AudioReader) write(samples []byte) {
+	r.pipe.mu.Lock()
+	if r.pipe.closed {
+		r.pipe.mu.Unlock()
+		return
+	}
+	if over := r.pipe.buf.Len() + len(samples) - r.limit; over > 0 {
+		r.pipe.buf.Next(over)
+	}
+	r.pipe.buf.Write(samples)
+	r.pipe.cond.Broadcast()
+	r.pipe.mu.Unlock()
+}
+
+// AudioWriter writes audio to be sent as 16-bit little-endian PCM
+// at the sample rate returned by Media.AudioRate.
+// The audio is encoded, packetized per ptime and paced by Media.
+type AudioWriter struct {
+	pipe *audioPipe
+	// limit the maximum number of buffered bytes, Write blocks beyond it
+	limit int
+	// eof the writer is closed by the user
+	eof bool
+	// flush a short last frame is sent instead of waiting for more audio
+	flush bool
+}
+
+// Write buffers PCM to be sent, blocks while the buffer is full.
+func (w *AudioWriter) Write(p []byte) (int, error) {
+	w.pipe.mu.Lock()
+	defer w.pipe.mu.Unlock()
+	var n int
+	for n < len(p) {
+		if w.pipe.closed || w.eof {
+			return n, io.ErrClosedPipe
+		}
+		free := w.limit - w.pipe.buf.Len()
+		if free <= 0 {
+			w.pipe.cond.Wait()
+			continue
+		}
+		chunk := min(free, len(p)-n)
+		w.pipe.buf.Write(p[n : n+chunk])
+		n += chunk
+	}
+	return n, nil
+}
+
+// Drain blocks until all buffered audio has been sent or the media is closed.
+func (w *AudioWriter) Drain() {
+	w.pipe.mu.Lock()
+	w.flush = true
+	for w.pipe.buf.Len() > 0 && !w.pipe.closed {
+		w.pipe.cond.Wait()
+	}
+	w.pipe.mu.Unlock()
+}
+
+// Close stops sending once the buffered audio has been sent.
+func (w *AudioWriter) Close() error {
+	w.pipe.mu.Lock()
+	w.eof = true
+	w.pipe.cond.Broadcast()
+	w.pipe.mu.Unlock()
+	return nil
+}
+
+// read reads the PCM of a frame, returns an empty frame on underrun.
+func (w *AudioWriter) read(size int) ([]byte, bool) {
+	w.pipe.mu.Lock()
+	defer w.pipe.mu.Unlock()
+	if w.pipe.closed || (w.eof && w.pipe.buf.Len() == 0) {
+		return nil, false
+	}
+	if w.pipe.buf.Len() == 0 || (w.pipe.buf.Len() < size && !w.eof && !w.flush) {
+		w.flush = false
+		return nil, true
+	}
+	frame := make([]byte, size)
+	// a short last frame is padded with silence
+	_, _ = w.pipe.buf.Read(frame)
+	if w.pipe.buf.Len() == 0 {
+		w.flush = false
+	}
+	w.pipe.cond.Broadcast()
+	return frame, true
+}
+
+// audioStream the MediaFrameHandler backing AudioReader and AudioWriter.
+type audioStream struct {
+	reader *AudioReader
+	writer *AudioWriter
+	tx, rx audioTranscoder
+	// txResampler rxResampler convert between the rate of the reader and writer and the codec, nil if equal
+	txResampler, rxResampler *pcm.Resampler
+	// frameSize the PCM size of a frame read from the writer in bytes
+	frameSize int
+	// codecFrameSize the PCM size of a frame at the audio rate of the codec in bytes
+	codecFrameSize int
+	// pending the resampled PCM not sent yet
+	pending []byte
+}
+
+func newAudioStream() *audioStream {
+	return &audioStream{
+		reader: &AudioReader{pipe: newAudioPipe(), limit: 2 * 8000 * audioReaderBuffer / 1000},
+		writer: &AudioWriter{pipe: newAudioPipe(), limit: 2 * 8000 * audioWriterBuffer / 1000},
+	}
+}
+
+func (s *audioStream) StartTx(m *Media, codec CodecDesc) error {
+	var err error
+	s.tx, err = newAudioTranscoder(codec)
+	if err != nil {
+		return err
+	}
+	rate := m.AudioRate()
+	if rate != codec.AudioRate() {
+		if s.txResampler, err = pcm.NewResampler(rate, codec.AudioRate()); err != nil {
+			return err
+		}
+	}
+	s.frameSize = 2 * rate * m.ptime() / 1000
+	s.codecFrameSize = 2 * m.audioSamplesPerPacket()
+	s.writer.pipe.mu.Lock()
+	s.writer.limit = 2 * rate * audioWriterBuffer / 1000
+	s.writer.pipe.mu.Unlock()
+	return nil
+}
+
+func (s *audioStream) ReadFrame(m *Media) ([]byte, bool) {
+	samples, ok := s.readSamples()
+	if !ok || len(samples) == 0 {
+		return nil, ok
+	}
+	frame, err := s.tx.Encode(samples)
+	if err != nil {
+		m.logger.Error("failed to encode audio", "error", err)
+		return nil, false
+	}
+	return frame, true
+}
+
+// readSamples reads the PCM of a frame at the audio rate of the codec.
+func (s *audioStream) readSamples() ([]byte, bool) {
+	if s.txResampler == nil {
+		return s.writer.read(s.frameSize)
+	}
+	// the resampler emits a varying number of samples, frames are cut from the pending output
+	for len(s.pending) < s.codecFrameSize {
+		samples, ok := s.writer.read(s.frameSize)
+		if !ok {
+			if len(s.pending) == 0 {
+				return nil, false
+			}
+			// pad the last frame with silence
+			s.pending = append(s.pending, make([]byte, s.codecFrameSize-len(s.pending))...)
+			break
+		}
+		if len(samples) == 0 {
+			return nil, true
+		}
+		s.pending = append(s.pending, s.txResampler.Resample(samples)...)
+	}
+	frame := make([]byte, s.codecFrameSize)
+	copy(frame, s.pending)
+	s.pending = append(s.pending[:0], s.pending[s.codecFrameSize:]...)
+	return frame, true
+}
+
+func (s *audioStream) StartRx(m *Media, codec CodecDesc) error {
+	var err error
+	s.rx, err = newAudioTranscoder(codec)
+	if err != nil {
+		return err
+	}
+	rate := m.AudioRate()
+	if rate != codec.AudioRate() {
+		if s.rxResampler, err = pcm.NewResampler(codec.AudioRate(), rate); err != nil {
+			return err
+		}
+	}
+	s.reader.pipe.mu.Lock()
+	s.reader.limit = 2 * rate * audioReaderBuffer / 1000
+	s.reader.pipe.mu.Unlock()
+	return nil
+}
+
+func (s *audioStream) WriteFrame(m *Media, frame []byte) bool {
+	samples, err := s.rx.Decode(frame)
+	if err != nil {
+		m.logger.Error("failed to decode audio", "error", err)
+		return false
+	}
+	if s.rxResampler != nil {
+		samples = s.rxResampler.Resample(samples)
+	}
+	s.reader.write(samples)
+	return true
+}
+
+func (s *audioStream) close() {
+	s.reader.pipe.close()
+	s.writer.pipe.close()
+}
+
+// audioStream returns the audio stream of the media, creating it if needed.
+func (m *Media) audioStream() *audioStream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.audio == nil {
+		m.audio = newAudioStream()
+		if m.closed {
+			m.audio.close()
+		} else if m.noTxAudio {
+			m.audio.writer.pipe.close()
+		}
+	}
+	return m.audio
+}
+
+// AudioReader returns the reader of the received audio.
+// It is fed only if the DialogHandler returns no MediaHandler from OnMediaOpen.
+func (m *Media) AudioReader() *AudioReader { return m.audioStream().reader }
+
+// AudioWriter returns the writer of the audio to be sent.
+// If the DialogHandler returns a MediaHandler from OnMediaOpen, the audio is sent in place of its packets.
+// Write fails with io.ErrClosedPipe if the media does not send audio, e.g. recvonly media.
+func (m *Media) AudioWriter() *AudioWriter { return m.audioStream().writer }
+
+// closeAudioWriter fails the writes to AudioWriter, its audio is never sent.
+func (m *Media) closeAudioWriter() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.noTxAudio = true
+	if m.audio != nil {
+		m.audio.writer.pipe.close()
+	}
+}
+
+// SetAudioRate sets the sample rate of AudioReader and AudioWriter, e.g. the rate preferred by the engine,
+// the audio is resampled if it differs from the audio rate of the negotiated codec.
+// It must be called before the media starts, e.g. in DialogHandler.OnMediaOpen.
+// Default: the audio rate of the negotiated codec
+func (m *Media) SetAudioRate(rate int) {
+	m.audioRate = rate
+}
+
+// AudioRate returns the sample rate of AudioReader and AudioWriter.
+func (m *Media) AudioRate() int {
+	if m.audioRate > 0 {
+		return m.audioRate
+	}
+	return m.audioCodec.AudioRate()
+}
+
+// AudioCodec returns the negotiated audio codec.
+func (m *Media) AudioCodec() CodecDesc { return m.audioCodec }
+
+// PlayTones writes the tone sequence to Media.AudioWriter at Media.AudioRate,
+// e.g. a pcm.Beep before recording starts, the tones are sent in place of the packets of a MediaHandler.
+// It returns once the audio is buffered, call AudioWriter.Drain to wait until it has been sent.
+func (m *Media) PlayTones(segments ...pcm.ToneSegment) error {
+	_, err := m.AudioWriter().Write(pcm.ToneSequence(m.AudioRate(), segments...))
+	return err
+}
@@ -0,0 +1,150 @@
+package mrcp
+
+import (
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestAudioWriter_read(t *testing.T) {
+	tests := []struct {
+		name   string
+		write  []byte
+		eof    bool
+		flush  bool
+		want   [][]byte
+		wantOk []bool
+	}{
+		{
+			name:   "frames",
+			write:  []byte{1, 2, 3, 4, 5},
+			want:   [][]byte{{1, 2}, {3, 4}, nil},
+			wantOk: []bool{true, true, true},
+		},
+		{
+			name:   "eof",
+			write:  []byte{1, 2, 3},
+			eof:    true,
+			want:   [][]byte{{1, 2}, {3, 0}, nil},
+			wantOk: []bool{true, true, false},
+		},
+		{
+			name:   "flush",
+			write:  []byte{1, 2, 3},
+			flush:  true,
+			want:   [][]byte{{1, 2}, {3, 0}, nil},
+			wantOk: []bool{true, true, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &AudioWriter{pipe: newAudioPipe(), limit: 10}
+			if _, err := w.Write(tt.write); err != nil {
+				t.Errorf("Write() error = %v", err)
+				return
+			}
+			w.eof = tt.eof
+			w.flush = tt.flush
+			for i := range tt.want {
+				got, ok := w.read(2)
+				if !reflect.DeepEqual(got, tt.want[i]) || ok != tt.wantOk[i] {
+					t.Errorf("read() got = %v, %v, want %v, %v", got, ok, tt.want[i], tt.wantOk[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_newAudioTranscoder(t *testing.T) {
+	samples := []byte{0x00, 0x00, 0xE8, 0x03, 0x18, 0xFC, 0x10, 0x27}
+	tests := []struct {
+		codec CodecDesc
+		// wantPayload the size of the encoded samples
+		wantPayload int
+		// wantExact the samples are decoded without loss
+		wantExact bool
+	}{
+		{codec: CodecDesc{PayloadType: 0, Name: "PCMU", SampleRate: 8000}, wantPayload: 4},
+		{codec: CodecDesc{PayloadType: 8, Name: "PCMA", SampleRate: 8000}, wantPayload: 4},
+		{codec: CodecDesc{PayloadType: 96, Name: "L16", SampleRate: 16000}, wantPayload: 8, wantExact: true},
+		{codec: CodecDesc{PayloadType: 9, Name: "G722", SampleRate: 8000}, wantPayload: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.codec.Name, func(t *testing.T) {
+			tc, err := newAudioTranscoder(tt.codec)
+			if err != nil {
+				t.Errorf("newAudioTranscoder() error = %v", err)
+				return
+			}
+			payload, err := tc.Encode(samples)
+			if err != nil || len(payload) != tt.wantPayload {
+				t.Errorf("Encode() got = %v, error = %v", payload, err)
+				return
+			}
+			got, err := tc.Decode(payload)
+			if err != nil || len(got) != len(samples) {
+				t.Errorf("Decode() got = %v, error = %v", got, err)
+			}
+			if tt.wantExact && !reflect.DeepEqual(got, samples) {
+				t.Errorf("Decode() got = %v, want %v", got, samples)
+			}
+		})
+	}
+}
+
+func Test_audioStream_resample(t *testing.T) {
+	m := &Media{audioCodec: CodecDesc{PayloadType: 96, Name: "L16", SampleRate: 16000}, logger: slog.Default()}
+	m.SetAudioRate(8000)
+	s := newAudioStream()
+	if err := s.StartTx(m, m.audioCodec); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.StartRx(m, m.audioCodec); err != nil {
+		t.Fatal(err)
+	}
+
+	// one second of a 1 kHz tone at 8 kHz
+	const n = 8000
+	samples := make([]byte, 2*n)
+	for i := 0; i < n; i++ {
+		binary.LittleEndian.PutUint16(samples[2*i:], uint16(int16(10000*math.Sin(2*math.Pi*1000*float64(i)/8000))))
+	}
+	go func() {
+		_, _ = s.writer.Write(samples)
+		_ = s.writer.Close()
+	}()
+
+	// sent as 16 kHz frames of 20 ms and received back at 8 kHz
+	for {
+		frame, ok := s.ReadFrame(m)
+		if !ok {
+			break
+		}
+		if len(frame) == 0 {
+			continue
+		}
+		if len(frame) != 640 {
+			t.Fatalf("ReadFrame() got %d bytes, want 640", len(frame))
+		}
+		s.WriteFrame(m, frame)
+	}
+	s.close()
+	got, _ := io.ReadAll(s.reader)
+	if len(got) < 2*n-100 {
+		t.Fatalf("read %d bytes, want about %d", len(got), 2*n)
+	}
+
+	var signal, noise float64
+	for i := n / 4; i < 3*n/4; i++ {
+		want := float64(int16(binary.LittleEndian.Uint16(samples[2*i:])))
+		v := float64(int16(binary.LittleEndian.Uint16(got[2*i:])))
+		signal += want * want
+		noise += (want - v) * (want - v)
+	}
+	if snr := 10 * math.Log10(signal/noise); snr < 60 {
+		t.Errorf("snr got = %.1f dB, want >= 60 dB", snr)
+	}
+}
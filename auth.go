@@ -0,0 +1,193 @@
+package mrcp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/emiago/sipgo/sip"
+	"github.com/icholy/digest"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// authNonceTTL the validity of the nonce of a digest challenge
+const authNonceTTL = 5 * time.Minute
+
+// Authenticator challenges the INVITEs of a Server with SIP digest authentication,
+// an INVITE without valid credentials is answered 401.
+type Authenticator interface {
+	// Password returns the password of a user, false if the user is unknown
+	Password(req *sip.Request, username string) (password string, ok bool)
+}
+
+type AuthenticatorFunc struct {
+	PasswordFunc func(req *sip.Request, username string) (password string, ok bool)
+}
+
+func (h AuthenticatorFunc) Password(req *sip.Request, username string) (string, bool) {
+	if h.PasswordFunc != nil {
+		return h.PasswordFunc(req, username)
+	}
+	return "", false
+}
+
+// newNonce returns a unique nonce signed with the key of the server, valid for authNonceTTL.
+func (s *Server) newNonce() string {
+	unique := make([]byte, 8)
+	_, _ = rand.Read(unique)
+	value := strconv.FormatInt(time.Now().Unix(), 16) + "." + hex.EncodeToString(unique)
+	return value + "." + s.signNonce(value)
+}
+
+func (s *Server) signNonce(value string) string {
+	mac := hmac.New(sha256.New, s.nonceKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// checkNonce reports whether the nonce was issued by the server and whether it is expired.
+func (s *Server) checkNonce(nonce string) (valid, stale bool) {
+	i := strings.LastIndexByte(nonce, '.')
+	if i == -1 || !hmac.Equal([]byte(nonce[i+1:]), []byte(s.signNonce(nonce[:i]))) {
+		return false, false
+	}
+	issued, expired := nonceIssued(nonce, time.Now())
+	if issued.IsZero() {
+		return false, false
+	}
+	return true, expired
+}
+
+// nonceCounts the highest nonce-count used with each nonce, a nonce-count is used once,
+// a nonce without qop is used once.
+type nonceCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+	pruned time.Time
+}
+
+// use records the nonce-count of a nonce, returns false if it was already used.
+func (c *nonceCounts) use(nonce string, nc int, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	// the expired nonces are rejected as stale before being counted
+	if now.Sub(c.pruned) > authNonceTTL {
+		for used := range c.counts {
+			if _, expired := nonceIssued(used, now); expired {
+				delete(c.counts, used)
+			}
+		}
+		c.pruned = now
+	}
+	if last, ok := c.counts[nonce]; ok && nc <= last {
+		return false
+	}
+	c.counts[nonce] = nc
+	return true
+}
+
+func nonceIssued(nonce string, now time.Time) (issued time.Time, expired bool) {
+	ts, _, _ := strings.Cut(nonce, ".")
+	sec, err := strconv.ParseInt(ts, 16, 64)
+	if err != nil {
+		return time.Time{}, true
+	}
+	issued = time.Unix(sec, 0)
+	return issued, now.Sub(issued) > authNonceTTL
+}
+
+// authenticate verifies the digest credentials of a request, the digest URI must be the Request-URI
+// and a nonce-count must not be replayed. stale reports valid credentials of an expired nonce.
+func (s *Server) authenticate(req *sip.Request) (ok, stale bool) {
+	h := req.GetHeader("Authorization")
+	if h == nil {
+		return false, false
+	}
+	creds, err := digest.ParseCredentials(h.Value())
+	if err != nil || creds.Realm != s.Realm {
+		return false, false
+	}
+	var uri sip.Uri
+	if err := sip.ParseUri(creds.URI, &uri); err != nil || uri.Addr() != req.Recipient.Addr() {
+		return false, false
+	}
+	valid, stale := s.checkNonce(creds.Nonce)
+	if !valid {
+		return false, false
+	}
+	password, ok := s.Authenticator.Password(req, creds.Username)
+	if !ok {
+		return false, false
+	}
+
+	chal := &digest.Challenge{
+		Realm:     creds.Realm,
+		Nonce:     creds.Nonce,
+		Opaque:    creds.Opaque,
+		Algorithm: creds.Algorithm,
+	}
+	if creds.QOP != "" {
+		chal.QOP = []string{creds.QOP}
+	}
+	want, err := digest.Digest(chal, digest.Options{
+		Method:   req.Method.String(),
+		URI:      creds.URI,
+		Count:    creds.Nc,
+		Username: creds.Username,
+		Password: password,
+		Cnonce:   creds.Cnonce,
+		GetBody: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(req.Body())), nil
+		},
+	})
+	if err != nil || subtle.ConstantTimeCompare([]byte(want.Response), []byte(creds.Response)) != 1 {
+		return false, false
+	}
+	if stale {
+		return false, true
+	}
+	if !s.nonces.use(creds.Nonce, creds.Nc, time.Now()) {
+		return false, false
+	}
+	return true, false
+}
+
+// challenge responds 401 with a digest challenge unless the request is authenticated,
+// returns whether the request is authenticated.
+func (s *Server) challenge(req *sip.Request, tx sip.ServerTransaction) bool {
+	ok, stale := s.authenticate(req)
+	if ok {
+		return true
+	}
+
+	chal := &digest.Challenge{
+		Realm:     s.Realm,
+		Nonce:     s.newNonce(),
+		Algorithm: "MD5",
+		QOP:       []string{"auth"},
+		Stale:     stale,
+	}
+	res := sip.NewResponseFromRequest(req, sip.StatusUnauthorized, "Unauthorized", nil)
+	res.AppendHeader(sip.NewHeader("WWW-Authenticate", chal.String()))
+	if err := tx.Respond(res); err != nil {
+		s.Logger.Error("failed to respond 401 unauthorized", "callId", req.CallID().Value(), "error", err)
+	}
+	return false
+}
+
+func newNonceKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,111 @@
+package mrcp
+
+import (
+	"github.com/emiago/sipgo/sip"
+	"github.com/icholy/digest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServer_authenticate(t *testing.T) {
+	key, err := newNonceKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		Realm:    "mrcp",
+		nonceKey: key,
+		Authenticator: AuthenticatorFunc{PasswordFunc: func(req *sip.Request, username string) (string, bool) {
+			return "secret", username == "alice"
+		}},
+	}
+	stale := strconv.FormatInt(time.Now().Add(-2*authNonceTTL).Unix(), 16) + ".0011223344556677"
+
+	// sign returns an INVITE with the credentials of the digest URI uri, nc the nonce-count, qop auth unless 0
+	sign := func(uri, nonce, username, password string, nc int) *sip.Request {
+		req := sip.NewRequest(sip.INVITE, sip.Uri{Host: "127.0.0.1", Port: 5060})
+		chal := &digest.Challenge{Realm: "mrcp", Nonce: nonce, Algorithm: "MD5"}
+		if nc > 0 {
+			chal.QOP = []string{"auth"}
+		}
+		cred, err := digest.Digest(chal, digest.Options{Method: "INVITE", URI: uri, Count: nc, Username: username, Password: password})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AppendHeader(sip.NewHeader("Authorization", cred.String()))
+		return req
+	}
+	newRequest := func(nonce, username, password string) *sip.Request {
+		return sign("sip:127.0.0.1:5060", nonce, username, password, 1)
+	}
+	counted, single := s.newNonce(), s.newNonce()
+	tests := []struct {
+		name      string
+		req       *sip.Request
+		wantOk    bool
+		wantStale bool
+	}{
+		{
+			name:   "authenticated",
+			req:    newRequest(s.newNonce(), "alice", "secret"),
+			wantOk: true,
+		},
+		{
+			name: "no credentials",
+			req:  sip.NewRequest(sip.INVITE, sip.Uri{Host: "127.0.0.1"}),
+		},
+		{
+			name: "wrong password",
+			req:  newRequest(s.newNonce(), "alice", "guess"),
+		},
+		{
+			name: "unknown user",
+			req:  newRequest(s.newNonce(), "bob", "secret"),
+		},
+		{
+			name: "forged nonce",
+			req:  newRequest(stale+".00112233445566778899aabbccddeeff", "alice", "secret"),
+		},
+		{
+			name:      "stale nonce",
+			req:       newRequest(stale+"."+s.signNonce(stale), "alice", "secret"),
+			wantStale: true,
+		},
+		{
+			name: "other uri",
+			req:  sign("sip:127.0.0.2:5060", s.newNonce(), "alice", "secret", 1),
+		},
+		{
+			name:   "nonce-count",
+			req:    sign("sip:127.0.0.1:5060", counted, "alice", "secret", 1),
+			wantOk: true,
+		},
+		{
+			name: "replayed nonce-count",
+			req:  sign("sip:127.0.0.1:5060", counted, "alice", "secret", 1),
+		},
+		{
+			name:   "next nonce-count",
+			req:    sign("sip:127.0.0.1:5060", counted, "alice", "secret", 2),
+			wantOk: true,
+		},
+		{
+			name:   "without qop",
+			req:    sign("sip:127.0.0.1:5060", single, "alice", "secret", 0),
+			wantOk: true,
+		},
+		{
+			name: "replayed without qop",
+			req:  sign("sip:127.0.0.1:5060", single, "alice", "secret", 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, stale := s.authenticate(tt.req)
+			if ok != tt.wantOk || stale != tt.wantStale {
+				t.Errorf("authenticate() got = %v/%v, want %v/%v", ok, stale, tt.wantOk, tt.wantStale)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package mrcp
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/hateeyan/go-mrcp/pkg"
@@ -136,22 +137,26 @@ type Channel struct {
 	requestId uint32
 	conn      *connection
 	handler   ChannelHandler
-	closed    bool
-	logger    *slog.Logger
+	// activity the last message sent or received
+	activity activity
+	closed   bool
+	logger   *slog.Logger
 }
 
-func (d *DialogClient) dialMRCPServer() error {
+func (d *DialogClient) dialMRCPServer(ctx context.Context) error {
 	if d.channel != nil {
 		return nil
 	}
 
 	if d.rdesc.ControlDesc.ChannelId.Id == "" {
-		return fmt.Errorf("invalid channel identifier: %s", d.rdesc.ControlDesc.ChannelId)
+		return &SDPNegotiationError{Err: fmt.Errorf("invalid channel identifier: %s", d.rdesc.ControlDesc.ChannelId)}
 	}
 
-	conn, err := net.Dial("tcp", d.rdesc.ControlDesc.Host+":"+strconv.Itoa(d.rdesc.ControlDesc.Port))
+	addr := d.rdesc.ControlDesc.Host + ":" + strconv.Itoa(d.rdesc.ControlDesc.Port)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
-		return err
+		return &MRCPConnectError{Addr: addr, Err: err}
 	}
 	d.channel = &Channel{
 		id: d.rdesc.ControlDesc.ChannelId,
@@ -229,6 +234,7 @@ func (c *Channel) NewEvent(event, requestState string) Message {
 // TODO: check Channel inused
 func (c *Channel) SendMrcpMessage(msg Message) error {
 	c.logger.Info("send MRCP message", "type", msg.messageType.String())
+	c.activity.touch()
 	return c.conn.writeMessage(msg)
 }
 
@@ -245,6 +251,7 @@ func (c *Channel) bound() bool {
 
 func (c *Channel) onMessage(msg Message) {
 	c.logger.Info("receive MRCP message", "type", msg.messageType.String())
+	c.activity.touch()
 	if c.handler != nil {
 		c.handler.OnMessage(c, msg)
 	}
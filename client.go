@@ -1,10 +1,14 @@
 package mrcp
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
 	"log/slog"
 	"sync"
+	"time"
 )
 
 type Client struct {
@@ -22,6 +26,22 @@ type Client struct {
 	// RtpPortMin RtpPortMax RTP port range
 	// Default: [20000, 40000)
 	RtpPortMin, RtpPortMax uint16
+	// Latching how the destination of the outgoing media is learned from the received packets
+	// Default: LatchFirstPacket
+	Latching LatchMode
+	// SessionExpires the session interval of SIP session timers (RFC 4028), the session is refreshed
+	// or closed with a BYE if not refreshed in time, 0 only runs them on request of the server
+	// Default: 0
+	SessionExpires time.Duration
+	// MinSE the minimum session interval accepted
+	// Default: 90s
+	MinSE time.Duration
+	// SessionRefreshMethod the method of the session refresh requests, sip.UPDATE or sip.INVITE
+	// Default: sip.UPDATE
+	SessionRefreshMethod sip.RequestMethod
+	// Username Password the credentials of the INVITEs challenged with 401 or 407,
+	// see WithCredentials for a single dial
+	Username, Password string
 	// Logger
 	// Default: slog.Default
 	Logger *slog.Logger
@@ -51,6 +71,12 @@ func (c *Client) Run() error {
 	if c.RtpPortMax == 0 {
 		c.RtpPortMax = defaultRtpPortMax
 	}
+	if c.MinSE == 0 {
+		c.MinSE = defaultMinSE
+	}
+	if c.SessionRefreshMethod == "" {
+		c.SessionRefreshMethod = sip.UPDATE
+	}
 	if c.Logger == nil {
 		c.Logger = slog.Default()
 	}
@@ -81,29 +107,106 @@ func (c *Client) Run() error {
 	return nil
 }
 
+// DialTimeoutError the context of the dial was done or the INVITE timed out before the answer,
+// a CANCEL is sent if the server has answered provisionally.
+type DialTimeoutError struct {
+	Err error
+}
+
+func (e *DialTimeoutError) Error() string { return "dial timeout: " + e.Err.Error() }
+func (e *DialTimeoutError) Unwrap() error { return e.Err }
+
+// DialRejectedError the server answered the INVITE with a final non-2xx response
+type DialRejectedError struct {
+	StatusCode int
+	Reason     string
+	Response   *sip.Response
+}
+
+func (e *DialRejectedError) Error() string {
+	return fmt.Sprintf("dial rejected: %d %s", e.StatusCode, e.Reason)
+}
+
+// SDPNegotiationError the answer of the server is invalid or not compatible with the offer
+type SDPNegotiationError struct {
+	Err error
+}
+
+func (e *SDPNegotiationError) Error() string { return "sdp negotiation failed: " + e.Err.Error() }
+func (e *SDPNegotiationError) Unwrap() error { return e.Err }
+
+// MRCPConnectError the MRCP channel failed to connect to the server
+type MRCPConnectError struct {
+	Addr string
+	Err  error
+}
+
+func (e *MRCPConnectError) Error() string {
+	return "failed to connect mrcp server " + e.Addr + ": " + e.Err.Error()
+}
+func (e *MRCPConnectError) Unwrap() error { return e.Err }
+
+// Dial establishes a dialog with the server at raddr, the INVITE is cancelled when ctx is done.
+// The errors are DialTimeoutError, DialRejectedError, SDPNegotiationError, MRCPConnectError or others.
 func (c *Client) Dial(
+	ctx context.Context,
 	raddr string,
 	resource Resource,
 	handler DialogHandler,
 	opts ...DialogClientOptionFunc,
 ) (*DialogClient, error) {
-	dc, err := c.newDialog(resource, handler, opts...)
+	dc, _, err := c.dial(ctx, raddr, resource, handler, nil, opts...)
+	return dc, err
+}
+
+// dial establishes a dialog with the server at raddr,
+// retry reports whether another server may succeed when it fails.
+func (c *Client) dial(
+	ctx context.Context,
+	raddr string,
+	resource Resource,
+	handler DialogHandler,
+	setup DialogClientOptionFunc,
+	opts ...DialogClientOptionFunc,
+) (dc *DialogClient, retry bool, err error) {
+	dc, err = c.newDialog(resource, handler, opts...)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	if err := dc.invite(raddr); err != nil {
+	if setup != nil {
+		setup(dc)
+	}
+	if err := dc.invite(ctx, raddr); err != nil {
 		_ = dc.Close()
-		return nil, err
+		var (
+			rejected *DialRejectedError
+			timeout  *DialTimeoutError
+			sdpErr   *SDPNegotiationError
+		)
+		switch {
+		case errors.As(err, &rejected):
+			retry = rejected.StatusCode == int(sip.StatusServiceUnavailable) ||
+				rejected.StatusCode == int(sip.StatusRequestTimeout)
+		case errors.As(err, &timeout):
+			// the caller gave up
+			retry = ctx.Err() == nil
+		case errors.As(err, &sdpErr):
+			retry = false
+		default:
+			// transport failure, unless the caller gave up
+			retry = ctx.Err() == nil
+		}
+		return nil, retry, err
 	}
 	if err := dc.initMedia(); err != nil {
 		_ = dc.Close()
-		return nil, err
+		return nil, false, err
 	}
-	if err := dc.dialMRCPServer(); err != nil {
+	if err := dc.dialMRCPServer(ctx); err != nil {
 		_ = dc.Close()
-		return nil, err
+		return nil, ctx.Err() == nil, err
 	}
-	return dc, nil
+	return dc, false, nil
 }
 
 func (c *Client) onBye(req *sip.Request, tx sip.ServerTransaction) {
@@ -122,10 +225,40 @@ func (c *Client) onBye(req *sip.Request, tx sip.ServerTransaction) {
 	}
 }
 
+// onRefresh handles re-INVITE and UPDATE requests of the server.
+func (c *Client) onRefresh(req *sip.Request, tx sip.ServerTransaction) {
+	got, ok := c.dialogs.Load(req.CallID().Value())
+	if !ok {
+		res := sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil)
+		if err := tx.Respond(res); err != nil {
+			c.Logger.Error("failed to respond to "+req.Method.String(), "callId", req.CallID().Value(), "error", err)
+		}
+		return
+	}
+
+	dialog := got.(*DialogClient)
+	if err := dialog.onRefresh(req, tx); err != nil {
+		c.Logger.Error("failed to respond to "+req.Method.String(), "callId", req.CallID().Value(), "error", err)
+		return
+	}
+}
+
 func (c *Client) onRequest(req *sip.Request, tx sip.ServerTransaction) {
 	switch req.Method {
 	case sip.BYE:
 		c.onBye(req, tx)
+	case sip.INVITE, sip.UPDATE:
+		c.onRefresh(req, tx)
+	case sip.OPTIONS:
+		c.onOptions(req, tx)
+	case sip.ACK:
+		// ACK of a 2xx to a re-INVITE
+	case sip.CANCEL:
+		// the CANCELs matching a transaction are answered by the transaction layer
+		res := sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil)
+		if err := tx.Respond(res); err != nil {
+			c.Logger.Warn("failed to respond CANCEL request", "callId", req.CallID(), "error", err)
+		}
 	default:
 		c.Logger.Warn("SIP request handler not found", "method", req.Method)
 		res := sip.NewResponseFromRequest(req, 405, "Method Not Allowed", nil)
@@ -0,0 +1,148 @@
+package mrcp
+
+import (
+	"context"
+	"errors"
+	"github.com/emiago/sipgo/sip"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeSIPServer answers the INVITEs with status, only 100 Trying if status is 100, nothing if 0.
+// A CANCEL is answered 200 and its INVITE 487, the methods received are sent to methods.
+func fakeSIPServer(t *testing.T, status sip.StatusCode, methods chan<- sip.RequestMethod) string {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		var invite *sip.Request
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			msg, err := sip.ParseMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			req, ok := msg.(*sip.Request)
+			if !ok {
+				continue
+			}
+			select {
+			case methods <- req.Method:
+			default:
+			}
+
+			var responses []*sip.Response
+			switch req.Method {
+			case sip.INVITE:
+				invite = req
+				switch status {
+				case 0:
+				case sip.StatusTrying:
+					responses = append(responses, sip.NewResponseFromRequest(req, sip.StatusTrying, "Trying", nil))
+				default:
+					responses = append(responses, sip.NewResponseFromRequest(req, status, "Rejected", nil))
+				}
+			case sip.CANCEL:
+				responses = append(responses, sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+				if invite != nil {
+					responses = append(responses, sip.NewResponseFromRequest(invite, sip.StatusRequestTerminated, "Request Terminated", nil))
+				}
+			}
+			for _, res := range responses {
+				if _, err := conn.WriteToUDP([]byte(res.String()), addr); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func freePort(t *testing.T) int {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestClient_Dial(t *testing.T) {
+	c := &Client{SIPPort: freePort(t), RtpPortMin: 30000, RtpPortMax: 30100, Logger: slog.Default()}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	tests := []struct {
+		name        string
+		status      sip.StatusCode
+		wantTimeout bool
+		wantStatus  int
+		wantMethods []sip.RequestMethod
+	}{
+		{
+			name:        "rejected",
+			status:      sip.StatusServiceUnavailable,
+			wantStatus:  503,
+			wantMethods: []sip.RequestMethod{sip.INVITE},
+		},
+		{
+			name:        "no answer",
+			wantTimeout: true,
+			wantMethods: []sip.RequestMethod{sip.INVITE},
+		},
+		{
+			name:        "cancelled",
+			status:      sip.StatusTrying,
+			wantTimeout: true,
+			wantMethods: []sip.RequestMethod{sip.INVITE, sip.CANCEL},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			methods := make(chan sip.RequestMethod, 10)
+			raddr := fakeSIPServer(t, tt.status, methods)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err := c.Dial(ctx, raddr, ResourceSpeechsynth, nil)
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Dial() took %v", elapsed)
+			}
+
+			var timeout *DialTimeoutError
+			if got := errors.As(err, &timeout); got != tt.wantTimeout {
+				t.Errorf("Dial() error = %v, want timeout %v", err, tt.wantTimeout)
+			}
+			if tt.wantTimeout && !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Dial() error = %v, want %v", err, context.DeadlineExceeded)
+			}
+			var rejected *DialRejectedError
+			if errors.As(err, &rejected) != (tt.wantStatus != 0) || (rejected != nil && rejected.StatusCode != tt.wantStatus) {
+				t.Errorf("Dial() error = %v, want status %v", err, tt.wantStatus)
+			}
+
+			for _, want := range tt.wantMethods {
+				select {
+				case got := <-methods:
+					if got != want {
+						t.Errorf("Dial() sent %v, want %v", got, want)
+					}
+				case <-time.After(time.Second):
+					t.Errorf("Dial() did not send %v", want)
+				}
+			}
+		})
+	}
+}
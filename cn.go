@@ -0,0 +1,158 @@
+package mrcp
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// comfortNoiseLevel the noise level of the sent CN packets in -dBov
+	comfortNoiseLevel = 70
+	// comfortNoiseRefresh the number of packet intervals between CN packets during silence
+	comfortNoiseRefresh = 25
+	// comfortNoiseMaxLevel the maximum noise level in -dBov, see RFC 3389 section 3
+	comfortNoiseMaxLevel = 127
+)
+
+// comfortNoise expands received CN packets into noise encoded with the audio codec,
+// see RFC 3389. The spectral information is ignored, white noise is generated.
+type comfortNoise struct {
+	mu         sync.Mutex
+	transcoder audioTranscoder
+	// samples the number of audio samples in a packet
+	samples int
+	// units the number of timestamp units in a packet
+	units  int
+	active bool
+	// amplitude the peak amplitude of the uniform noise
+	amplitude float64
+	packet    RTPPacket
+	rand      *rand.Rand
+}
+
+func newComfortNoise(codec CodecDesc, ptime int) (*comfortNoise, error) {
+	transcoder, err := newAudioTranscoder(codec)
+	if err != nil {
+		return nil, err
+	}
+	return &comfortNoise{
+		transcoder: transcoder,
+		samples:    codec.AudioRate() * ptime / 1000,
+		units:      codec.SampleRate * ptime / 1000,
+		packet:     RTPPacket{PayloadType: uint8(codec.PayloadType)},
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// start is called when a CN packet is received, the noise is generated until stop is called.
+func (n *comfortNoise) start(packet *RTPPacket) {
+	level := comfortNoiseMaxLevel
+	if len(packet.Payload) > 0 {
+		level = int(packet.Payload[0] & 0x7F)
+	}
+	// the RMS of uniform noise in [-a, a] is a/sqrt(3)
+	rms := math.MaxInt16 * math.Pow(10, -float64(level)/20)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.active {
+		n.active = true
+		n.packet.SequenceNumber = packet.SequenceNumber
+		n.packet.Timestamp = packet.Timestamp
+	}
+	n.amplitude = rms * math.Sqrt(3)
+	n.packet.SSRC = packet.SSRC
+}
+
+// stop is called when an audio packet is received.
+func (n *comfortNoise) stop() {
+	n.mu.Lock()
+	n.active = false
+	n.mu.Unlock()
+}
+
+// next returns a packet of noise, nil if no CN packet is being expanded.
+// The sequence numbers continue from the first CN packet of the silence.
+func (n *comfortNoise) next() []byte {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.active {
+		return nil
+	}
+
+	samples := make([]byte, 2*n.samples)
+	for i := 0; i < len(samples); i += 2 {
+		v := (2*n.rand.Float64() - 1) * n.amplitude
+		binary.LittleEndian.PutUint16(samples[i:], uint16(int16(v)))
+	}
+	payload, err := n.transcoder.Encode(samples)
+	if err != nil {
+		return nil
+	}
+	n.packet.Payload = payload
+	data, err := n.packet.Marshal()
+	if err != nil {
+		return nil
+	}
+	n.packet.SequenceNumber++
+	n.packet.Timestamp += uint32(n.units)
+	return data
+}
+
+// writeRTPPacket writes a received packet to the MediaHandler, CN packets are expanded into noise.
+func (m *Media) writeRTPPacket(packet *RTPPacket, data []byte) bool {
+	m.record(packet)
+	m.detectDTMF(packet)
+	if m.cnCodec.Name != "" && int(packet.PayloadType) == m.cnCodec.PayloadType {
+		if m.rxNoise != nil {
+			m.rxNoise.start(packet)
+		}
+		return true
+	}
+	if int(packet.PayloadType) == m.audioCodec.PayloadType {
+		if m.rxNoise != nil {
+			m.rxNoise.stop()
+		}
+		m.onReceivedAudio(packet.Payload)
+	}
+	m.rxMu.Lock()
+	defer m.rxMu.Unlock()
+	return m.handler.WriteRTPPacket(m, data)
+}
+
+// startComfortNoise writes the expanded noise to the MediaHandler every packetization interval.
+func (m *Media) startComfortNoise() {
+	t := time.NewTicker(time.Duration(m.ptime()) * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-t.C:
+		}
+
+		data := m.rxNoise.next()
+		if data == nil {
+			continue
+		}
+		if m.speech != nil {
+			var packet RTPPacket
+			if err := packet.Unmarshal(data); err == nil {
+				m.onReceivedAudio(packet.Payload)
+			}
+		}
+		if m.rxStopped.Load() {
+			continue
+		}
+		m.rxMu.Lock()
+		ok := m.handler.WriteRTPPacket(m, data)
+		m.rxMu.Unlock()
+		if !ok {
+			m.rxStopped.Store(true)
+			return
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package mrcp
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func Test_comfortNoise(t *testing.T) {
+	codec := CodecDesc{PayloadType: 8, Name: "PCMA", SampleRate: 8000}
+	n, err := newComfortNoise(codec, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := n.next(); got != nil {
+		t.Errorf("next() before start got = %v, want nil", got)
+	}
+
+	n.start(&RTPPacket{PayloadType: 13, SequenceNumber: 10, Timestamp: 1000, SSRC: 1, Payload: []byte{40}})
+	var packet RTPPacket
+	for i := 0; i < 4; i++ {
+		if i == 2 {
+			// a refresh CN packet does not restart the sequence numbers
+			n.start(&RTPPacket{PayloadType: 13, SequenceNumber: 11, Timestamp: 1320, SSRC: 1, Payload: []byte{40}})
+		}
+		if err := packet.Unmarshal(n.next()); err != nil {
+			t.Fatal(err)
+		}
+		if packet.PayloadType != 8 || packet.SequenceNumber != uint16(10+i) || packet.Timestamp != uint32(1000+160*i) || len(packet.Payload) != 160 {
+			t.Errorf("next() got = %+v", packet)
+		}
+	}
+
+	// the level of the noise is -40 dBov
+	transcoder, _ := newAudioTranscoder(codec)
+	samples, _ := transcoder.Decode(packet.Payload)
+	var sum float64
+	for i := 0; i < len(samples); i += 2 {
+		v := float64(int16(binary.LittleEndian.Uint16(samples[i:])))
+		sum += v * v
+	}
+	level := 20 * math.Log10(math.Sqrt(sum/160)/math.MaxInt16)
+	if level < -43 || level > -37 {
+		t.Errorf("level got = %.1f dBov, want -40 dBov", level)
+	}
+
+	n.stop()
+	if got := n.next(); got != nil {
+		t.Errorf("next() after stop got = %v, want nil", got)
+	}
+}
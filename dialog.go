@@ -11,6 +11,9 @@ const (
 	ProtoTCP = "TCP/MRCPv2"
 	ProtoTLS = "TCP/TLS/MRCPv2"
 
+	ProtoRTP  = "RTP/AVP"
+	ProtoSRTP = "RTP/SAVP"
+
 	SetupActive  = "active"
 	SetupPassive = "passive"
 
@@ -23,6 +26,7 @@ type Resource string
 const (
 	ResourceSpeechsynth Resource = "speechsynth"
 	ResourceSpeechrecog Resource = "speechrecog"
+	ResourceRecorder    Resource = "recorder"
 )
 
 type Direction string
@@ -47,17 +51,25 @@ type ControlDesc struct {
 
 const (
 	CodecTelephoneEvent = "telephone-event"
+	// CodecCN comfort noise, see RFC 3389
+	CodecCN = "CN"
 )
 
 var defaultAudioCodecs = []CodecDesc{
 	{PayloadType: 0, Name: "PCMU", SampleRate: 8000},
 	{PayloadType: 8, Name: "PCMA", SampleRate: 8000},
+	{PayloadType: 9, Name: "G722", SampleRate: 8000},
+	{PayloadType: 96, Name: "L16", SampleRate: 16000},
 	{PayloadType: 101, Name: CodecTelephoneEvent, SampleRate: 8000, FormatParams: map[string]string{"0-15": ""}},
+	{PayloadType: 13, Name: CodecCN, SampleRate: 8000},
 }
 
 var codecsMap = map[int]CodecDesc{
 	0:   {PayloadType: 0, Name: "PCMU", SampleRate: 8000},
 	8:   {PayloadType: 8, Name: "PCMA", SampleRate: 8000},
+	9:   {PayloadType: 9, Name: "G722", SampleRate: 8000},
+	11:  {PayloadType: 11, Name: "L16", SampleRate: 44100},
+	13:  {PayloadType: 13, Name: CodecCN, SampleRate: 8000},
 	101: {PayloadType: 101, Name: CodecTelephoneEvent, SampleRate: 8000, FormatParams: map[string]string{"0-15": ""}},
 }
 
@@ -68,8 +80,20 @@ type CodecDesc struct {
 	FormatParams map[string]string
 }
 
+// equal reports whether the codecs are the same, the payload type of a dynamic codec may differ.
 func (c CodecDesc) equal(cd CodecDesc) bool {
-	return c.PayloadType == cd.PayloadType && c.Name == cd.Name && c.SampleRate == cd.SampleRate
+	if c.PayloadType < 96 && c.PayloadType != cd.PayloadType {
+		return false
+	}
+	return strings.EqualFold(c.Name, cd.Name) && c.SampleRate == cd.SampleRate
+}
+
+// AudioRate returns the sample rate of the audio, which differs from the RTP clock rate for G.722, see RFC 3551 section 4.5.2.
+func (c CodecDesc) AudioRate() int {
+	if strings.EqualFold(c.Name, "G722") {
+		return 16000
+	}
+	return c.SampleRate
 }
 
 type MediaDesc struct {
@@ -79,6 +103,16 @@ type MediaDesc struct {
 	Direction Direction
 	Ptime     int
 	Codecs    []CodecDesc
+	// RTCPPort the port in the SDP rtcp attribute
+	// Default: Port+1
+	RTCPPort int
+	// RTCPHost the connection-address in the SDP rtcp attribute
+	// Default: Host
+	RTCPHost string
+	// RTCPMux multiplexing RTP and RTCP on a single port, see RFC 5761
+	RTCPMux bool
+	// Crypto the SDES crypto attributes, the media is sent as RTP/SAVP if not empty
+	Crypto []CryptoDesc
 }
 
 // Desc SDP
@@ -136,24 +170,31 @@ func parseSDP(raw []byte) (Desc, error) {
 			}
 			desc.AudioDesc.Port = md.MediaName.Port.Value
 
+			var payloadTypes []int
+			rtpmaps := make(map[int]CodecDesc)
+			fmtps := make(map[int]map[string]string)
 			for _, f := range md.MediaName.Formats {
 				pt, err := strconv.Atoi(f)
 				if err != nil {
 					return Desc{}, fmt.Errorf("invalid format: %s", f)
 				}
-				codec, ok := codecsMap[pt]
-				if !ok {
-					continue
-				}
-				desc.AudioDesc.Codecs = append(desc.AudioDesc.Codecs, codec)
+				payloadTypes = append(payloadTypes, pt)
 			}
 
 			for _, a := range md.Attributes {
 				switch a.Key {
 				case "rtpmap":
-					// TODO: parse rtpmap
+					codec, err := parseRtpmap(a.Value)
+					if err != nil {
+						return Desc{}, err
+					}
+					rtpmaps[codec.PayloadType] = codec
 				case "fmtp":
-					// TODO: parse fmtp
+					pt, params, err := parseFmtp(a.Value)
+					if err != nil {
+						return Desc{}, err
+					}
+					fmtps[pt] = params
 				case string(DirectionSendonly), string(DirectionRecvonly), string(DirectionSendrecv), string(DirectionInactive):
 					desc.AudioDesc.Direction = Direction(a.Key)
 				case "ptime":
@@ -162,7 +203,46 @@ func parseSDP(raw []byte) (Desc, error) {
 						return Desc{}, fmt.Errorf("invalid ptime: %s", a.Value)
 					}
 					desc.AudioDesc.Ptime = got
+				case "rtcp":
+					// port [nettype addrtype connection-address], see RFC 3605
+					fields := strings.Fields(a.Value)
+					if len(fields) == 0 {
+						return Desc{}, fmt.Errorf("invalid rtcp: %s", a.Value)
+					}
+					got, err := strconv.Atoi(fields[0])
+					if err != nil {
+						return Desc{}, fmt.Errorf("invalid rtcp: %s", a.Value)
+					}
+					desc.AudioDesc.RTCPPort = got
+					if len(fields) == 4 {
+						desc.AudioDesc.RTCPHost = fields[3]
+					}
+				case "rtcp-mux":
+					desc.AudioDesc.RTCPMux = true
+				case "crypto":
+					if strings.Join(md.MediaName.Protos, "/") != ProtoSRTP {
+						continue
+					}
+					crypto, err := parseCrypto(a.Value)
+					if err != nil {
+						return Desc{}, err
+					}
+					desc.AudioDesc.Crypto = append(desc.AudioDesc.Crypto, crypto)
+				}
+			}
+
+			for _, pt := range payloadTypes {
+				codec, ok := rtpmaps[pt]
+				if !ok {
+					codec, ok = codecsMap[pt]
 				}
+				if !ok || codec.Name == "" {
+					continue
+				}
+				if params, ok := fmtps[pt]; ok {
+					codec.FormatParams = params
+				}
+				desc.AudioDesc.Codecs = append(desc.AudioDesc.Codecs, codec)
 			}
 		}
 	}
@@ -170,6 +250,57 @@ func parseSDP(raw []byte) (Desc, error) {
 	return desc, nil
 }
 
+// parseRtpmap parses "<payload type> <encoding name>/<clock rate>[/<channels>]"
+func parseRtpmap(raw string) (CodecDesc, error) {
+	pt, encoding, ok := strings.Cut(raw, " ")
+	if !ok {
+		return CodecDesc{}, fmt.Errorf("invalid rtpmap: %s", raw)
+	}
+	payloadType, err := strconv.Atoi(pt)
+	if err != nil {
+		return CodecDesc{}, fmt.Errorf("invalid rtpmap: %s", raw)
+	}
+	parts := strings.Split(strings.TrimSpace(encoding), "/")
+	if len(parts) < 2 {
+		return CodecDesc{}, fmt.Errorf("invalid rtpmap: %s", raw)
+	}
+	rate, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return CodecDesc{}, fmt.Errorf("invalid rtpmap: %s", raw)
+	}
+	codec := CodecDesc{PayloadType: payloadType, Name: parts[0], SampleRate: rate}
+	if static, ok := codecsMap[payloadType]; ok && strings.EqualFold(static.Name, codec.Name) {
+		codec.Name = static.Name
+	}
+	if len(parts) > 2 && parts[2] != "1" {
+		// only mono audio is supported
+		codec.Name = ""
+	}
+	return codec, nil
+}
+
+// parseFmtp parses "<payload type> <format specific parameters>"
+func parseFmtp(raw string) (int, map[string]string, error) {
+	pt, value, ok := strings.Cut(raw, " ")
+	if !ok {
+		return 0, nil, fmt.Errorf("invalid fmtp: %s", raw)
+	}
+	payloadType, err := strconv.Atoi(pt)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid fmtp: %s", raw)
+	}
+	params := make(map[string]string)
+	for _, param := range strings.Split(value, ";") {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(param, "=")
+		params[k] = v
+	}
+	return payloadType, params, nil
+}
+
 func (d Desc) generateSDP() ([]byte, error) {
 	sd := sdp.SessionDescription{
 		Version: 0,
@@ -230,6 +361,15 @@ func (d Desc) generateSDP() ([]byte, error) {
 	}
 
 	audio := sd.MediaDescriptions[1]
+	if len(d.AudioDesc.Crypto) > 0 {
+		audio.MediaName.Protos = strings.Split(ProtoSRTP, "/")
+		for _, crypto := range d.AudioDesc.Crypto {
+			audio.Attributes = append(audio.Attributes, sdp.Attribute{Key: "crypto", Value: crypto.String()})
+		}
+	}
+	if d.AudioDesc.RTCPMux {
+		audio.Attributes = append(audio.Attributes, sdp.Attribute{Key: "rtcp-mux"})
+	}
 	for _, codec := range d.AudioDesc.Codecs {
 		pt := strconv.Itoa(codec.PayloadType)
 		audio.MediaName.Formats = append(audio.MediaName.Formats, pt)
@@ -257,6 +397,8 @@ type DialogHandlerFunc struct {
 	OnMediaOpenFunc   func(media *Media) MediaHandler
 	OnChannelOpenFunc func(channel *Channel) ChannelHandler
 	OnCloseFunc       func()
+	// OnTimeoutFunc see TimeoutHandler
+	OnTimeoutFunc func(timeout Timeout)
 }
 
 func (h DialogHandlerFunc) OnMediaOpen(media *Media) MediaHandler {
@@ -278,3 +420,9 @@ func (h DialogHandlerFunc) OnClose() {
 		h.OnCloseFunc()
 	}
 }
+
+func (h DialogHandlerFunc) OnTimeout(timeout Timeout) {
+	if h.OnTimeoutFunc != nil {
+		h.OnTimeoutFunc(timeout)
+	}
+}
@@ -2,11 +2,15 @@ package mrcp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
 	"github.com/hateeyan/go-mrcp/pkg"
 	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type DialogClientOptionFunc func(d *DialogClient)
@@ -17,18 +21,78 @@ func WithAudioCodecs(codecs []CodecDesc) DialogClientOptionFunc {
 	}
 }
 
+// WithRTCPMux offers multiplexing RTP and RTCP on a single port.
+func WithRTCPMux() DialogClientOptionFunc {
+	return func(d *DialogClient) {
+		d.ldesc.AudioDesc.RTCPMux = true
+	}
+}
+
+// WithSRTP offers RTP/SAVP with SDES keys of the profiles in order of preference,
+// the dial fails if the server answers unencrypted media.
+// Default: AES_CM_128_HMAC_SHA1_80
+func WithSRTP(profiles ...SRTPProfile) DialogClientOptionFunc {
+	if len(profiles) == 0 {
+		profiles = []SRTPProfile{SRTPAESCM128HMACSHA180}
+	}
+	return func(d *DialogClient) {
+		d.srtpProfiles = profiles
+	}
+}
+
+// WithCredentials authenticates the INVITE of the dial on a 401 or 407 challenge,
+// it overrides Client.Username and Client.Password.
+func WithCredentials(username, password string) DialogClientOptionFunc {
+	return func(d *DialogClient) {
+		d.username = username
+		d.password = password
+	}
+}
+
+// WithRequestUser sets the user of the request-URI of the INVITE, e.g. speechrecog for sip:speechrecog@host.
+func WithRequestUser(user string) DialogClientOptionFunc {
+	return func(d *DialogClient) {
+		d.requestUser = user
+	}
+}
+
+// WithHeader adds a header to the INVITE, e.g. X-Tenant.
+func WithHeader(name, value string) DialogClientOptionFunc {
+	return func(d *DialogClient) {
+		d.headers = append(d.headers, sip.NewHeader(name, value))
+	}
+}
+
+// WithFromDisplayName sets the display name of the From header of the INVITE.
+func WithFromDisplayName(name string) DialogClientOptionFunc {
+	return func(d *DialogClient) {
+		d.fromDisplayName = name
+	}
+}
+
 type DialogClient struct {
-	callId       string
-	ldesc, rdesc Desc
-	sc           *Client
-	channel      *Channel
-	media        *Media
-	session      *sipgo.DialogClientSession
-	handler      DialogHandler
-	ctx          context.Context
-	cancel       context.CancelFunc
-	closed       bool
-	logger       *slog.Logger
+	callId          string
+	ldesc, rdesc    Desc
+	srtpProfiles    []SRTPProfile
+	username        string
+	password        string
+	requestUser     string
+	headers         []sip.Header
+	fromDisplayName string
+	sc              *Client
+	channel         *Channel
+	media           *Media
+	session         *sipgo.DialogClientSession
+	handler         DialogHandler
+	timer           sessionTimer
+	onClose         func()
+	provisional     atomic.Bool
+	sdpErr          error
+	ctx             context.Context
+	cancel          context.CancelFunc
+	mu              sync.Mutex
+	closed          bool
+	logger          *slog.Logger
 }
 
 func (c *Client) newDialog(resource Resource, handler DialogHandler, opts ...DialogClientOptionFunc) (*DialogClient, error) {
@@ -47,7 +111,7 @@ func (c *Client) newDialog(resource Resource, handler DialogHandler, opts ...Dia
 	}
 
 	switch resource {
-	case ResourceSpeechrecog:
+	case ResourceSpeechrecog, ResourceRecorder:
 		audioDesc.Direction = DirectionSendonly
 	case ResourceSpeechsynth:
 		audioDesc.Direction = DirectionRecvonly
@@ -70,60 +134,134 @@ func (c *Client) newDialog(resource Resource, handler DialogHandler, opts ...Dia
 			AudioDesc:   audioDesc,
 			ControlDesc: controlDesc,
 		},
-		sc:      c,
-		handler: handler,
-		logger:  c.Logger.With("callId", callId),
+		username: c.Username,
+		password: c.Password,
+		sc:       c,
+		handler:  handler,
+		logger:   c.Logger.With("callId", callId),
 	}
 
 	for _, fn := range opts {
 		fn(d)
 	}
 
+	d.timer.onRefresh = d.refreshSession
+	d.timer.onExpire = d.onSessionExpired
 	d.ctx, d.cancel = context.WithCancel(context.Background())
 	c.dialogs.Store(d.callId, d)
 	return d, nil
 }
 
-func (d *DialogClient) invite(raddr string) error {
+func (d *DialogClient) invite(ctx context.Context, raddr string) error {
 	rhost, rport, err := sip.ParseAddr(raddr)
 	if err != nil {
 		return err
 	}
 
+	d.ldesc.AudioDesc.Crypto = nil
+	for i, profile := range d.srtpProfiles {
+		crypto, err := newCryptoDesc(i+1, profile)
+		if err != nil {
+			return err
+		}
+		d.ldesc.AudioDesc.Crypto = append(d.ldesc.AudioDesc.Crypto, crypto)
+	}
+
 	localSDP, err := d.ldesc.generateSDP()
 	if err != nil {
 		return err
 	}
 
-	recipient := sip.Uri{Host: rhost, Port: rport}
-	d.session, err = d.sc.ua.Invite(
-		d.ctx,
-		recipient,
-		localSDP,
-		&sip.FromHeader{
-			Address: d.sc.ua.ContactHDR.Address,
-			Params:  sip.HeaderParams{"tag": pkg.RandString(5)},
-		},
-		&sip.ToHeader{Address: recipient},
-		sip.NewHeader("Call-ID", d.callId),
-		sip.NewHeader("Content-Type", "application/sdp"),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to send sip invite: %v", err)
-	}
-	d.session.OnState(d.handleState)
+	recipient := sip.Uri{User: d.requestUser, Host: rhost, Port: rport}
+	tag := pkg.RandString(5)
+	interval, minSE := d.sc.SessionExpires, d.sc.MinSE
+	for cseq := uint32(1); ; cseq++ {
+		headers := d.inviteHeaders(recipient, tag, cseq, interval, minSE)
+		d.provisional.Store(false)
+		d.session, err = d.sc.ua.Invite(ctx, recipient, localSDP, headers...)
+		if err != nil {
+			return fmt.Errorf("failed to send sip invite: %w", err)
+		}
+		d.session.OnState(d.handleState)
 
-	if err := d.session.WaitAnswer(d.ctx, sipgo.AnswerOptions{OnResponse: d.onResponse}); err != nil {
-		return fmt.Errorf("failed to wait for answer: %v", err)
+		answerCtx, stop := d.answerContext(ctx)
+		err = d.session.WaitAnswer(answerCtx, sipgo.AnswerOptions{
+			OnResponse: d.onResponse,
+			Username:   d.username,
+			Password:   d.password,
+		})
+		stop()
+		var rejected *sipgo.ErrDialogResponse
+		if errors.As(err, &rejected) && rejected.Res.StatusCode == statusSessionIntervalTooSmall && cseq == 1 {
+			if m := getMinSE(rejected.Res); m > interval {
+				// retry with the minimum interval of the server
+				d.logger.Info("session interval too small", "interval", interval, "minSE", m)
+				_ = d.session.Close()
+				interval, minSE = m, max(minSE, m)
+				continue
+			}
+		}
+		if err != nil {
+			switch {
+			case ctx.Err() != nil:
+				return &DialTimeoutError{Err: ctx.Err()}
+			case rejected != nil:
+				return &DialRejectedError{
+					StatusCode: int(rejected.Res.StatusCode),
+					Reason:     rejected.Res.Reason,
+					Response:   rejected.Res,
+				}
+			case errors.Is(err, sip.ErrTransactionTimeout):
+				return &DialTimeoutError{Err: err}
+			}
+			return fmt.Errorf("failed to wait for answer: %w", err)
+		}
+		break
 	}
 
 	if err := d.session.Ack(d.ctx); err != nil {
 		return fmt.Errorf("failed to send ack: %v", err)
 	}
+	if d.sdpErr != nil {
+		return &SDPNegotiationError{Err: d.sdpErr}
+	}
+
+	se, ok := getSessionExpires(d.session.InviteResponse)
+	if !ok && interval > 0 {
+		// a server without session timers leaves the refresh to us
+		se = sessionExpires{interval: interval, refresher: refresherUAC}
+	}
+	d.timer.reset(se, se.refresher != refresherUAS)
 
 	return nil
 }
 
+// inviteHeaders returns the headers of an INVITE, interval is the requested session interval, 0 if none.
+func (d *DialogClient) inviteHeaders(recipient sip.Uri, tag string, cseq uint32, interval, minSE time.Duration) []sip.Header {
+	headers := []sip.Header{
+		&sip.FromHeader{
+			DisplayName: d.fromDisplayName,
+			Address:     d.sc.ua.ContactHDR.Address,
+			Params:      sip.HeaderParams{"tag": tag},
+		},
+		&sip.ToHeader{Address: recipient},
+		sip.NewHeader("Call-ID", d.callId),
+		&sip.CSeqHeader{SeqNo: cseq, MethodName: sip.INVITE},
+		sip.NewHeader("Content-Type", "application/sdp"),
+		sip.NewHeader("Supported", "timer"),
+	}
+	if interval > 0 {
+		headers = append(headers,
+			sip.NewHeader(headerSessionExpires, formatSeconds(interval)),
+			sip.NewHeader(headerMinSE, formatSeconds(minSE)),
+		)
+	}
+	for _, h := range d.headers {
+		headers = append(headers, sip.HeaderClone(h))
+	}
+	return headers
+}
+
 func (d *DialogClient) handleState(s sip.DialogState) {
 	switch s {
 	case sip.DialogStateEnded:
@@ -139,30 +277,129 @@ func (d *DialogClient) onBye(req *sip.Request, tx sip.ServerTransaction) error {
 	return d.session.ReadBye(req, tx)
 }
 
-func (d *DialogClient) onResponse(res *sip.Response) error {
-	switch res.StatusCode {
-	case sip.StatusOK:
-		var err error
-		d.rdesc, err = parseSDP(res.Body())
+// onRefresh handles session refreshes of the server, a re-INVITE is answered with the local SDP.
+func (d *DialogClient) onRefresh(req *sip.Request, tx sip.ServerTransaction) error {
+	if err := d.session.ReadRequest(req, tx); err != nil {
+		return err
+	}
+
+	se, err := negotiateSessionTimer(req, d.sc.SessionExpires, d.sc.MinSE)
+	if err != nil {
+		if err := respondSessionIntervalTooSmall(req, tx, d.sc.MinSE); err != nil {
+			d.logger.Error("failed to respond 422 session interval too small", "error", err)
+		}
+		return err
+	}
+	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	if req.IsInvite() {
+		localSDP, err := d.ldesc.generateSDP()
 		if err != nil {
-			d.logger.Error("failed to parse SDP", "error", err)
-			d.cancel()
-			return nil
+			res = sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil)
+			if err := tx.Respond(res); err != nil {
+				d.logger.Error("failed to respond 500 internal server error", "error", err)
+			}
+			return err
+		}
+		res = sip.NewSDPResponseFromRequest(req, localSDP)
+	}
+	for _, h := range sessionTimerHeaders(se) {
+		res.AppendHeader(h)
+	}
+	if err := tx.Respond(res); err != nil {
+		return fmt.Errorf("failed to respond 200 ok: %v", err)
+	}
+	d.timer.reset(se, se.refresher == refresherUAS)
+	return nil
+}
+
+// refreshSession sends a session refresh request when we are the refresher.
+func (d *DialogClient) refreshSession() {
+	sdp, err := d.ldesc.generateSDP()
+	if err != nil {
+		d.logger.Error("failed to generate SDP", "error", err)
+		return
+	}
+	target := d.session.InviteRequest.Recipient
+	if contact := d.session.InviteResponse.Contact(); contact != nil {
+		target = contact.Address
+	}
+	se, refresher, err := refreshSession(
+		d.ctx,
+		d.session,
+		d.sc.SessionRefreshMethod,
+		target,
+		d.session.InviteRequest.Transport(),
+		sdp,
+		d.timer.sessionExpires().interval,
+		d.sc.MinSE,
+	)
+	if err != nil {
+		if d.ctx.Err() != nil {
+			return
+		}
+		d.logger.Error("failed to refresh session", "error", err)
+		if err := d.Close(); err != nil {
+			d.logger.Error("failed to close dialog client", "error", err)
+		}
+		return
+	}
+	d.timer.reset(se, refresher)
+}
+
+// onSessionExpired closes the dialog when the server did not refresh the session in time.
+func (d *DialogClient) onSessionExpired() {
+	d.logger.Warn("session expired", "interval", d.timer.sessionExpires().interval)
+	if err := d.Close(); err != nil {
+		d.logger.Error("failed to close dialog client", "error", err)
+	}
+}
+
+// answerContext returns the context of waiting for the answer to the INVITE, done with ctx or the dialog.
+// The INVITE is cancelled if the server has answered provisionally, otherwise it is abandoned.
+func (d *DialogClient) answerContext(ctx context.Context) (context.Context, func()) {
+	answerCtx, cancel := context.WithCancelCause(context.Background())
+	done := func() {
+		if d.provisional.Load() {
+			cancel(context.Canceled)
+		} else {
+			cancel(sipgo.WaitAnswerForceCancelErr)
 		}
 	}
+	stopCtx := context.AfterFunc(ctx, done)
+	stopDialog := context.AfterFunc(d.ctx, done)
+	return answerCtx, func() {
+		stopCtx()
+		stopDialog()
+		cancel(nil)
+	}
+}
+
+func (d *DialogClient) onResponse(res *sip.Response) error {
+	switch {
+	case res.IsProvisional():
+		d.provisional.Store(true)
+	case res.StatusCode == sip.StatusOK:
+		// the dialog is acknowledged before it fails
+		d.rdesc, d.sdpErr = parseSDP(res.Body())
+	}
 	return nil
 }
 
 func (d *DialogClient) GetChannel() *Channel { return d.channel }
+func (d *DialogClient) GetMedia() *Media     { return d.media }
 func (d *DialogClient) GetLocalDesc() *Desc  { return &d.ldesc }
 func (d *DialogClient) GetRemoteDesc() *Desc { return &d.rdesc }
 
 func (d *DialogClient) Close() error {
+	d.mu.Lock()
 	if d.closed {
+		d.mu.Unlock()
 		return nil
 	}
 	d.closed = true
+	d.mu.Unlock()
 
+	d.timer.stop()
 	d.cancel()
 	_ = d.media.Close()
 	_ = d.channel.Close()
@@ -176,6 +413,9 @@ func (d *DialogClient) Close() error {
 	}
 	d.sc.porter.free(uint16(d.ldesc.AudioDesc.Port))
 	d.sc.dialogs.Delete(d.callId)
+	if d.onClose != nil {
+		d.onClose()
+	}
 
 	d.logger.Info("close dialog")
 	if d.handler != nil {
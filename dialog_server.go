@@ -2,12 +2,18 @@ package mrcp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 )
 
+// ErrInviteCanceled the INVITE of the dialog was cancelled by the client
+var ErrInviteCanceled = errors.New("INVITE canceled")
+
 type DialogServer struct {
 	callId       string
 	ldesc, rdesc Desc
@@ -16,10 +22,17 @@ type DialogServer struct {
 	media        *Media
 	session      *sipgo.DialogServerSession
 	handler      DialogHandler
-	ctx          context.Context
-	cancel       context.CancelFunc
-	closed       bool
-	logger       *slog.Logger
+	// admitted the dialog is counted by the admission control of the server
+	admitted bool
+	resource Resource
+	source   string
+	ctx      context.Context
+	cancel   context.CancelFunc
+	canceled atomic.Bool
+	timer    sessionTimer
+	mu       sync.Mutex
+	closed   bool
+	logger   *slog.Logger
 }
 
 func (s *Server) newDialog(session *sipgo.DialogServerSession) *DialogServer {
@@ -46,6 +59,8 @@ func (s *Server) newDialog(session *sipgo.DialogServerSession) *DialogServer {
 		session: session,
 		logger:  s.Logger.With("callId", callId),
 	}
+	d.timer.onRefresh = d.refreshSession
+	d.timer.onExpire = func() { d.onTimeout(TimeoutSession) }
 	d.ctx, d.cancel = context.WithCancel(context.Background())
 	s.dialogs.Store(callId, d)
 	return d
@@ -53,38 +68,54 @@ func (s *Server) newDialog(session *sipgo.DialogServerSession) *DialogServer {
 
 func (d *DialogServer) onInvite(req *sip.Request, tx sip.ServerTransaction) error {
 	d.session.OnState(d.handleState)
+	if stx, ok := tx.(*sip.ServerTx); ok {
+		stx.OnCancel(d.onCancel)
+	}
 	if err := d.session.Respond(sip.StatusTrying, "Trying", nil); err != nil {
 		return fmt.Errorf("failed to respond 100 trying: %v", err)
 	}
 
 	rdesc, err := parseSDP(req.Body())
 	if err != nil {
-		if err := d.session.Respond(sip.StatusInternalServerError, "Internal Server Error", nil); err != nil {
-			d.logger.Error("failed to respond 500 internal server error", "error", err)
-		}
+		d.reject(&RejectError{StatusCode: int(sip.StatusBadRequest), Reason: "Bad Request"})
 		return fmt.Errorf("failed to parse sdp: %v", err)
 	}
 	d.rdesc = rdesc
 
+	se, err := negotiateSessionTimer(req, d.ss.SessionExpires, d.ss.MinSE)
+	if err != nil {
+		if err := respondSessionIntervalTooSmall(req, tx, d.ss.MinSE); err != nil {
+			d.logger.Error("failed to respond 422 session interval too small", "error", err)
+		}
+		return err
+	}
+
 	switch rdesc.ControlDesc.Resource {
-	case ResourceSpeechrecog:
+	case ResourceSpeechrecog, ResourceRecorder:
 		d.ldesc.AudioDesc.Direction = DirectionRecvonly
 	case ResourceSpeechsynth:
 		d.ldesc.AudioDesc.Direction = DirectionSendonly
 	default:
-		if err := d.session.Respond(sip.StatusInternalServerError, "Internal Server Error", nil); err != nil {
-			d.logger.Error("failed to respond 500 internal server error", "error", err)
-		}
-		return fmt.Errorf("unsupported resource type: %s", rdesc.ControlDesc.Resource)
+		err := fmt.Errorf("%w: %s", errUnsupportedResource, rdesc.ControlDesc.Resource)
+		d.reject(err)
+		return err
 	}
 
+	d.resource, d.source = rdesc.ControlDesc.Resource, sourceIP(req)
+	if err := d.ss.admit(d.resource, d.source); err != nil {
+		d.reject(err)
+		return err
+	}
+	d.admitted = true
+
 	if d.ss.Handler != nil {
 		d.handler, err = d.ss.Handler.OnDialogCreate(d)
+		if d.canceled.Load() {
+			return ErrInviteCanceled
+		}
 		if err != nil {
-			if err := d.session.Respond(sip.StatusInternalServerError, "Internal Server Error", nil); err != nil {
-				d.logger.Error("failed to respond 500 internal server error", "error", err)
-			}
-			return fmt.Errorf("OnDialogCreate callback failed: %v", err)
+			d.reject(err)
+			return fmt.Errorf("OnDialogCreate callback failed: %w", err)
 		}
 	}
 	d.newChannel()
@@ -92,33 +123,54 @@ func (d *DialogServer) onInvite(req *sip.Request, tx sip.ServerTransaction) erro
 
 	port, err := d.ss.porter.get()
 	if err != nil {
-		if err := d.session.Respond(sip.StatusInternalServerError, "Internal Server Error", nil); err != nil {
-			d.logger.Error("failed to respond 500 internal server error", "error", err)
-		}
+		d.reject(err)
 		return err
 	}
 	d.ldesc.AudioDesc.Port = int(port)
 
 	if err := d.newMedia(); err != nil {
-		if err := d.session.Respond(sip.StatusInternalServerError, "Internal Server Error", nil); err != nil {
-			d.logger.Error("failed to respond 500 internal server error", "error", err)
-		}
+		d.reject(err)
 		return err
 	}
 	localSDP, err := d.ldesc.generateSDP()
 	if err != nil {
-		if err := d.session.Respond(sip.StatusInternalServerError, "Internal Server Error", nil); err != nil {
-			d.logger.Error("failed to respond 500 internal server error", "error", err)
-		}
+		d.reject(err)
 		return err
 	}
-	if err := d.session.RespondSDP(localSDP); err != nil {
+	if d.canceled.Load() {
+		return ErrInviteCanceled
+	}
+	headers := append([]sip.Header{sip.NewHeader("Content-Type", "application/sdp")}, sessionTimerHeaders(se)...)
+	if err := d.session.Respond(sip.StatusOK, "OK", localSDP, headers...); err != nil {
+		if d.canceled.Load() {
+			return ErrInviteCanceled
+		}
 		return fmt.Errorf("failed to respond 200 ok: %v", err)
 	}
+	d.startWatchChannel()
+	d.timer.reset(se, se.refresher == refresherUAS)
 
 	return nil
 }
 
+// onCancel aborts the creation of the dialog, the transaction responds 487 to the INVITE.
+func (d *DialogServer) onCancel(*sip.Request) {
+	d.canceled.Store(true)
+	d.cancel()
+}
+
+// RespondProvisional sends a provisional response to the INVITE, e.g. 180 Ringing or
+// 183 Session Progress, it may be called by OnDialogCreate while it works.
+func (d *DialogServer) RespondProvisional(statusCode sip.StatusCode, reason string, headers ...sip.Header) error {
+	if statusCode <= sip.StatusTrying || statusCode >= sip.StatusOK {
+		return fmt.Errorf("invalid provisional status code: %d", statusCode)
+	}
+	if d.canceled.Load() {
+		return ErrInviteCanceled
+	}
+	return d.session.Respond(statusCode, reason, nil, headers...)
+}
+
 // TODO: support modify descs
 func (d *DialogServer) onReInvite(req *sip.Request, tx sip.ServerTransaction) error {
 	if err := d.session.ReadRequest(req, tx); err != nil {
@@ -140,6 +192,14 @@ func (d *DialogServer) onReInvite(req *sip.Request, tx sip.ServerTransaction) er
 	}
 	d.rdesc = rdesc
 
+	se, err := negotiateSessionTimer(req, d.ss.SessionExpires, d.ss.MinSE)
+	if err != nil {
+		if err := respondSessionIntervalTooSmall(req, tx, d.ss.MinSE); err != nil {
+			d.logger.Error("failed to respond 422 session interval too small", "error", err)
+		}
+		return err
+	}
+
 	if rdesc.ControlDesc.Port == 0 {
 		d.ldesc.ControlDesc.Port = 0
 		_ = d.channel.Close()
@@ -159,12 +219,69 @@ func (d *DialogServer) onReInvite(req *sip.Request, tx sip.ServerTransaction) er
 		return err
 	}
 	res = sip.NewSDPResponseFromRequest(req, localSDP)
+	for _, h := range sessionTimerHeaders(se) {
+		res.AppendHeader(h)
+	}
+	if err := tx.Respond(res); err != nil {
+		return fmt.Errorf("failed to respond 200 ok: %v", err)
+	}
+	d.timer.reset(se, se.refresher == refresherUAS)
+	return nil
+}
+
+// onUpdate handles session refreshes.
+func (d *DialogServer) onUpdate(req *sip.Request, tx sip.ServerTransaction) error {
+	if err := d.session.ReadRequest(req, tx); err != nil {
+		return err
+	}
+
+	se, err := negotiateSessionTimer(req, d.ss.SessionExpires, d.ss.MinSE)
+	if err != nil {
+		if err := respondSessionIntervalTooSmall(req, tx, d.ss.MinSE); err != nil {
+			d.logger.Error("failed to respond 422 session interval too small", "error", err)
+		}
+		return err
+	}
+	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	for _, h := range sessionTimerHeaders(se) {
+		res.AppendHeader(h)
+	}
 	if err := tx.Respond(res); err != nil {
 		return fmt.Errorf("failed to respond 200 ok: %v", err)
 	}
+	d.timer.reset(se, se.refresher == refresherUAS)
 	return nil
 }
 
+// refreshSession sends a session refresh request when we are the refresher.
+func (d *DialogServer) refreshSession() {
+	sdp, err := d.ldesc.generateSDP()
+	if err != nil {
+		d.logger.Error("failed to generate SDP", "error", err)
+		return
+	}
+	target := d.session.InviteRequest.Contact().Address
+	se, refresher, err := refreshSession(
+		d.ctx,
+		d.session,
+		d.ss.SessionRefreshMethod,
+		target,
+		d.session.InviteRequest.Transport(),
+		sdp,
+		d.timer.sessionExpires().interval,
+		d.ss.MinSE,
+	)
+	if err != nil {
+		if d.ctx.Err() != nil {
+			return
+		}
+		d.logger.Error("failed to refresh session", "error", err)
+		d.onTimeout(TimeoutSession)
+		return
+	}
+	d.timer.reset(se, refresher)
+}
+
 func (d *DialogServer) onBye(req *sip.Request, tx sip.ServerTransaction) error {
 	if err := d.session.ReadBye(req, tx); err != nil {
 		return err
@@ -184,16 +301,48 @@ func (d *DialogServer) handleState(s sip.DialogState) {
 }
 
 func (d *DialogServer) GetChannel() *Channel  { return d.channel }
+func (d *DialogServer) GetMedia() *Media      { return d.media }
 func (d *DialogServer) GetLocalDesc() *Desc   { return &d.ldesc }
 func (d *DialogServer) GetRemoteDesc() *Desc  { return &d.rdesc }
 func (d *DialogServer) GetResource() Resource { return d.rdesc.ControlDesc.Resource }
 
+// Context returns a context done when the INVITE is cancelled or the dialog is closed,
+// OnDialogCreate should abort its work when it is done.
+func (d *DialogServer) Context() context.Context { return d.ctx }
+
+// GetInviteRequest returns the INVITE which created the dialog.
+func (d *DialogServer) GetInviteRequest() *sip.Request { return d.session.InviteRequest }
+
+// GetRequestURI returns the request-URI of the INVITE, e.g. sip:speechrecog@host.
+func (d *DialogServer) GetRequestURI() sip.Uri { return d.session.InviteRequest.Recipient }
+
+// GetSIPHeader returns the value of a header of the INVITE, empty if absent.
+func (d *DialogServer) GetSIPHeader(name string) string {
+	if h := d.session.InviteRequest.GetHeader(name); h != nil {
+		return h.Value()
+	}
+	return ""
+}
+
+// GetSIPHeaders returns the values of a header of the INVITE.
+func (d *DialogServer) GetSIPHeaders(name string) []string {
+	var values []string
+	for _, h := range d.session.InviteRequest.GetHeaders(name) {
+		values = append(values, h.Value())
+	}
+	return values
+}
+
 func (d *DialogServer) Close() error {
+	d.mu.Lock()
 	if d.closed {
+		d.mu.Unlock()
 		return nil
 	}
 	d.closed = true
+	d.mu.Unlock()
 
+	d.timer.stop()
 	d.cancel()
 	_ = d.media.Close()
 	if d.session != nil {
@@ -205,6 +354,9 @@ func (d *DialogServer) Close() error {
 		_ = d.session.Close()
 	}
 	d.ss.porter.free(uint16(d.ldesc.AudioDesc.Port))
+	if d.admitted {
+		d.ss.release(d.resource, d.source)
+	}
 	d.ss.dialogs.Delete(d.callId)
 	if d.channel != nil {
 		_ = d.channel.Close()
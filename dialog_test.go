@@ -1,7 +1,13 @@
 package mrcp
 
 import (
+	"context"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"log/slog"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -45,6 +51,57 @@ func Test_parseSDP(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "wideband",
+			args: args{raw: []byte("v=0\r\no=- 0 0 IN IP4 10.29.0.87\r\ns=-\r\nc=IN IP4 10.29.0.87\r\nt=0 0\r\nm=application 7230 TCP/MRCPv2 1\r\na=setup:passive\r\na=connection:new\r\nm=audio 22836 RTP/AVP 97 9 98 0 100\r\na=rtpmap:97 L16/16000\r\na=rtpmap:9 G722/8000\r\na=rtpmap:98 L16/16000/2\r\na=rtpmap:100 telephone-event/8000\r\na=fmtp:100 0-16\r\na=sendrecv\r\n")},
+			want: Desc{
+				UserAgent: "-",
+				Host:      "10.29.0.87",
+				AudioDesc: MediaDesc{
+					Host:      "10.29.0.87",
+					Port:      22836,
+					Direction: DirectionSendrecv,
+					Codecs: []CodecDesc{
+						{PayloadType: 97, Name: "L16", SampleRate: 16000},
+						{PayloadType: 9, Name: "G722", SampleRate: 8000},
+						{PayloadType: 0, Name: "PCMU", SampleRate: 8000},
+						{PayloadType: 100, Name: "telephone-event", SampleRate: 8000, FormatParams: map[string]string{"0-16": ""}},
+					},
+				},
+				ControlDesc: ControlDesc{
+					Host:           "10.29.0.87",
+					Port:           7230,
+					Proto:          ProtoTCP,
+					SetupType:      SetupPassive,
+					ConnectionType: ConnectionNew,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "rtcp address",
+			args: args{raw: []byte("v=0\r\no=- 0 0 IN IP4 10.29.0.87\r\ns=-\r\nc=IN IP4 10.29.0.87\r\nt=0 0\r\nm=application 7230 TCP/MRCPv2 1\r\na=setup:passive\r\na=connection:new\r\nm=audio 22836 RTP/AVP 0\r\na=rtcp:53020 IN IP4 126.16.64.4\r\na=sendonly\r\n")},
+			want: Desc{
+				UserAgent: "-",
+				Host:      "10.29.0.87",
+				AudioDesc: MediaDesc{
+					Host:      "10.29.0.87",
+					Port:      22836,
+					Direction: DirectionSendonly,
+					Codecs:    []CodecDesc{{PayloadType: 0, Name: "PCMU", SampleRate: 8000}},
+					RTCPPort:  53020,
+					RTCPHost:  "126.16.64.4",
+				},
+				ControlDesc: ControlDesc{
+					Host:           "10.29.0.87",
+					Port:           7230,
+					Proto:          ProtoTCP,
+					SetupType:      SetupPassive,
+					ConnectionType: ConnectionNew,
+				},
+			},
+			wantErr: false,
+		},
 		{
 			name: "deallocate",
 			args: args{raw: []byte("v=0\r\no=go-mrcp 3033826439310859339 3200628959442406558 IN IP4 10.9.232.246\r\ns=-\r\nc=IN IP4 10.9.232.246\r\nt=0 0\r\nm=application 0 TCP/MRCPv2 1\r\na=inactive\r\nm=audio 0 RTP/AVP 19\r\na=inactive\r\n")},
@@ -139,3 +196,95 @@ func TestDesc_generateSDP(t *testing.T) {
 		})
 	}
 }
+
+func TestDialogClient_inviteHeaders(t *testing.T) {
+	c := &Client{ua: sipgo.DialogUA{ContactHDR: sip.ContactHeader{Address: sip.Uri{User: "go-mrcp", Host: "127.0.0.1", Port: 5060}}}}
+	d := &DialogClient{callId: "abc", sc: c}
+	for _, fn := range []DialogClientOptionFunc{
+		WithRequestUser("speechrecog"),
+		WithHeader("X-Tenant", "acme"),
+		WithHeader("X-Call-Id", "parent"),
+		WithFromDisplayName("IVR"),
+	} {
+		fn(d)
+	}
+
+	req := sip.NewRequest(sip.INVITE, sip.Uri{User: d.requestUser, Host: "127.0.0.2", Port: 5060})
+	for _, h := range d.inviteHeaders(req.Recipient, "tag", 1, 0, defaultMinSE) {
+		req.AppendHeader(h)
+	}
+	want := map[string]string{
+		"From":      "\"IVR\" <sip:go-mrcp@127.0.0.1:5060>;tag=tag",
+		"To":        "<sip:speechrecog@127.0.0.2:5060>",
+		"X-Tenant":  "acme",
+		"X-Call-Id": "parent",
+		"Supported": "timer",
+	}
+	for name, value := range want {
+		if h := req.GetHeader(name); h == nil || h.Value() != value {
+			t.Errorf("inviteHeaders() got %s = %v, want %v", name, h, value)
+		}
+	}
+	if h := req.GetHeader(headerSessionExpires); h != nil {
+		t.Errorf("inviteHeaders() got %s = %v, want %v", headerSessionExpires, h.Value(), nil)
+	}
+}
+
+func TestDialogServer_GetSIPHeader(t *testing.T) {
+	req := sip.NewRequest(sip.INVITE, sip.Uri{User: "speechrecog", Host: "127.0.0.1", Port: 5060})
+	req.AppendHeader(sip.NewHeader("X-Tenant", "acme"))
+	req.AppendHeader(sip.NewHeader("X-Route", "a"))
+	req.AppendHeader(sip.NewHeader("X-Route", "b"))
+	d := &DialogServer{session: &sipgo.DialogServerSession{Dialog: sipgo.Dialog{InviteRequest: req}}}
+
+	if got := d.GetRequestURI().User; got != "speechrecog" {
+		t.Errorf("GetRequestURI() got = %v, want %v", got, "speechrecog")
+	}
+	if got := d.GetSIPHeader("x-tenant"); got != "acme" {
+		t.Errorf("GetSIPHeader() got = %v, want %v", got, "acme")
+	}
+	if got := d.GetSIPHeader("X-Missing"); got != "" {
+		t.Errorf("GetSIPHeader() got = %v, want %v", got, "")
+	}
+	if got := d.GetSIPHeaders("X-Route"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("GetSIPHeaders() got = %v, want %v", got, []string{"a", "b"})
+	}
+}
+
+func TestDialogClient_Close(t *testing.T) {
+	porter, err := newPorter(20000, 20010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := porter.get()
+	c := &Client{porter: porter}
+
+	var closes, released atomic.Int32
+	d := &DialogClient{
+		callId:  "abc",
+		sc:      c,
+		handler: DialogHandlerFunc{OnCloseFunc: func() { closes.Add(1) }},
+		onClose: func() { released.Add(1) },
+		logger:  slog.Default(),
+	}
+	d.ldesc.AudioDesc.Port = int(port)
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	c.dialogs.Store(d.callId, d)
+
+	// closed concurrently by the user, the session timer and the dialog state
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = d.Close()
+		}()
+	}
+	wg.Wait()
+	if closes.Load() != 1 || released.Load() != 1 {
+		t.Errorf("Close() called OnClose %d and onClose %d times, want once", closes.Load(), released.Load())
+	}
+	if got := porter.available(); got != 5 {
+		t.Errorf("available() got = %v, want %v", got, 5)
+	}
+}
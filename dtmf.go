@@ -0,0 +1,280 @@
+package mrcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/hateeyan/go-mrcp/pkg/pcm"
+	"sync"
+	"time"
+)
+
+const (
+	// dtmfVolume the power level of the tone, expressed in dBm0 after dropping the sign
+	dtmfVolume = 10
+	// dtmfEndPackets the number of times the final packet of an event is sent
+	dtmfEndPackets = 3
+	// dtmfMaxDuration the maximum duration of an event expressed in timestamp units
+	dtmfMaxDuration = 0xFFFF
+)
+
+// dtmfEvent converts a DTMF digit to the RFC 4733 event code.
+func dtmfEvent(digit byte) (byte, bool) {
+	switch {
+	case digit >= '0' && digit <= '9':
+		return digit - '0', true
+	case digit == '*':
+		return 10, true
+	case digit == '#':
+		return 11, true
+	case digit >= 'A' && digit <= 'D':
+		return 12 + digit - 'A', true
+	case digit >= 'a' && digit <= 'd':
+		return 12 + digit - 'a', true
+	default:
+		return 0, false
+	}
+}
+
+type dtmfDigit struct {
+	event byte
+	// duration the tone duration in packets
+	duration int
+}
+
+// dtmfSender generates RFC 4733 telephone-event payloads one packet at a time.
+type dtmfSender struct {
+	mu    sync.Mutex
+	queue []dtmfDigit
+	// current the digit being sent
+	current   *dtmfDigit
+	timestamp uint32
+	sent      int
+	endSent   int
+	// pause the remaining packets of the inter-digit pause
+	pause int
+}
+
+func (s *dtmfSender) push(digits []dtmfDigit) {
+	s.mu.Lock()
+	s.queue = append(s.queue, digits...)
+	s.mu.Unlock()
+}
+
+// next returns the telephone-event payload to be sent in place of the audio
+// packet at timestamp, nil if the audio should be sent.
+func (s *dtmfSender) next(timestamp uint32, samples int) (payload []byte, eventTimestamp uint32, marker bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil {
+		if s.pause > 0 {
+			s.pause--
+			return nil, 0, false
+		}
+		if len(s.queue) == 0 {
+			return nil, 0, false
+		}
+		s.current = &s.queue[0]
+		s.queue = s.queue[1:]
+		s.timestamp = timestamp
+		s.sent = 0
+		s.endSent = 0
+		marker = true
+	}
+
+	digit := s.current
+	var end bool
+	if s.sent < digit.duration {
+		s.sent++
+	}
+	if s.sent == digit.duration {
+		end = true
+		s.endSent++
+	}
+
+	duration := s.sent * samples
+	if duration > dtmfMaxDuration {
+		duration = dtmfMaxDuration
+	}
+	payload = make([]byte, 4)
+	payload[0] = digit.event
+	payload[1] = dtmfVolume
+	if end {
+		payload[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(payload[2:], uint16(duration))
+
+	eventTimestamp = s.timestamp
+	if s.endSent == dtmfEndPackets {
+		s.pause = digit.duration
+		s.current = nil
+	}
+	return payload, eventTimestamp, marker
+}
+
+// SendDTMF sends digits as RFC 4733 telephone-events on the outgoing stream,
+// each tone lasting duration and followed by a pause of the same length.
+// The audio read from the MediaHandler is discarded while a tone is being sent.
+// SendDTMF does not wait for the digits to be sent,
+// it fails if the remote did not offer telephone-event.
+func (m *Media) SendDTMF(digits string, duration time.Duration) error {
+	if !m.remoteEvents {
+		return fmt.Errorf("%s is not negotiated", CodecTelephoneEvent)
+	}
+	if m.laudioDesc.Direction != DirectionSendonly && m.laudioDesc.Direction != DirectionSendrecv {
+		return fmt.Errorf("unable to send DTMF on %s media", m.laudioDesc.Direction)
+	}
+
+	ptime := time.Duration(m.ptime()) * time.Millisecond
+	packets := int((duration + ptime - 1) / ptime)
+	if packets < 1 {
+		packets = 1
+	}
+	queue := make([]dtmfDigit, 0, len(digits))
+	for i := 0; i < len(digits); i++ {
+		event, ok := dtmfEvent(digits[i])
+		if !ok {
+			return fmt.Errorf("invalid DTMF digit: %q", digits[i])
+		}
+		queue = append(queue, dtmfDigit{event: event, duration: packets})
+	}
+	m.dtmf.push(queue)
+	return nil
+}
+
+// dtmfDigitOf converts an RFC 4733 event code to a DTMF digit.
+func dtmfDigitOf(event byte) (byte, bool) {
+	const digits = "0123456789*#ABCD"
+	if int(event) >= len(digits) {
+		return 0, false
+	}
+	return digits[event], true
+}
+
+// DTMFSource where a received DTMF digit was detected
+type DTMFSource int
+
+const (
+	// DTMFSourceTelephoneEvent an RFC 4733 telephone-event
+	DTMFSourceTelephoneEvent DTMFSource = iota
+	// DTMFSourceInBand a tone in the audio
+	DTMFSourceInBand
+)
+
+func (s DTMFSource) String() string {
+	switch s {
+	case DTMFSourceTelephoneEvent:
+		return "telephone-event"
+	case DTMFSourceInBand:
+		return "in-band"
+	default:
+		return "unknown"
+	}
+}
+
+// DTMFMode how received DTMF digits are detected
+type DTMFMode int
+
+const (
+	// DTMFModeTelephoneEvent only RFC 4733 telephone-events are reported
+	DTMFModeTelephoneEvent DTMFMode = iota
+	// DTMFModeInBand only in-band tones are reported
+	DTMFModeInBand
+	// DTMFModeAuto telephone-events are reported if negotiated, in-band tones otherwise
+	DTMFModeAuto
+)
+
+// DTMFHandler is notified of the DTMF digits received.
+// OnDTMF is called from the receiving goroutine and must not block.
+type DTMFHandler interface {
+	OnDTMF(m *Media, digit byte, source DTMFSource)
+}
+
+type DTMFHandlerFunc struct {
+	OnDTMFFunc func(m *Media, digit byte, source DTMFSource)
+}
+
+func (h DTMFHandlerFunc) OnDTMF(m *Media, digit byte, source DTMFSource) {
+	if h.OnDTMFFunc != nil {
+		h.OnDTMFFunc(m, digit, source)
+	}
+}
+
+// dtmfReceiver detects the DTMF digits of the received stream.
+type dtmfReceiver struct {
+	mu      sync.Mutex
+	handler DTMFHandler
+	// inband the in-band tone detector, nil if the telephone-events are used
+	inband     *pcm.DTMFDetector
+	transcoder audioTranscoder
+	// eventTimestamp the timestamp of the last reported telephone-event
+	eventTimestamp uint32
+	eventSeen      bool
+}
+
+// onEvent reports a telephone-event once, the packets of an event share its timestamp.
+func (r *dtmfReceiver) onEvent(m *Media, packet *RTPPacket) {
+	if len(packet.Payload) < 4 {
+		return
+	}
+	digit, ok := dtmfDigitOf(packet.Payload[0])
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	if r.eventSeen && r.eventTimestamp == packet.Timestamp {
+		r.mu.Unlock()
+		return
+	}
+	r.eventSeen = true
+	r.eventTimestamp = packet.Timestamp
+	r.mu.Unlock()
+	r.handler.OnDTMF(m, digit, DTMFSourceTelephoneEvent)
+}
+
+// onAudio detects the in-band tones of an audio payload.
+func (r *dtmfReceiver) onAudio(m *Media, payload []byte) {
+	r.mu.Lock()
+	samples, err := r.transcoder.Decode(payload)
+	if err != nil {
+		r.mu.Unlock()
+		return
+	}
+	digits := r.inband.Process(samples)
+	r.mu.Unlock()
+	for _, digit := range digits {
+		r.handler.OnDTMF(m, digit, DTMFSourceInBand)
+	}
+}
+
+// SetDTMFHandler enables the detection of received DTMF digits.
+// With DTMFModeAuto the in-band tones are detected only if the remote did not offer telephone-event.
+// It must be called before the media starts, e.g. in DialogHandler.OnMediaOpen.
+func (m *Media) SetDTMFHandler(mode DTMFMode, handler DTMFHandler) error {
+	r := &dtmfReceiver{handler: handler}
+	if mode == DTMFModeInBand || (mode == DTMFModeAuto && !m.remoteEvents) {
+		transcoder, err := newAudioTranscoder(m.audioCodec)
+		if err != nil {
+			return err
+		}
+		r.transcoder = transcoder
+		r.inband = pcm.NewDTMFDetector(m.audioCodec.AudioRate())
+	}
+	m.dtmfRx = r
+	return nil
+}
+
+// detectDTMF reports the DTMF digits of a received packet.
+func (m *Media) detectDTMF(packet *RTPPacket) {
+	if m.dtmfRx == nil {
+		return
+	}
+	switch {
+	case m.dtmfRx.inband != nil:
+		if int(packet.PayloadType) == m.audioCodec.PayloadType {
+			m.dtmfRx.onAudio(m, packet.Payload)
+		}
+	case m.eventCodec.Name != "" && int(packet.PayloadType) == m.eventCodec.PayloadType:
+		m.dtmfRx.onEvent(m, packet)
+	}
+}
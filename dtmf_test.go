@@ -0,0 +1,188 @@
+package mrcp
+
+import (
+	"github.com/hateeyan/go-mrcp/pkg/pcm"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_dtmfSender_next(t *testing.T) {
+	type packet struct {
+		payload   []byte
+		timestamp uint32
+		marker    bool
+	}
+	tests := []struct {
+		name   string
+		digits []dtmfDigit
+		ticks  int
+		want   []packet
+	}{
+		{
+			name:   "idle",
+			digits: nil,
+			ticks:  2,
+			want:   []packet{{}, {}},
+		},
+		{
+			name:   "one digit",
+			digits: []dtmfDigit{{event: 1, duration: 2}},
+			ticks:  7,
+			want: []packet{
+				{payload: []byte{1, 10, 0, 160}, timestamp: 1000, marker: true},
+				{payload: []byte{1, 0x80 | 10, 1, 64}, timestamp: 1000},
+				{payload: []byte{1, 0x80 | 10, 1, 64}, timestamp: 1000},
+				{payload: []byte{1, 0x80 | 10, 1, 64}, timestamp: 1000},
+				{},
+				{},
+				{},
+			},
+		},
+		{
+			name:   "two digits",
+			digits: []dtmfDigit{{event: 11, duration: 1}, {event: 12, duration: 1}},
+			ticks:  6,
+			want: []packet{
+				{payload: []byte{11, 0x80 | 10, 0, 160}, timestamp: 1000, marker: true},
+				{payload: []byte{11, 0x80 | 10, 0, 160}, timestamp: 1000},
+				{payload: []byte{11, 0x80 | 10, 0, 160}, timestamp: 1000},
+				{},
+				{payload: []byte{12, 0x80 | 10, 0, 160}, timestamp: 1640, marker: true},
+				{payload: []byte{12, 0x80 | 10, 0, 160}, timestamp: 1640},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s dtmfSender
+			s.push(tt.digits)
+			var got []packet
+			for i := 0; i < tt.ticks; i++ {
+				payload, timestamp, marker := s.next(uint32(1000+i*160), 160)
+				got = append(got, packet{payload: payload, timestamp: timestamp, marker: marker})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("next() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dtmfEvent(t *testing.T) {
+	tests := []struct {
+		digit  byte
+		want   byte
+		wantOk bool
+	}{
+		{digit: '0', want: 0, wantOk: true},
+		{digit: '9', want: 9, wantOk: true},
+		{digit: '*', want: 10, wantOk: true},
+		{digit: '#', want: 11, wantOk: true},
+		{digit: 'A', want: 12, wantOk: true},
+		{digit: 'd', want: 15, wantOk: true},
+		{digit: 'E', want: 0, wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.digit), func(t *testing.T) {
+			got, ok := dtmfEvent(tt.digit)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("dtmfEvent() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestMedia_SendDTMF(t *testing.T) {
+	pcmu := CodecDesc{Name: "PCMU", PayloadType: 0, SampleRate: 8000}
+	event := CodecDesc{Name: CodecTelephoneEvent, PayloadType: 101, SampleRate: 8000}
+	tests := []struct {
+		name      string
+		rcodecs   []CodecDesc
+		direction Direction
+		digits    string
+		wantErr   bool
+	}{
+		{name: "negotiated", rcodecs: []CodecDesc{pcmu, event}, direction: DirectionSendrecv, digits: "12#"},
+		{name: "not offered by the remote", rcodecs: []CodecDesc{pcmu}, direction: DirectionSendrecv, digits: "1", wantErr: true},
+		{name: "recvonly", rcodecs: []CodecDesc{pcmu, event}, direction: DirectionRecvonly, digits: "1", wantErr: true},
+		{name: "invalid digit", rcodecs: []CodecDesc{pcmu, event}, direction: DirectionSendrecv, digits: "1x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Media{laudioDesc: MediaDesc{Direction: tt.direction}, logger: slog.Default()}
+			if err := m.negotiateCodecs([]CodecDesc{pcmu, event}, tt.rcodecs); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.SendDTMF(tt.digits, 100*time.Millisecond); (err != nil) != tt.wantErr {
+				t.Errorf("SendDTMF() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMedia_SetDTMFHandler(t *testing.T) {
+	pcmu := CodecDesc{Name: "PCMU", PayloadType: 0, SampleRate: 8000}
+	event := CodecDesc{Name: CodecTelephoneEvent, PayloadType: 101, SampleRate: 8000}
+	six, _ := pcm.DTMFSegment('6', 80*time.Millisecond, -10)
+	tone := pcm.ToneSequence(8000, pcm.ToneSegment{Duration: 40 * time.Millisecond}, six, pcm.ToneSegment{Duration: 40 * time.Millisecond})
+	audio := make([][]byte, 0)
+	for i := 0; i < len(tone); i += 320 {
+		payload := make([]byte, 160)
+		_ = pcm.LinearToMuLaw(tone[i:i+320], payload)
+		audio = append(audio, payload)
+	}
+
+	tests := []struct {
+		name string
+		mode DTMFMode
+		// rcodecs the codecs offered by the remote, telephone-event is always offered locally
+		rcodecs []CodecDesc
+		want    []string
+	}{
+		{name: "telephone-event", mode: DTMFModeTelephoneEvent, rcodecs: []CodecDesc{pcmu, event}, want: []string{"4 telephone-event"}},
+		{name: "in-band", mode: DTMFModeInBand, rcodecs: []CodecDesc{pcmu, event}, want: []string{"6 in-band"}},
+		{name: "auto with telephone-event", mode: DTMFModeAuto, rcodecs: []CodecDesc{pcmu, event}, want: []string{"4 telephone-event"}},
+		{name: "auto without telephone-event", mode: DTMFModeAuto, rcodecs: []CodecDesc{pcmu}, want: []string{"6 in-band"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Media{
+				handler: MediaHandlerFunc{WriteRTPPacketFunc: func(*Media, []byte) bool { return true }},
+				logger:  slog.Default(),
+			}
+			if err := m.negotiateCodecs([]CodecDesc{pcmu, event}, tt.rcodecs); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			err := m.SetDTMFHandler(tt.mode, DTMFHandlerFunc{OnDTMFFunc: func(m *Media, digit byte, source DTMFSource) {
+				got = append(got, string(digit)+" "+source.String())
+			}})
+			if err != nil {
+				t.Errorf("SetDTMFHandler() error = %v", err)
+				return
+			}
+
+			var seq uint16
+			for _, payload := range audio {
+				packet := RTPPacket{PayloadType: 0, SequenceNumber: seq, Payload: payload}
+				data, _ := packet.Marshal()
+				m.writeRTPPacket(&packet, data)
+				seq++
+			}
+			// the packets of the event '4', the end packet is repeated
+			if m.remoteEvents {
+				for _, payload := range [][]byte{{4, 10, 0, 160}, {4, 10, 1, 64}, {4, 0x8A, 1, 224}, {4, 0x8A, 1, 224}} {
+					packet := RTPPacket{PayloadType: 101, SequenceNumber: seq, Timestamp: 8000, Payload: payload}
+					data, _ := packet.Marshal()
+					m.writeRTPPacket(&packet, data)
+					seq++
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OnDTMF() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
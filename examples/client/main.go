@@ -1,22 +1,16 @@
 package main
 
 import (
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/hateeyan/go-mrcp"
-	"github.com/hateeyan/go-mrcp/pkg/pcm"
-	"math/rand"
+	"io"
 	"os"
+	"time"
 )
 
 var (
-	pcmf      *os.File
-	pcmData   = make([]byte, 320)
-	rtpData   = make([]byte, 172)
-	sequence  uint16
-	timestamp = rand.Uint32()
-	ssrc      = rand.Uint32()
-	pt        uint8
 	responses = make(chan mrcp.Message, 1)
 )
 
@@ -72,16 +66,18 @@ func main() {
 	}
 	defer client.Close()
 
-	// connect to mrcp server
+	// connect to mrcp server, the INVITE is cancelled after 10s
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 	dialog, err := client.Dial(
+		ctx,
 		"10.9.232.246:8060",
 		mrcp.ResourceSpeechrecog,
 		mrcp.DialogHandlerFunc{
-			OnMediaOpenFunc: func(_ *mrcp.Media) mrcp.MediaHandler {
-				return mrcp.MediaHandlerFunc{
-					StartTxFunc:       startTx,
-					ReadRTPPacketFunc: readRTPPacket,
-				}
+			// send audio with Media.AudioWriter, the 8k file is resampled if a wideband codec is negotiated
+			OnMediaOpenFunc: func(m *mrcp.Media) mrcp.MediaHandler {
+				m.SetAudioRate(8000)
+				return nil
 			},
 			OnChannelOpenFunc: func(_ *mrcp.Channel) mrcp.ChannelHandler {
 				return mrcp.ChannelHandlerFunc{
@@ -91,17 +87,24 @@ func main() {
 		},
 	)
 	if err != nil {
+		var rejected *mrcp.DialRejectedError
+		if errors.As(err, &rejected) {
+			fmt.Println("rejected by server:", rejected.StatusCode, rejected.Reason)
+			return
+		}
 		fmt.Println(err)
 		return
 	}
 	defer dialog.Close()
 
-	pcmf, err = os.Open("../../testdata/8k.pcm")
+	pcmf, err := os.Open("../../testdata/8k.pcm")
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 	defer pcmf.Close()
+	go sendAudio(dialog.GetMedia(), pcmf)
+
 	if err := recognize(dialog); err != nil {
 		fmt.Println(err)
 		return
@@ -112,38 +115,10 @@ func onMessage(c *mrcp.Channel, msg mrcp.Message) {
 	responses <- msg
 }
 
-func startTx(m *mrcp.Media, codec mrcp.CodecDesc) error {
-	pt = uint8(codec.PayloadType)
-	return nil
-}
-
-func readRTPPacket(m *mrcp.Media) ([]byte, bool) {
-	// read pcm from file
-	n, err := pcmf.Read(pcmData)
-	if err != nil {
-		fmt.Println("read frame error:", err)
-		return nil, false
+func sendAudio(m *mrcp.Media, r io.Reader) {
+	w := m.AudioWriter()
+	defer w.Close()
+	if _, err := io.Copy(w, r); err != nil {
+		fmt.Println("failed to send audio:", err)
 	}
-	if n != len(pcmData) {
-		fmt.Println("no more data")
-		return nil, false
-	}
-
-	// rtp header
-	sequence++
-	timestamp += 160
-	rtpData[0] = 0x80
-	rtpData[1] = pt
-	binary.BigEndian.PutUint16(rtpData[2:], sequence)
-	binary.BigEndian.PutUint32(rtpData[4:], timestamp)
-	binary.BigEndian.PutUint32(rtpData[8:], ssrc)
-
-	// rtp payload
-	// pcm to pcmu
-	if err := pcm.LinearToMuLaw(pcmData, rtpData[12:]); err != nil {
-		fmt.Println("failed to encode to pcmu:", err)
-		return nil, false
-	}
-
-	return rtpData, true
 }
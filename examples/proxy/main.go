@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/hateeyan/go-mrcp"
 	"sync"
+	"time"
 )
 
 type proxySession struct {
@@ -83,8 +85,12 @@ type proxy struct {
 func (p *proxy) OnDialogCreate(ds *mrcp.DialogServer) (mrcp.DialogHandler, error) {
 	ps := &proxySession{ds: ds, rtps: make(chan []byte, 10)}
 
+	// the dial is cancelled if the INVITE of the dialog is cancelled
+	ctx, cancel := context.WithTimeout(ds.Context(), 5*time.Second)
+	defer cancel()
 	var err error
 	ps.dc, err = p.client.Dial(
+		ctx,
 		"10.9.232.246:8060",
 		ds.GetResource(),
 		mrcp.DialogHandlerFunc{
@@ -4,9 +4,9 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/hateeyan/go-mrcp"
-	"github.com/hateeyan/go-mrcp/pkg/pcm"
 	"github.com/tencentcloud/tencentcloud-speech-sdk-go/asr"
 	"github.com/tencentcloud/tencentcloud-speech-sdk-go/common"
+	"io"
 	"strconv"
 	"time"
 )
@@ -14,7 +14,6 @@ import (
 type speechRecognitionListener struct {
 	sessionId string
 	pcm       []byte
-	next      int
 	recog     *asr.SpeechRecognizer
 	channel   *mrcp.Channel
 }
@@ -32,28 +31,24 @@ func newRecognition() (*speechRecognitionListener, error) {
 	return listener, nil
 }
 
-func (l *speechRecognitionListener) onWriteRTPPacket(m *mrcp.Media, rtp []byte) bool {
-	if err := pcm.MuLawToLiner(rtp[12:], l.pcm[l.next*320:]); err != nil {
-		fmt.Println("failed to decode to pcm:", err)
-		return false
-	}
-
-	if l.next < 4 {
-		l.next++
-		return true
-	}
-	l.next = 0
-	if err := l.recog.Write(l.pcm); err != nil {
-		fmt.Println("send pcm error:", err)
-		return false
+func (l *speechRecognitionListener) readAudio(m *mrcp.Media) {
+	r := m.AudioReader()
+	for {
+		if _, err := io.ReadFull(r, l.pcm); err != nil {
+			return
+		}
+		if err := l.recog.Write(l.pcm); err != nil {
+			fmt.Println("send pcm error:", err)
+			return
+		}
 	}
-	return true
 }
 
 func (l *speechRecognitionListener) OnMediaOpen(media *mrcp.Media) mrcp.MediaHandler {
-	return mrcp.MediaHandlerFunc{
-		WriteRTPPacketFunc: l.onWriteRTPPacket,
-	}
+	// receive audio with Media.AudioReader, resampled to the 8k engine
+	media.SetAudioRate(8000)
+	go l.readAudio(media)
+	return nil
 }
 
 func (l *speechRecognitionListener) onMessage(c *mrcp.Channel, msg mrcp.Message) {
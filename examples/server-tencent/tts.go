@@ -1,16 +1,11 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/hateeyan/go-mrcp"
-	"github.com/hateeyan/go-mrcp/pkg/pcm"
 	"github.com/tencentcloud/tencentcloud-speech-sdk-go/common"
 	"github.com/tencentcloud/tencentcloud-speech-sdk-go/tts"
-	"math/rand"
-	"sync"
 	"time"
 )
 
@@ -18,14 +13,7 @@ type speechWsSynthesisListener struct {
 	sessionId string
 	synth     *tts.SpeechWsSynthesizer
 	channel   *mrcp.Channel
-	sequence  uint16
-	timestamp uint32
-	ssrc      uint32
-	pcm       []byte
-	rtp       []byte
-	done      bool
-	buf       bytes.Buffer
-	mu        sync.Mutex
+	audio     *mrcp.AudioWriter
 }
 
 func newSynthesis() (*speechWsSynthesisListener, error) {
@@ -33,10 +21,6 @@ func newSynthesis() (*speechWsSynthesisListener, error) {
 	credential := common.NewCredential(secretId, secretKey)
 	listener := &speechWsSynthesisListener{
 		sessionId: sessionId,
-		timestamp: rand.Uint32(),
-		ssrc:      rand.Uint32(),
-		pcm:       make([]byte, 320),
-		rtp:       make([]byte, 172),
 	}
 	listener.synth = tts.NewSpeechWsSynthesizer(int64(appId), credential, listener)
 	listener.synth.SessionId = sessionId
@@ -48,50 +32,21 @@ func newSynthesis() (*speechWsSynthesisListener, error) {
 	return listener, nil
 }
 
-func (l *speechWsSynthesisListener) readRTPPacket(m *mrcp.Media) ([]byte, bool) {
-	if l.buf.Len() < 320 {
-		if l.done {
-			event := l.channel.NewEvent("SPEAK-COMPLETE", mrcp.RequestStateComplete)
-			event.SetCompletionCause(l.channel.GetResource(), mrcp.SynthCompletionCauseNormal)
-			if err := l.channel.SendMrcpMessage(event); err != nil {
-				fmt.Println("failed to send event:", err)
-			}
-			return nil, false
-		}
-		return nil, true
-	}
-
-	l.mu.Lock()
-	_, err := l.buf.Read(l.pcm)
-	l.mu.Unlock()
-	if err != nil {
-		fmt.Println("read error:", err)
-		return nil, false
+func (l *speechWsSynthesisListener) complete() {
+	// wait until the synthesized audio has been sent
+	l.audio.Drain()
+	event := l.channel.NewEvent("SPEAK-COMPLETE", mrcp.RequestStateComplete)
+	event.SetCompletionCause(l.channel.GetResource(), mrcp.SynthCompletionCauseNormal)
+	if err := l.channel.SendMrcpMessage(event); err != nil {
+		fmt.Println("failed to send event:", err)
 	}
-
-	// rtp header
-	l.sequence++
-	l.timestamp += 160
-	l.rtp[0] = 0x80
-	l.rtp[1] = 0
-	binary.BigEndian.PutUint16(l.rtp[2:], l.sequence)
-	binary.BigEndian.PutUint32(l.rtp[4:], l.timestamp)
-	binary.BigEndian.PutUint32(l.rtp[8:], l.ssrc)
-
-	// rtp payload
-	// pcm to pcmu
-	if err := pcm.LinearToMuLaw(l.pcm, l.rtp[12:]); err != nil {
-		fmt.Println("failed to encode to pcmu:", err)
-		return nil, false
-	}
-
-	return l.rtp, true
 }
 
 func (l *speechWsSynthesisListener) OnMediaOpen(media *mrcp.Media) mrcp.MediaHandler {
-	return mrcp.MediaHandlerFunc{
-		ReadRTPPacketFunc: l.readRTPPacket,
-	}
+	// send audio with Media.AudioWriter, resampled from the 8k engine
+	media.SetAudioRate(int(l.synth.SampleRate))
+	l.audio = media.AudioWriter()
+	return nil
 }
 
 func (l *speechWsSynthesisListener) onMessage(c *mrcp.Channel, msg mrcp.Message) {
@@ -127,15 +82,15 @@ func (l *speechWsSynthesisListener) OnSynthesisStart(r *tts.SpeechWsSynthesisRes
 }
 
 func (l *speechWsSynthesisListener) OnSynthesisEnd(r *tts.SpeechWsSynthesisResponse) {
-	l.done = true
+	go l.complete()
 	fmt.Printf("%s|OnSynthesisEnd,sessionId:%s response: %s\n", time.Now().Format("2006-01-02 15:04:05"), l.sessionId, r.ToString())
 }
 
 func (l *speechWsSynthesisListener) OnAudioResult(data []byte) {
 	fmt.Printf("%s|OnAudioResult,sessionId:%s\n", time.Now().Format("2006-01-02 15:04:05"), l.sessionId)
-	l.mu.Lock()
-	l.buf.Write(data)
-	l.mu.Unlock()
+	if _, err := l.audio.Write(data); err != nil {
+		fmt.Println("write audio error:", err)
+	}
 }
 
 func (l *speechWsSynthesisListener) OnTextResult(r *tts.SpeechWsSynthesisResponse) {
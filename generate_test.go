@@ -0,0 +1,79 @@
+package mrcp
+
+import (
+	"github.com/hateeyan/go-mrcp/pkg/pcm"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMedia_PlayTones(t *testing.T) {
+	m := &Media{audioCodec: CodecDesc{Name: "G722", PayloadType: 9, SampleRate: 8000}, logger: slog.Default()}
+	w := m.AudioWriter()
+	w.limit = 1 << 20
+	if err := m.PlayTones(pcm.Beep, pcm.ToneSegment{Duration: 100 * time.Millisecond}); err != nil {
+		t.Errorf("PlayTones() error = %v", err)
+		return
+	}
+	// 300ms at the 16 kHz audio rate of G.722
+	if got, want := w.pipe.buf.Len(), 2*16000*300/1000; got != want {
+		t.Errorf("PlayTones() got = %d bytes, want %d bytes", got, want)
+	}
+}
+
+func TestMedia_PlayTones_mediaHandler(t *testing.T) {
+	receiver, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+
+	tests := []struct {
+		name      string
+		direction Direction
+		// wantPackets the number of packets of the tones
+		wantPackets int
+		wantErr     bool
+	}{
+		{name: "sendrecv", direction: DirectionSendrecv, wantPackets: 10},
+		{name: "recvonly", direction: DirectionRecvonly, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the handler has no packet ready
+			m := &Media{
+				remote:     receiver.LocalAddr().(*net.UDPAddr),
+				laudioDesc: MediaDesc{Host: "127.0.0.1", Direction: tt.direction, RTCPMux: true},
+				raudioDesc: MediaDesc{Host: "127.0.0.1", Port: receiver.LocalAddr().(*net.UDPAddr).Port, RTCPMux: true},
+				audioCodec: CodecDesc{PayloadType: 0, Name: "PCMU", SampleRate: 8000},
+				handler: MediaHandlerFunc{
+					ReadRTPPacketFunc:  func(*Media) ([]byte, bool) { return nil, true },
+					WriteRTPPacketFunc: func(*Media, []byte) bool { return true },
+				},
+				logger: slog.Default(),
+			}
+			if err := m.start(); err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close()
+
+			err := m.PlayTones(pcm.Beep)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PlayTones() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			buf := make([]byte, 1500)
+			for i := 0; i < tt.wantPackets; i++ {
+				_ = receiver.SetReadDeadline(time.Now().Add(time.Second))
+				n, _, err := receiver.ReadFromUDP(buf)
+				if err != nil {
+					t.Fatalf("packet %d error = %v", i, err)
+				}
+				var packet RTPPacket
+				if err := packet.Unmarshal(buf[:n]); err != nil || packet.PayloadType != 0 || len(packet.Payload) != 160 {
+					t.Errorf("packet %d got = %v, error = %v", i, packet, err)
+				}
+			}
+		})
+	}
+}
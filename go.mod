@@ -4,6 +4,7 @@ go 1.23
 
 require (
 	github.com/emiago/sipgo v0.27.1
+	github.com/icholy/digest v0.1.22
 	github.com/pion/sdp/v3 v3.0.10
 )
 
@@ -12,7 +13,6 @@ require (
 	github.com/gobwas/pool v0.2.1 // indirect
 	github.com/gobwas/ws v1.3.2 // indirect
 	github.com/google/uuid v1.6.0 // indirect
-	github.com/icholy/digest v0.1.22 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
@@ -0,0 +1,316 @@
+package mrcp
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultJitterMinDelay = 40 * time.Millisecond
+	defaultJitterMaxDelay = 200 * time.Millisecond
+	// jitterMaxDropout the maximum sequence gap treated as packet loss, larger gaps resynchronize the buffer
+	jitterMaxDropout = 100
+	// jitterMaxPtime the longest packetization interval learned from the received packets
+	jitterMaxPtime = 200 * time.Millisecond
+)
+
+// JitterBufferConfig configures the jitter buffer of the received RTP stream
+type JitterBufferConfig struct {
+	// MinDelay MaxDelay the range of the adaptive playout delay
+	// Default: [40ms, 200ms]
+	MinDelay, MaxDelay time.Duration
+	// OnLoss is called when lost packets are detected
+	OnLoss func(m *Media, lost int)
+}
+
+// JitterBufferStats statistics of the jitter buffer
+type JitterBufferStats struct {
+	// Delay the current target playout delay
+	Delay time.Duration
+	// Jitter the estimated interarrival jitter
+	Jitter     time.Duration
+	Lost       int
+	Late       int
+	Duplicated int
+	Reordered  int
+	// Discarded the packets dropped because the buffer is full
+	Discarded int
+	// Resets the number of resynchronizations caused by SSRC changes or large sequence gaps
+	Resets int
+}
+
+type jitterPacket struct {
+	seq  uint64
+	data []byte
+}
+
+// jitterBuffer reorders received RTP packets by sequence number and releases them
+// at the packetization interval of the received stream after an adaptive playout delay.
+type jitterBuffer struct {
+	mu        sync.Mutex
+	config    JitterBufferConfig
+	clockRate int
+	// ptime the duration of the received packets, learned from their timestamps
+	ptime time.Duration
+	// last the header of the previous received packet, if hasLast
+	last    RTPPacket
+	hasLast bool
+	packets []jitterPacket
+	ssrc    uint32
+	started bool
+	playing bool
+	// maxSeq the highest extended sequence number received
+	maxSeq uint64
+	// next the extended sequence number of the next packet to be released
+	next uint64
+	// jitter the interarrival jitter in timestamp units defined in RFC 3550
+	jitter      float64
+	lastTransit int64
+	epoch       time.Time
+	stats       JitterBufferStats
+}
+
+func newJitterBuffer(config JitterBufferConfig, clockRate int, ptime time.Duration) *jitterBuffer {
+	if config.MinDelay <= 0 {
+		config.MinDelay = defaultJitterMinDelay
+	}
+	if config.MaxDelay < config.MinDelay {
+		config.MaxDelay = max(defaultJitterMaxDelay, config.MinDelay)
+	}
+	return &jitterBuffer{
+		config:    config,
+		clockRate: clockRate,
+		ptime:     ptime,
+		epoch:     time.Now(),
+	}
+}
+
+// extend converts a sequence number to an extended sequence number close to the highest received.
+func (b *jitterBuffer) extend(seq uint16) uint64 {
+	ext := b.maxSeq&^0xFFFF | uint64(seq)
+	if ext+0x8000 < b.maxSeq {
+		ext += 0x10000
+	} else if ext > b.maxSeq+0x8000 && ext >= 0x10000 {
+		ext -= 0x10000
+	}
+	return ext
+}
+
+func (b *jitterBuffer) reset() {
+	b.hasLast = false
+	b.packets = b.packets[:0]
+	b.started = false
+	b.playing = false
+	b.jitter = 0
+}
+
+// push stores a packet, data is copied.
+func (b *jitterBuffer) push(packet *RTPPacket, data []byte, arrival time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.started && packet.SSRC != b.ssrc {
+		b.stats.Resets++
+		b.reset()
+	}
+	if !b.started {
+		b.started = true
+		b.ssrc = packet.SSRC
+		// leave room for packets reordered before the first one
+		b.maxSeq = 0x10000 | uint64(packet.SequenceNumber)
+		b.next = b.maxSeq
+		b.lastTransit = b.transit(packet.Timestamp, arrival)
+	}
+	b.learnPtime(packet)
+
+	seq := b.extend(packet.SequenceNumber)
+	if seq < b.next && seq+jitterMaxDropout >= b.next {
+		b.stats.Late++
+		return
+	}
+	if seq > b.maxSeq+jitterMaxDropout || seq < b.next {
+		// the sender jumped, start over from this packet
+		b.stats.Resets++
+		b.packets = b.packets[:0]
+		b.next = seq
+		b.maxSeq = seq
+		b.playing = false
+	}
+
+	transit := b.transit(packet.Timestamp, arrival)
+	d := transit - b.lastTransit
+	if d < 0 {
+		d = -d
+	}
+	b.lastTransit = transit
+	b.jitter += (float64(d) - b.jitter) / 16
+
+	i := len(b.packets)
+	for i > 0 && b.packets[i-1].seq > seq {
+		i--
+	}
+	if i > 0 && b.packets[i-1].seq == seq {
+		b.stats.Duplicated++
+		return
+	}
+	if i < len(b.packets) {
+		b.stats.Reordered++
+	}
+	b.packets = append(b.packets, jitterPacket{})
+	copy(b.packets[i+1:], b.packets[i:])
+	b.packets[i] = jitterPacket{seq: seq, data: append([]byte(nil), data...)}
+	if seq > b.maxSeq {
+		b.maxSeq = seq
+	}
+
+	// bound the buffer to twice the maximum delay
+	if limit := int(2*b.config.MaxDelay/b.ptime) + 1; len(b.packets) > limit {
+		b.stats.Lost += int(b.packets[0].seq - b.next)
+		b.stats.Discarded++
+		b.next = b.packets[0].seq + 1
+		b.packets = b.packets[1:]
+	}
+}
+
+// learnPtime updates the packet duration from the timestamp delta of consecutive packets
+// of the same payload type. The first packet of a talkspurt and the events, whose timestamps
+// do not advance with the packets, are skipped.
+func (b *jitterBuffer) learnPtime(packet *RTPPacket) {
+	last, hasLast := b.last, b.hasLast
+	b.last = RTPPacket{PayloadType: packet.PayloadType, SequenceNumber: packet.SequenceNumber, Timestamp: packet.Timestamp}
+	b.hasLast = true
+	if !hasLast || packet.Marker || packet.SequenceNumber != last.SequenceNumber+1 || packet.PayloadType != last.PayloadType {
+		return
+	}
+	ptime := time.Duration(packet.Timestamp-last.Timestamp) * time.Second / time.Duration(b.clockRate)
+	if ptime > 0 && ptime <= jitterMaxPtime {
+		b.ptime = ptime
+	}
+}
+
+// interval returns the duration of the received packets, the playout interval.
+func (b *jitterBuffer) interval() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ptime
+}
+
+// transit returns the relative transit time in timestamp units.
+func (b *jitterBuffer) transit(timestamp uint32, arrival time.Time) int64 {
+	return int64(arrival.Sub(b.epoch))*int64(b.clockRate)/int64(time.Second) - int64(timestamp)
+}
+
+// delay returns the target playout delay.
+func (b *jitterBuffer) delay() time.Duration {
+	jitter := time.Duration(b.jitter * float64(time.Second) / float64(b.clockRate))
+	delay := 3*jitter + b.ptime
+	return min(max(delay, b.config.MinDelay), b.config.MaxDelay)
+}
+
+// pop is called every packetization interval, returns the packets to be released
+// and the number of packets detected as lost.
+func (b *jitterBuffer) pop() ([][]byte, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	target := int(b.delay() / b.ptime)
+	if !b.playing {
+		if len(b.packets) == 0 || len(b.packets) < target {
+			return nil, 0
+		}
+		b.playing = true
+	}
+	if len(b.packets) == 0 {
+		// underrun, build up the delay again
+		b.playing = false
+		return nil, 0
+	}
+
+	head := b.packets[0]
+	if head.seq > b.next {
+		// the missing packet is lost at its playout time
+		b.next++
+		b.stats.Lost++
+		return nil, 1
+	}
+
+	n := 1
+	if len(b.packets) > target+2 {
+		// the buffer is too deep, release one more packet to reduce the delay
+		n = 2
+		if b.packets[1].seq != head.seq+1 {
+			n = 1
+		}
+	}
+	packets := make([][]byte, 0, n)
+	for _, p := range b.packets[:n] {
+		packets = append(packets, p.data)
+	}
+	b.next = b.packets[n-1].seq + 1
+	b.packets = b.packets[n:]
+	return packets, 0
+}
+
+func (b *jitterBuffer) getStats() JitterBufferStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	stats.Delay = b.delay()
+	stats.Jitter = time.Duration(b.jitter * float64(time.Second) / float64(b.clockRate))
+	return stats
+}
+
+// SetJitterBuffer enables the jitter buffer between the socket and the MediaHandler,
+// it must be called before the media starts, e.g. in DialogHandler.OnMediaOpen.
+// The packets are released at the packetization interval of the remote, its ptime attribute
+// until it is learned from the timestamps of the received packets.
+func (m *Media) SetJitterBuffer(config JitterBufferConfig) {
+	ptime := m.raudioDesc.Ptime
+	if ptime <= 0 {
+		ptime = m.ptime()
+	}
+	m.jitter = newJitterBuffer(config, m.audioCodec.SampleRate, time.Duration(ptime)*time.Millisecond)
+}
+
+// JitterBufferStats returns the statistics of the jitter buffer.
+func (m *Media) JitterBufferStats() JitterBufferStats {
+	if m.jitter == nil {
+		return JitterBufferStats{}
+	}
+	return m.jitter.getStats()
+}
+
+// startPlayout releases the packets of the jitter buffer to the MediaHandler.
+func (m *Media) startPlayout() {
+	interval := m.jitter.interval()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-t.C:
+		}
+		if got := m.jitter.interval(); got != interval {
+			m.logger.Debug("rtp packetization interval changed", "ptime", got)
+			interval = got
+			t.Reset(interval)
+		}
+
+		packets, lost := m.jitter.pop()
+		if lost > 0 {
+			m.logger.Debug("rtp packets lost", "lost", lost)
+			if m.jitter.config.OnLoss != nil {
+				m.jitter.config.OnLoss(m, lost)
+			}
+		}
+		for _, data := range packets {
+			var packet RTPPacket
+			_ = packet.Unmarshal(data)
+			if ok := m.writeRTPPacket(&packet, data); !ok {
+				m.rxStopped.Store(true)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package mrcp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_jitterBuffer(t *testing.T) {
+	type packet struct {
+		ssrc uint32
+		seq  uint16
+	}
+	tests := []struct {
+		name      string
+		packets   []packet
+		want      []byte
+		wantLost  int
+		wantStats JitterBufferStats
+	}{
+		{
+			name:    "in order",
+			packets: []packet{{1, 10}, {1, 11}, {1, 12}},
+			want:    []byte{10, 11, 12},
+		},
+		{
+			name:      "reordered and duplicated",
+			packets:   []packet{{1, 10}, {1, 12}, {1, 11}, {1, 12}, {1, 13}},
+			want:      []byte{10, 11, 12, 13},
+			wantStats: JitterBufferStats{Reordered: 1, Duplicated: 1},
+		},
+		{
+			name:      "sequence wrap",
+			packets:   []packet{{1, 65534}, {1, 0}, {1, 65535}, {1, 1}},
+			want:      []byte{254, 255, 0, 1},
+			wantStats: JitterBufferStats{Reordered: 1},
+		},
+		{
+			name:      "gap",
+			packets:   []packet{{1, 10}, {1, 11}, {1, 14}},
+			want:      []byte{10, 11, 14},
+			wantLost:  2,
+			wantStats: JitterBufferStats{Lost: 2},
+		},
+		{
+			name:      "ssrc change",
+			packets:   []packet{{1, 10}, {1, 11}, {2, 500}, {2, 501}},
+			want:      []byte{244, 245},
+			wantStats: JitterBufferStats{Resets: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newJitterBuffer(JitterBufferConfig{MinDelay: time.Millisecond, MaxDelay: time.Second}, 8000, 20*time.Millisecond)
+			now := time.Now()
+			for i, p := range tt.packets {
+				packet := RTPPacket{SequenceNumber: p.seq, Timestamp: uint32(p.seq-tt.packets[0].seq) * 160, SSRC: p.ssrc}
+				b.push(&packet, []byte{byte(p.seq)}, now.Add(time.Duration(i)*20*time.Millisecond))
+			}
+
+			var got []byte
+			var lost int
+			for i := 0; i < 2*len(tt.packets); i++ {
+				packets, n := b.pop()
+				lost += n
+				for _, data := range packets {
+					got = append(got, data...)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pop() got = %v, want %v", got, tt.want)
+			}
+			if lost != tt.wantLost {
+				t.Errorf("pop() lost = %v, want %v", lost, tt.wantLost)
+			}
+			stats := b.getStats()
+			stats.Delay, stats.Jitter = 0, 0
+			if !reflect.DeepEqual(stats, tt.wantStats) {
+				t.Errorf("getStats() got = %+v, want %+v", stats, tt.wantStats)
+			}
+		})
+	}
+}
+
+func Test_jitterBuffer_interval(t *testing.T) {
+	type packet struct {
+		pt        uint8
+		seq       uint16
+		timestamp uint32
+		marker    bool
+	}
+	tests := []struct {
+		name    string
+		packets []packet
+		want    time.Duration
+	}{
+		{
+			name:    "remote ptime",
+			packets: []packet{{pt: 0, seq: 10, timestamp: 1000}},
+			want:    20 * time.Millisecond,
+		},
+		{
+			name:    "30ms packets",
+			packets: []packet{{pt: 0, seq: 10, timestamp: 1000}, {pt: 0, seq: 11, timestamp: 1240}},
+			want:    30 * time.Millisecond,
+		},
+		{
+			name: "events and talkspurts",
+			packets: []packet{
+				{pt: 0, seq: 10, timestamp: 1000},
+				{pt: 0, seq: 11, timestamp: 1080},
+				{pt: 101, seq: 12, timestamp: 1160},
+				{pt: 101, seq: 13, timestamp: 1160},
+				{pt: 0, seq: 14, timestamp: 2000},
+				{pt: 0, seq: 15, timestamp: 9000, marker: true},
+				{pt: 0, seq: 17, timestamp: 9500},
+			},
+			want: 10 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newJitterBuffer(JitterBufferConfig{}, 8000, 20*time.Millisecond)
+			now := time.Now()
+			for _, p := range tt.packets {
+				packet := RTPPacket{PayloadType: p.pt, SequenceNumber: p.seq, Timestamp: p.timestamp, Marker: p.marker, SSRC: 1}
+				b.push(&packet, nil, now)
+			}
+			if got := b.interval(); got != tt.want {
+				t.Errorf("interval() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMedia_SetJitterBuffer(t *testing.T) {
+	m := &Media{
+		laudioDesc: MediaDesc{Ptime: 20},
+		raudioDesc: MediaDesc{Ptime: 30},
+		audioCodec: CodecDesc{PayloadType: 0, Name: "PCMU", SampleRate: 8000},
+	}
+	m.SetJitterBuffer(JitterBufferConfig{})
+	if got := m.jitter.interval(); got != 30*time.Millisecond {
+		t.Errorf("interval() got = %v, want %v", got, 30*time.Millisecond)
+	}
+}
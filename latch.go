@@ -0,0 +1,130 @@
+package mrcp
+
+import (
+	"net"
+	"time"
+)
+
+// latchSDPHostTimeout how long LatchVerified waits for a packet from the host in the SDP
+// before it latches a source on another host, e.g. behind a NAT.
+const latchSDPHostTimeout = time.Second
+
+// LatchMode how the destination of the outgoing media is learned from the received packets,
+// known as symmetric RTP, used to traverse NAT. The destination of the outgoing RTCP is learned
+// the same way, only from the host of the latched media.
+type LatchMode int
+
+const (
+	// LatchFirstPacket the destination is the source of the first received packet
+	LatchFirstPacket LatchMode = iota
+	// LatchDisabled the media is sent to the address in the SDP, including the rtcp attribute
+	LatchDisabled
+	// LatchVerified the destination is the source of the first packet with a negotiated payload type,
+	// its SSRC is remembered. The source must be on the host in the SDP during the first second of
+	// the media, packets from other hosts are dropped until then. Packets from other sources are dropped unless they have the same SSRC,
+	// the destination then follows them, e.g. after a NAT rebinding.
+	LatchVerified
+	// LatchSSRCChange the destination is the source of the first packet
+	// and of the first packet of every new SSRC
+	LatchSSRCChange
+)
+
+func (l LatchMode) String() string {
+	switch l {
+	case LatchFirstPacket:
+		return "first-packet"
+	case LatchDisabled:
+		return "disabled"
+	case LatchVerified:
+		return "verified"
+	case LatchSSRCChange:
+		return "ssrc-change"
+	default:
+		return "unknown"
+	}
+}
+
+// latch updates the destination of the outgoing media from a received packet,
+// returns false if the packet must be dropped.
+func (m *Media) latch(addr *net.UDPAddr, packet *RTPPacket) bool {
+	switch m.latchMode {
+	case LatchDisabled:
+		return true
+	case LatchFirstPacket:
+		if !m.latched {
+			m.setRemote(addr, packet.SSRC)
+		}
+		return true
+	case LatchSSRCChange:
+		if !m.latched || packet.SSRC != m.latchSSRC {
+			m.setRemote(addr, packet.SSRC)
+		}
+		return true
+	case LatchVerified:
+		if !m.negotiated(int(packet.PayloadType)) {
+			return false
+		}
+		if !m.latched {
+			// remote is the address in the SDP until latched
+			if !addr.IP.Equal(m.remote.IP) && time.Now().Before(m.latchSDPHostUntil) {
+				m.logger.Debug("drop rtp packet from unexpected host", "source", addr.String(), "ssrc", packet.SSRC)
+				return false
+			}
+			m.setRemote(addr, packet.SSRC)
+			return true
+		}
+		m.mu.Lock()
+		same := addr.IP.Equal(m.remote.IP) && addr.Port == m.remote.Port
+		m.mu.Unlock()
+		switch {
+		case packet.SSRC == m.latchSSRC:
+			if !same {
+				m.setRemote(addr, packet.SSRC)
+			}
+			return true
+		case same:
+			// a new stream from the latched source
+			m.latchSSRC = packet.SSRC
+			return true
+		default:
+			m.logger.Debug("drop rtp packet from unexpected source", "source", addr.String(), "ssrc", packet.SSRC)
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Media) setRemote(addr *net.UDPAddr, ssrc uint32) {
+	m.logger.Info("latch remote media", "remote", addr.String(), "ssrc", ssrc)
+	m.mu.Lock()
+	m.remote = addr
+	if m.rtcpMux {
+		m.rtcpRemote = addr
+	}
+	m.latched = true
+	m.latchSSRC = ssrc
+	m.mu.Unlock()
+}
+
+// latchRTCP updates the destination of the outgoing RTCP from a received packet,
+// only a packet from the host of the latched media is latched.
+func (m *Media) latchRTCP(addr *net.UDPAddr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.latchMode == LatchDisabled || !m.latched || !addr.IP.Equal(m.remote.IP) {
+		return
+	}
+	// the first packet only, as the media
+	if m.latchMode == LatchFirstPacket && m.rtcpLatched {
+		return
+	}
+	m.rtcpRemote = addr
+	m.rtcpLatched = true
+}
+
+// negotiated reports whether a payload type of the received stream was negotiated.
+func (m *Media) negotiated(pt int) bool {
+	return pt == m.audioCodec.PayloadType ||
+		(m.eventCodec.Name != "" && pt == m.eventCodec.PayloadType) ||
+		(m.cnCodec.Name != "" && pt == m.cnCodec.PayloadType)
+}
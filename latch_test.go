@@ -0,0 +1,136 @@
+package mrcp
+
+import (
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMedia_latch(t *testing.T) {
+	sdp := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 20000}
+	nat := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 30000}
+	rebound := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 30002}
+	attacker := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 40000}
+	sdpRTP := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 20010}
+
+	type packet struct {
+		from *net.UDPAddr
+		pt   uint8
+		ssrc uint32
+		// wantOk the packet is accepted, wantRemote the destination afterwards
+		wantOk     bool
+		wantRemote *net.UDPAddr
+	}
+	tests := []struct {
+		name string
+		mode LatchMode
+		// sdpHost only a source on the host in the SDP is latched
+		sdpHost bool
+		packets []packet
+	}{
+		{
+			name: "first packet",
+			mode: LatchFirstPacket,
+			packets: []packet{
+				{from: nat, pt: 0, ssrc: 1, wantOk: true, wantRemote: nat},
+				{from: attacker, pt: 0, ssrc: 2, wantOk: true, wantRemote: nat},
+			},
+		},
+		{
+			name: "disabled",
+			mode: LatchDisabled,
+			packets: []packet{
+				{from: nat, pt: 0, ssrc: 1, wantOk: true, wantRemote: sdp},
+			},
+		},
+		{
+			name: "verified after the timeout",
+			mode: LatchVerified,
+			packets: []packet{
+				{from: attacker, pt: 96, ssrc: 9, wantOk: false, wantRemote: sdp},
+				{from: nat, pt: 0, ssrc: 1, wantOk: true, wantRemote: nat},
+				{from: attacker, pt: 0, ssrc: 9, wantOk: false, wantRemote: nat},
+				{from: nat, pt: 101, ssrc: 1, wantOk: true, wantRemote: nat},
+				{from: rebound, pt: 0, ssrc: 1, wantOk: true, wantRemote: rebound},
+				{from: rebound, pt: 0, ssrc: 3, wantOk: true, wantRemote: rebound},
+				{from: nat, pt: 0, ssrc: 1, wantOk: false, wantRemote: rebound},
+			},
+		},
+		{
+			name:    "verified from the host in the sdp",
+			mode:    LatchVerified,
+			sdpHost: true,
+			packets: []packet{
+				{from: attacker, pt: 0, ssrc: 9, wantOk: false, wantRemote: sdp},
+				{from: nat, pt: 0, ssrc: 1, wantOk: false, wantRemote: sdp},
+				{from: sdpRTP, pt: 0, ssrc: 1, wantOk: true, wantRemote: sdpRTP},
+				{from: attacker, pt: 0, ssrc: 9, wantOk: false, wantRemote: sdpRTP},
+			},
+		},
+		{
+			name: "ssrc change",
+			mode: LatchSSRCChange,
+			packets: []packet{
+				{from: nat, pt: 0, ssrc: 1, wantOk: true, wantRemote: nat},
+				{from: attacker, pt: 0, ssrc: 1, wantOk: true, wantRemote: nat},
+				{from: rebound, pt: 0, ssrc: 2, wantOk: true, wantRemote: rebound},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Media{
+				remote:     sdp,
+				latchMode:  tt.mode,
+				audioCodec: CodecDesc{Name: "PCMU", PayloadType: 0, SampleRate: 8000},
+				eventCodec: CodecDesc{Name: CodecTelephoneEvent, PayloadType: 101, SampleRate: 8000},
+				logger:     slog.Default(),
+			}
+			if tt.sdpHost {
+				m.latchSDPHostUntil = time.Now().Add(time.Minute)
+			}
+			for i, p := range tt.packets {
+				ok := m.latch(p.from, &RTPPacket{PayloadType: p.pt, SSRC: p.ssrc})
+				if ok != p.wantOk || m.remote.String() != p.wantRemote.String() {
+					t.Errorf("latch() packet %d got = %v, %v, want %v, %v", i, ok, m.remote, p.wantOk, p.wantRemote)
+				}
+			}
+		})
+	}
+}
+
+func TestMedia_latchRTCP(t *testing.T) {
+	sdp := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 20001}
+	nat := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 30000}
+	natRTCP := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 30001}
+	reboundRTCP := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 30003}
+	attacker := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 40001}
+
+	tests := []struct {
+		name string
+		mode LatchMode
+		// latched the media is latched to nat
+		latched bool
+		from    []*net.UDPAddr
+		want    *net.UDPAddr
+	}{
+		{name: "disabled", mode: LatchDisabled, latched: true, from: []*net.UDPAddr{natRTCP}, want: sdp},
+		{name: "media not latched", mode: LatchFirstPacket, from: []*net.UDPAddr{natRTCP}, want: sdp},
+		{name: "first packet", mode: LatchFirstPacket, latched: true, from: []*net.UDPAddr{natRTCP, reboundRTCP}, want: natRTCP},
+		{name: "first packet from another host", mode: LatchFirstPacket, latched: true, from: []*net.UDPAddr{attacker, natRTCP}, want: natRTCP},
+		{name: "ssrc change", mode: LatchSSRCChange, latched: true, from: []*net.UDPAddr{natRTCP, attacker, reboundRTCP}, want: reboundRTCP},
+		{name: "verified", mode: LatchVerified, latched: true, from: []*net.UDPAddr{attacker, natRTCP}, want: natRTCP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Media{remote: nat, rtcpRemote: sdp, latchMode: tt.mode, latched: tt.latched, logger: slog.Default()}
+			for _, addr := range tt.from {
+				m.latchRTCP(addr)
+			}
+			if m.rtcpRemote.String() != tt.want.String() {
+				t.Errorf("latchRTCP() got = %v, want %v", m.rtcpRemote, tt.want)
+			}
+		})
+	}
+}
@@ -4,15 +4,24 @@ import (
 	"errors"
 	"io"
 	"log/slog"
+	"math/rand"
 	"net"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var errNoAudioCodec = errors.New("no available audio codec")
+
+const defaultPtime = 20
+
 type MediaHandler interface {
 	// StartTx is called when starting to send RTP stream
 	StartTx(m *Media, codec CodecDesc) error
 	// ReadRTPPacket read a RTP packet from high-level
+	// the header is kept unless Media.SetRewriteRTPHeaders is enabled,
+	// the packet is paced by the duration of its payload
 	// stop sending by returning false
 	ReadRTPPacket(m *Media) ([]byte, bool)
 
@@ -59,17 +68,71 @@ func (h MediaHandlerFunc) WriteRTPPacket(m *Media, rtp []byte) bool {
 }
 
 type Media struct {
-	conn                   *net.UDPConn
-	remote                 *net.UDPAddr
-	remoteVerified         bool
+	conn   *net.UDPConn
+	remote *net.UDPAddr
+	// latchMode how remote is learned from the received packets
+	latchMode LatchMode
+	latched   bool
+	latchSSRC uint32
+	// latchSDPHostUntil until when LatchVerified latches only a source on the host in the SDP
+	latchSDPHostUntil      time.Time
 	laudioDesc, raudioDesc MediaDesc
 	// preferred audio codec
 	audioCodec CodecDesc
 	// preferred telephone-event codec
 	eventCodec CodecDesc
-	handler    MediaHandler
-	closed     bool
-	logger     *slog.Logger
+	// remoteEvents the remote offered telephone-event
+	remoteEvents bool
+	// comfort noise codec, empty if not negotiated
+	cnCodec CodecDesc
+	handler MediaHandler
+	// outgoing RTP stream state
+	txSequence  uint16
+	txTimestamp uint32
+	txSSRC      uint32
+	dtmf        dtmfSender
+	// dtmfRx the detection of received DTMF digits, nil if not enabled
+	dtmfRx *dtmfReceiver
+	// underrunFill what is sent when the handler has no packet ready
+	underrunFill UnderrunFill
+	// rewriteHeaders the sequence numbers, timestamps and SSRC of the handler packets are assigned by Media
+	rewriteHeaders bool
+	silence        []byte
+	audio          *audioStream
+	// overlay the audio of AudioWriter sent in place of the packets of a custom MediaHandler, nil if none
+	overlay MediaHandler
+	// noTxAudio the audio of AudioWriter is never sent
+	noTxAudio bool
+	// audioRate the sample rate of AudioReader and AudioWriter
+	audioRate int
+	jitter    *jitterBuffer
+	receiving bool
+	// rxStopped the MediaHandler stopped receiving
+	rxStopped atomic.Bool
+	// rxMu serializes writes to the MediaHandler
+	rxMu    sync.Mutex
+	rxNoise *comfortNoise
+	// speech the voice activity detection of the received audio, nil if not enabled
+	speech *speechDetector
+	// inactivityTimeout onInactive see SetInactivityTimeout
+	inactivityTimeout time.Duration
+	onInactive        func(m *Media)
+	rxActivity        activity
+	// recorder records the received audio, nil if not recording
+	recorder atomic.Pointer[wavRecorder]
+	// RTCP
+	rtcpConn   *net.UDPConn
+	rtcpRemote *net.UDPAddr
+	// rtcpLatched rtcpRemote was learned from a received packet
+	rtcpLatched bool
+	rtcpMux     bool
+	stats       *rtpStats
+	// SRTP sessions of the outgoing and incoming streams, nil if not encrypted
+	srtpTx, srtpRx *srtpSession
+	done           chan struct{}
+	mu             sync.Mutex
+	closed         bool
+	logger         *slog.Logger
 }
 
 func (d *DialogClient) initMedia() error {
@@ -80,10 +143,14 @@ func (d *DialogClient) initMedia() error {
 		},
 		laudioDesc: d.ldesc.AudioDesc,
 		raudioDesc: d.rdesc.AudioDesc,
+		latchMode:  d.sc.Latching,
 		logger:     d.logger,
 	}
 	if err := d.media.negotiateCodecs(d.ldesc.AudioDesc.Codecs, d.rdesc.AudioDesc.Codecs); err != nil {
-		return err
+		return &SDPNegotiationError{Err: err}
+	}
+	if err := d.media.negotiateCrypto(d.ldesc.AudioDesc.Crypto, d.rdesc.AudioDesc.Crypto); err != nil {
+		return &SDPNegotiationError{Err: err}
 	}
 
 	if d.handler != nil {
@@ -95,6 +162,17 @@ func (d *DialogClient) initMedia() error {
 }
 
 func (d *DialogServer) newMedia() error {
+	// accept multiplexing if offered
+	d.ldesc.AudioDesc.RTCPMux = d.rdesc.AudioDesc.RTCPMux
+	// answer RTP/SAVP with our own key if offered
+	d.ldesc.AudioDesc.Crypto = nil
+	if len(d.rdesc.AudioDesc.Crypto) > 0 {
+		crypto, err := answerCrypto(d.rdesc.AudioDesc.Crypto)
+		if err != nil {
+			return err
+		}
+		d.ldesc.AudioDesc.Crypto = []CryptoDesc{crypto}
+	}
 	d.media = &Media{
 		remote: &net.UDPAddr{
 			IP:   net.ParseIP(d.rdesc.AudioDesc.Host),
@@ -102,15 +180,26 @@ func (d *DialogServer) newMedia() error {
 		},
 		laudioDesc: d.ldesc.AudioDesc,
 		raudioDesc: d.rdesc.AudioDesc,
+		latchMode:  d.ss.Latching,
 		logger:     d.logger,
 	}
+	if d.ss.MediaTimeout > 0 {
+		d.media.SetInactivityTimeout(d.ss.MediaTimeout, func(*Media) { d.onTimeout(TimeoutMedia) })
+	}
 	if err := d.media.negotiateCodecs(d.ldesc.AudioDesc.Codecs, d.rdesc.AudioDesc.Codecs); err != nil {
 		return err
 	}
+	if err := d.media.negotiateCrypto(d.ldesc.AudioDesc.Crypto, d.rdesc.AudioDesc.Crypto); err != nil {
+		return err
+	}
 	d.ldesc.AudioDesc.Codecs = []CodecDesc{d.media.audioCodec}
-	if d.media.eventCodec.Name != "" {
+	// an answer only includes the codecs of the offer
+	if d.media.remoteEvents {
 		d.ldesc.AudioDesc.Codecs = append(d.ldesc.AudioDesc.Codecs, d.media.eventCodec)
 	}
+	if d.media.cnCodec.Name != "" {
+		d.ldesc.AudioDesc.Codecs = append(d.ldesc.AudioDesc.Codecs, d.media.cnCodec)
+	}
 
 	if d.handler != nil {
 		d.media.handler = d.handler.OnMediaOpen(d.media)
@@ -129,23 +218,64 @@ func (m *Media) start() error {
 		"remote", m.remote.String(),
 		"codec", m.audioCodec.Name,
 	)
+	if m.handler == nil {
+		m.handler = NewMediaFrameHandler(m.audioStream())
+	}
+	if _, ok := m.handler.(*frameHandler); ok {
+		m.rewriteHeaders = true
+	}
+
 	var err error
 	m.conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(m.laudioDesc.Host), Port: m.laudioDesc.Port})
 	if err != nil {
 		return err
 	}
+	m.done = make(chan struct{})
+	m.latchSDPHostUntil = time.Now().Add(latchSDPHostTimeout)
+	m.receiving = m.laudioDesc.Direction == DirectionRecvonly || m.laudioDesc.Direction == DirectionSendrecv
+	// a send-only media may receive only sparse RTCP, it is not watched
+	if m.inactivityTimeout > 0 && m.receiving {
+		m.startWatchInactivity()
+	}
+	m.stats = newRTPStats(m.audioCodec.SampleRate)
+	m.rtcpMux = m.laudioDesc.RTCPMux && m.raudioDesc.RTCPMux
+	if err := m.startRTCP(); err != nil {
+		return err
+	}
+	go m.startSendRTCP()
 
-	if m.laudioDesc.Direction == DirectionRecvonly || m.laudioDesc.Direction == DirectionSendrecv {
+	if m.receiving {
 		if err := m.handler.StartRx(m, m.audioCodec); err != nil {
 			return err
 		}
-		go m.startReadMedia()
+		if m.jitter != nil {
+			go m.startPlayout()
+		}
+		if m.cnCodec.Name != "" {
+			if m.rxNoise, err = newComfortNoise(m.audioCodec, m.ptime()); err != nil {
+				m.logger.Warn("comfort noise is not expanded", "error", err)
+			} else {
+				go m.startComfortNoise()
+			}
+		}
 	}
+	go m.startReadMedia()
 	if m.laudioDesc.Direction == DirectionSendonly || m.laudioDesc.Direction == DirectionSendrecv {
 		if err := m.handler.StartTx(m, m.audioCodec); err != nil {
 			return err
 		}
-		go m.startSendMedia(m.laudioDesc.Ptime)
+		if _, ok := m.handler.(*frameHandler); !ok {
+			overlay := NewMediaFrameHandler(m.audioStream())
+			if err := overlay.StartTx(m, m.audioCodec); err != nil {
+				m.logger.Debug("unable to send audio of AudioWriter", "error", err)
+				m.closeAudioWriter()
+			} else {
+				m.overlay = overlay
+			}
+		}
+		go m.startSendMedia(m.ptime())
+	} else {
+		m.closeAudioWriter()
 	}
 	return nil
 }
@@ -154,6 +284,9 @@ func (m *Media) negotiateCodecs(lcodecs, rcodecs []CodecDesc) error {
 	// audio codec
 loop:
 	for _, rcodec := range rcodecs {
+		if rcodec.Name == CodecTelephoneEvent || rcodec.Name == CodecCN {
+			continue
+		}
 		for _, lcodec := range lcodecs {
 			if rcodec.equal(lcodec) {
 				m.audioCodec = rcodec
@@ -162,16 +295,18 @@ loop:
 		}
 	}
 	if m.audioCodec.Name == "" {
-		return errors.New("no available audio codec")
+		return errNoAudioCodec
 	}
 
 	// telephone-event codec
 	for _, rcodec := range rcodecs {
 		if rcodec.Name == CodecTelephoneEvent && rcodec.SampleRate == m.audioCodec.SampleRate {
 			m.eventCodec = rcodec
+			m.remoteEvents = true
 			break
 		}
 	}
+	// telephone-events are still accepted on the local payload type
 	if m.eventCodec.Name == "" {
 		for _, lcodec := range lcodecs {
 			if lcodec.Name == CodecTelephoneEvent && lcodec.SampleRate == m.audioCodec.SampleRate {
@@ -180,6 +315,20 @@ loop:
 			}
 		}
 	}
+
+	// comfort noise codec, only if supported by both sides
+cn:
+	for _, rcodec := range rcodecs {
+		if rcodec.Name != CodecCN || rcodec.SampleRate != m.audioCodec.SampleRate {
+			continue
+		}
+		for _, lcodec := range lcodecs {
+			if lcodec.Name == CodecCN && lcodec.SampleRate == rcodec.SampleRate {
+				m.cnCodec = rcodec
+				break cn
+			}
+		}
+	}
 	return nil
 }
 
@@ -193,34 +342,205 @@ func (m *Media) startReadMedia() {
 			}
 			break
 		}
-		if !m.remoteVerified {
-			m.remote = addr
-			m.remoteVerified = true
+		if m.rtcpMux && isRTCP(buf[:n]) {
+			m.onRTCP(buf[:n])
+			continue
+		}
+		data := buf[:n]
+		if m.srtpRx != nil {
+			if data, err = m.srtpRx.unprotectRTP(data); err != nil {
+				m.logger.Warn("failed to unprotect rtp packet", "error", err)
+				continue
+			}
 		}
 
-		if ok := m.handler.WriteRTPPacket(m, buf[:n]); !ok {
-			break
+		var packet RTPPacket
+		if err := packet.Unmarshal(data); err != nil {
+			m.logger.Warn("failed to parse rtp packet", "error", err)
+			continue
+		}
+		if !m.latch(addr, &packet) {
+			continue
+		}
+		m.rxActivity.touch()
+		arrival := time.Now()
+		m.stats.onReceive(&packet, len(data), arrival)
+		if !m.receiving || m.rxStopped.Load() {
+			continue
+		}
+
+		if m.jitter != nil {
+			m.jitter.push(&packet, data, arrival)
+			continue
+		}
+
+		if ok := m.writeRTPPacket(&packet, data); !ok {
+			m.rxStopped.Store(true)
 		}
 	}
 }
 
+// ptime returns the packetization time in milliseconds.
+func (m *Media) ptime() int {
+	if m.laudioDesc.Ptime > 0 {
+		return m.laudioDesc.Ptime
+	}
+	return defaultPtime
+}
+
+// samplesPerPacket returns the number of timestamp units in a packet.
+func (m *Media) samplesPerPacket() int {
+	return m.audioCodec.SampleRate * m.ptime() / 1000
+}
+
+// audioSamplesPerPacket returns the number of audio samples in a packet.
+func (m *Media) audioSamplesPerPacket() int {
+	return m.audioCodec.AudioRate() * m.ptime() / 1000
+}
+
 func (m *Media) startSendMedia(ptime int) {
-	t := time.NewTicker(time.Duration(ptime) * time.Millisecond)
-	for range t.C {
-		if m.closed {
-			break
-		}
+	m.txSequence = uint16(rand.Uint32())
+	m.txTimestamp = rand.Uint32()
+	m.txSSRC = rand.Uint32()
+	samples := m.samplesPerPacket()
+	interval := time.Duration(ptime) * time.Millisecond
 
+	clock := newMediaClock(m.audioCodec.SampleRate, time.Now())
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	// the first packet starts a talkspurt
+	marker := true
+	// silent the number of consecutive packet intervals without audio
+	silent := 0
+	// seqOffset the packets inserted into the stream of the handler, see SetRewriteRTPHeaders
+	var seqOffset uint16
+	for {
+		// wait for the media time of the next packet
+		if wait := time.Until(clock.deadline()); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-m.done:
+				return
+			case <-timer.C:
+			}
+		} else {
+			select {
+			case <-m.done:
+				return
+			default:
+			}
+		}
 		data, ok := m.handler.ReadRTPPacket(m)
 		if !ok {
 			break
 		}
+		// handlerData the handler has a packet ready, fromHandler the packet is the packet of the handler
+		handlerData, fromHandler := len(data) > 0, true
+		if m.overlay != nil {
+			if audio, _ := m.overlay.ReadRTPPacket(m); len(audio) > 0 {
+				data, fromHandler = audio, false
+			}
+		}
+		if late := time.Since(clock.deadline()); late > pacingMaxLate {
+			// the handler stalled, skip the missed packets to keep the timestamps aligned with the wall clock
+			missed := int(late/interval) * samples
+			clock.advance(missed)
+			m.txTimestamp += uint32(missed)
+			marker = true
+		}
 
-		if len(data) == 0 {
+		var packet RTPPacket
+		if len(data) > 0 {
+			if err := packet.Unmarshal(data); err != nil {
+				m.logger.Warn("failed to parse rtp packet", "error", err)
+				data = nil
+			}
+		}
+
+		duration := samples
+		// talkspurt the next packet starts a talkspurt
+		talkspurt := false
+		// handled the packet of the handler is sent
+		handled := false
+		if payload, timestamp, eventMarker := m.dtmf.next(m.txTimestamp, samples); payload != nil {
+			packet = RTPPacket{
+				Marker:      eventMarker,
+				PayloadType: uint8(m.eventCodec.PayloadType),
+				Timestamp:   timestamp,
+				Payload:     payload,
+			}
+		} else if len(data) > 0 {
+			silent = 0
+			handled = fromHandler
+			if m.rewriteHeaders || !fromHandler {
+				packet.Timestamp = m.txTimestamp
+				packet.Marker = packet.Marker || marker
+			} else {
+				// the inserted packets follow the timing of the handler
+				m.txTimestamp = packet.Timestamp
+				m.txSSRC = packet.SSRC
+				packet.SequenceNumber += seqOffset
+			}
+			duration = m.packetSamples(packet.Payload)
+		} else {
+			silent++
+			fill := m.underrunFill
+			if fill == UnderrunComfortNoise && m.cnCodec.Name == "" {
+				fill = UnderrunSilence
+			}
+			switch {
+			case fill == UnderrunComfortNoise && (silent-1)%comfortNoiseRefresh == 0:
+				packet = RTPPacket{
+					PayloadType: uint8(m.cnCodec.PayloadType),
+					Timestamp:   m.txTimestamp,
+					Payload:     []byte{comfortNoiseLevel},
+				}
+				talkspurt = true
+			case fill == UnderrunSilence && m.silenceFrame() != nil:
+				packet = RTPPacket{
+					Marker:      marker,
+					PayloadType: uint8(m.audioCodec.PayloadType),
+					Timestamp:   m.txTimestamp,
+					Payload:     m.silence,
+				}
+			default:
+				// gap, the next packet starts a talkspurt
+				clock.advance(duration)
+				m.txTimestamp += uint32(duration)
+				marker = true
+				continue
+			}
+		}
+		marker = talkspurt
+		clock.advance(duration)
+		m.txTimestamp += uint32(duration)
+
+		if !handled || m.rewriteHeaders {
+			packet.SequenceNumber = m.txSequence
+			packet.SSRC = m.txSSRC
+		}
+		if !handled && !handlerData {
+			// inserted, not in place of a packet of the handler
+			seqOffset++
+		}
+		m.txSequence = packet.SequenceNumber + 1
+		data, err := packet.Marshal()
+		if err != nil {
+			m.logger.Error("failed to marshal rtp packet", "error", err)
 			continue
 		}
+		m.stats.onSend(&packet, time.Now())
+		if m.srtpTx != nil {
+			if data, err = m.srtpTx.protectRTP(data); err != nil {
+				m.logger.Error("failed to protect rtp packet", "error", err)
+				continue
+			}
+		}
 
-		if _, err := m.conn.WriteToUDP(data, m.remote); err != nil {
+		m.mu.Lock()
+		remote := m.remote
+		m.mu.Unlock()
+		if _, err := m.conn.WriteToUDP(data, remote); err != nil {
 			m.logger.Error("failed to send media", "error", err)
 			break
 		}
@@ -231,13 +551,34 @@ func (m *Media) LocalAudioDesc() MediaDesc  { return m.laudioDesc }
 func (m *Media) RemoteAudioDesc() MediaDesc { return m.raudioDesc }
 
 func (m *Media) Close() error {
-	if m == nil || m.closed {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
 		return nil
 	}
 	m.closed = true
+	if m.audio != nil {
+		m.audio.close()
+	}
+	m.mu.Unlock()
+	if recorder := m.recorder.Load(); recorder != nil {
+		if err := recorder.close(); err != nil {
+			m.logger.Error("failed to close recording", "error", err)
+		}
+	}
 	m.logger.Info("close media")
+	if m.done != nil {
+		m.sendRTCP(true)
+		close(m.done)
+	}
 	if m.conn != nil {
 		_ = m.conn.Close()
 	}
+	if m.rtcpConn != nil {
+		_ = m.rtcpConn.Close()
+	}
 	return nil
 }
@@ -1,8 +1,11 @@
 package mrcp
 
 import (
+	"log/slog"
+	"net"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestMedia_negotiateCodecs(t *testing.T) {
@@ -15,6 +18,7 @@ func TestMedia_negotiateCodecs(t *testing.T) {
 		args           args
 		wantAudioCodec CodecDesc
 		wantEventCodec CodecDesc
+		wantCNCodec    CodecDesc
 		wantErr        bool
 	}{
 		{
@@ -69,6 +73,37 @@ func TestMedia_negotiateCodecs(t *testing.T) {
 			wantEventCodec: CodecDesc{PayloadType: 102, Name: CodecTelephoneEvent, SampleRate: 8000, FormatParams: map[string]string{"0-15": ""}},
 			wantErr:        false,
 		},
+		{
+			name: "dynamic payload type",
+			args: args{
+				lcodecs: []CodecDesc{
+					{PayloadType: 0, Name: "PCMU", SampleRate: 8000},
+					{PayloadType: 96, Name: "L16", SampleRate: 16000},
+				},
+				rcodecs: []CodecDesc{
+					{PayloadType: 97, Name: "L16", SampleRate: 16000},
+					{PayloadType: 0, Name: "PCMU", SampleRate: 8000},
+				},
+			},
+			wantAudioCodec: CodecDesc{PayloadType: 97, Name: "L16", SampleRate: 16000},
+			wantErr:        false,
+		},
+		{
+			name: "comfort noise",
+			args: args{
+				lcodecs: []CodecDesc{
+					{PayloadType: 0, Name: "PCMU", SampleRate: 8000},
+					{PayloadType: 13, Name: CodecCN, SampleRate: 8000},
+				},
+				rcodecs: []CodecDesc{
+					{PayloadType: 13, Name: CodecCN, SampleRate: 8000},
+					{PayloadType: 0, Name: "PCMU", SampleRate: 8000},
+				},
+			},
+			wantAudioCodec: CodecDesc{PayloadType: 0, Name: "PCMU", SampleRate: 8000},
+			wantCNCodec:    CodecDesc{PayloadType: 13, Name: CodecCN, SampleRate: 8000},
+			wantErr:        false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -85,6 +120,245 @@ func TestMedia_negotiateCodecs(t *testing.T) {
 				t.Errorf("eventCodec = %v, want %v", m.eventCodec, tt.wantEventCodec)
 				return
 			}
+			if !reflect.DeepEqual(m.cnCodec, tt.wantCNCodec) {
+				t.Errorf("cnCodec = %v, want %v", m.cnCodec, tt.wantCNCodec)
+				return
+			}
+		})
+	}
+}
+
+func TestMedia_startSendMedia(t *testing.T) {
+	codec := CodecDesc{PayloadType: 0, Name: "PCMU", SampleRate: 8000}
+	type args struct {
+		fill UnderrunFill
+		cn   CodecDesc
+		// read returns the payload of the n-th packet
+		read func(n int) []byte
+	}
+	tests := []struct {
+		name string
+		args args
+		// wantSilence the number of silence packets expected in the first 10 packets
+		wantSilence int
+		// wantCN the number of CN packets expected in the first 10 packets
+		wantCN int
+		// wantGap a timestamp gap is expected in the first 10 packets
+		wantGap bool
+	}{
+		{
+			name: "steady",
+			args: args{read: func(n int) []byte { return make([]byte, 160) }},
+		},
+		{
+			name: "variable ptime",
+			args: args{read: func(n int) []byte { return make([]byte, 80+160*(n%2)) }},
+		},
+		{
+			name: "stalled",
+			args: args{read: func(n int) []byte {
+				if n == 3 {
+					time.Sleep(250 * time.Millisecond)
+				}
+				return make([]byte, 160)
+			}},
+			wantGap: true,
+		},
+		{
+			name: "underrun gap",
+			args: args{read: func(n int) []byte {
+				if n == 3 || n == 4 {
+					return nil
+				}
+				return make([]byte, 160)
+			}},
+			wantGap: true,
+		},
+		{
+			name: "underrun silence",
+			args: args{fill: UnderrunSilence, read: func(n int) []byte {
+				if n == 3 || n == 4 {
+					return nil
+				}
+				return make([]byte, 160)
+			}},
+			wantSilence: 2,
+		},
+		{
+			name: "underrun comfort noise",
+			args: args{fill: UnderrunComfortNoise, cn: CodecDesc{PayloadType: 13, Name: CodecCN, SampleRate: 8000}, read: func(n int) []byte {
+				if n == 3 || n == 4 {
+					return nil
+				}
+				return make([]byte, 160)
+			}},
+			wantCN:  1,
+			wantGap: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer receiver.Close()
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var n int
+			m := &Media{
+				conn:           conn,
+				remote:         receiver.LocalAddr().(*net.UDPAddr),
+				audioCodec:     codec,
+				cnCodec:        tt.args.cn,
+				underrunFill:   tt.args.fill,
+				rewriteHeaders: true,
+				handler: MediaHandlerFunc{ReadRTPPacketFunc: func(m *Media) ([]byte, bool) {
+					n++
+					payload := tt.args.read(n)
+					if payload == nil {
+						return nil, true
+					}
+					packet := RTPPacket{PayloadType: 0, Payload: payload}
+					data, _ := packet.Marshal()
+					return data, true
+				}},
+				done:   make(chan struct{}),
+				stats:  newRTPStats(codec.SampleRate),
+				logger: slog.Default(),
+			}
+			go m.startSendMedia(m.ptime())
+			defer m.Close()
+
+			var (
+				first    RTPPacket
+				start    time.Time
+				silence  int
+				cn       int
+				gap      bool
+				expected uint32
+			)
+			buf := make([]byte, 1500)
+			for i := 0; i < 10; i++ {
+				_ = receiver.SetReadDeadline(time.Now().Add(time.Second))
+				size, _, err := receiver.ReadFromUDP(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				arrival := time.Now()
+				var packet RTPPacket
+				if err := packet.Unmarshal(buf[:size]); err != nil {
+					t.Fatal(err)
+				}
+				if i == 0 {
+					first, start = packet, arrival
+				} else {
+					if packet.SequenceNumber != first.SequenceNumber+uint16(i) {
+						t.Errorf("packet %d sequence number got = %d, want %d", i, packet.SequenceNumber, first.SequenceNumber+uint16(i))
+					}
+					if packet.Timestamp != expected {
+						gap = true
+						if !packet.Marker {
+							t.Errorf("packet %d after a gap has no marker", i)
+						}
+					}
+				}
+				expected = packet.Timestamp + uint32(len(packet.Payload))
+				if packet.PayloadType == 13 {
+					cn++
+					expected = packet.Timestamp + 160
+				} else if packet.Payload[0] == 0xFF {
+					silence++
+				}
+
+				// the timestamp follows the wall clock
+				media := time.Duration(packet.Timestamp-first.Timestamp) * time.Second / time.Duration(codec.SampleRate)
+				if drift := arrival.Sub(start) - media; drift > 30*time.Millisecond || drift < -30*time.Millisecond {
+					t.Errorf("packet %d drift got = %v", i, drift)
+				}
+			}
+			if silence != tt.wantSilence {
+				t.Errorf("silence packets got = %d, want %d", silence, tt.wantSilence)
+			}
+			if cn != tt.wantCN {
+				t.Errorf("cn packets got = %d, want %d", cn, tt.wantCN)
+			}
+			if gap != tt.wantGap {
+				t.Errorf("gap got = %v, want %v", gap, tt.wantGap)
+			}
 		})
 	}
 }
+
+func TestMedia_startSendMedia_handlerHeaders(t *testing.T) {
+	receiver, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the handler relays packets with a silence gap of 1s after the second one,
+	// nothing is ready at the third read and a silence packet is inserted
+	var n int
+	m := &Media{
+		conn:         conn,
+		remote:       receiver.LocalAddr().(*net.UDPAddr),
+		audioCodec:   CodecDesc{PayloadType: 0, Name: "PCMU", SampleRate: 8000},
+		underrunFill: UnderrunSilence,
+		handler: MediaHandlerFunc{ReadRTPPacketFunc: func(m *Media) ([]byte, bool) {
+			n++
+			if n == 3 {
+				return nil, true
+			}
+			packet := RTPPacket{PayloadType: 0, SequenceNumber: uint16(100 + n), Timestamp: uint32(1000 + 160*n), SSRC: 0x1234, Payload: make([]byte, 160)}
+			if n > 2 {
+				packet.Timestamp += 8000
+			}
+			data, _ := packet.Marshal()
+			return data, true
+		}},
+		done:   make(chan struct{}),
+		stats:  newRTPStats(8000),
+		logger: slog.Default(),
+	}
+	go m.startSendMedia(m.ptime())
+	defer m.Close()
+
+	type header struct {
+		seq       uint16
+		timestamp uint32
+		ssrc      uint32
+	}
+	// the sequence numbers of the handler are shifted by the inserted packet
+	want := []header{
+		{seq: 101, timestamp: 1160, ssrc: 0x1234},
+		{seq: 102, timestamp: 1320, ssrc: 0x1234},
+		{seq: 103, timestamp: 1480, ssrc: 0x1234},
+		{seq: 105, timestamp: 9640, ssrc: 0x1234},
+		{seq: 106, timestamp: 9800, ssrc: 0x1234},
+	}
+	var got []header
+	buf := make([]byte, 1500)
+	for range want {
+		_ = receiver.SetReadDeadline(time.Now().Add(time.Second))
+		size, _, err := receiver.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var packet RTPPacket
+		if err := packet.Unmarshal(buf[:size]); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, header{seq: packet.SequenceNumber, timestamp: packet.Timestamp, ssrc: packet.SSRC})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("startSendMedia() got = %v, want %v", got, want)
+	}
+}
@@ -29,6 +29,7 @@ const (
 	MethodBargeInOccurred       = "BARGE-IN-OCCURRED"
 	MethodControl               = "CONTROL"
 	MethodDefineLexicon         = "DEFINE-LEXICON"
+	MethodRecord                = "RECORD"
 )
 
 const (
@@ -36,6 +37,7 @@ const (
 	HeaderContentLength     = "Content-Length"
 	HeaderCompletionCause   = "Completion-Cause"
 	HeaderChannelIdentifier = "Channel-Identifier"
+	HeaderSensitivityLevel  = "Sensitivity-Level"
 )
 
 const (
@@ -58,6 +60,11 @@ func (c CompletionCause) Marshal(resource Resource) string {
 			return ""
 		}
 		return synthCompletionCauses[c]
+	case ResourceRecorder:
+		if c >= _RecorderCompletionCauseMax {
+			return ""
+		}
+		return recorderCompletionCauses[c]
 	default:
 		return ""
 	}
@@ -98,6 +105,16 @@ const (
 	_RecogCompletionCauseMax
 )
 
+const (
+	RecorderCompletionCauseSuccessSilence CompletionCause = iota
+	RecorderCompletionCauseSuccessMaxTime
+	RecorderCompletionCauseNoInputTimeout
+	RecorderCompletionCauseUriFailure
+	RecorderCompletionCauseError
+
+	_RecorderCompletionCauseMax
+)
+
 var (
 	synthCompletionCauses = []string{
 		"000 normal",
@@ -128,6 +145,13 @@ var (
 		"015 no-match-maxtime",
 		"016 grammar-definition-failure",
 	}
+	recorderCompletionCauses = []string{
+		"000 success-silence",
+		"001 success-maxtime",
+		"002 no-input-timeout",
+		"003 uri-failure",
+		"004 error",
+	}
 )
 
 type MessageType uint8
@@ -328,6 +352,15 @@ func (m *Message) GetCompletionCause() CompletionCause {
 	return CompletionCause(cause)
 }
 
+// GetSensitivityLevel returns the Sensitivity-Level header from 0.0 to 1.0, false if absent or invalid.
+func (m *Message) GetSensitivityLevel() (float64, bool) {
+	level, err := strconv.ParseFloat(m.GetHeader(HeaderSensitivityLevel), 64)
+	if err != nil || level < 0 || level > 1 {
+		return 0, false
+	}
+	return level, true
+}
+
 func (m *Message) GetName() string               { return m.name }
 func (m *Message) GetMessageType() MessageType   { return m.messageType }
 func (m *Message) GetRequestId() uint32          { return m.requestId }
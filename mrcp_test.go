@@ -250,3 +250,25 @@ func TestMessage_GetCompletionCause(t *testing.T) {
 		})
 	}
 }
+
+func TestMessage_GetSensitivityLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    float64
+		wantOk  bool
+	}{
+		{name: "level", headers: map[string]string{HeaderSensitivityLevel: "0.8"}, want: 0.8, wantOk: true},
+		{name: "lowest", headers: map[string]string{HeaderSensitivityLevel: "0.0"}, want: 0, wantOk: true},
+		{name: "absent", headers: map[string]string{}, want: 0, wantOk: false},
+		{name: "out of range", headers: map[string]string{HeaderSensitivityLevel: "1.5"}, want: 0, wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Message{headers: tt.headers}
+			if got, ok := m.GetSensitivityLevel(); got != tt.want || ok != tt.wantOk {
+				t.Errorf("GetSensitivityLevel() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package mrcp
+
+import (
+	"context"
+	"fmt"
+	"github.com/emiago/sipgo/sip"
+	"strconv"
+)
+
+const (
+	// HeaderActiveDialogs the number of dialogs of a Server, in the response to OPTIONS
+	HeaderActiveDialogs = "X-Active-Dialogs"
+	// HeaderAvailableDialogs the number of further dialogs a Server can accept, in the response to OPTIONS
+	HeaderAvailableDialogs = "X-Available-Dialogs"
+
+	allowedMethods = "INVITE, ACK, CANCEL, BYE, UPDATE, OPTIONS"
+)
+
+// Capacity the load advertised by a Server in the response to OPTIONS, -1 if unknown
+type Capacity struct {
+	// ActiveDialogs the number of dialogs
+	ActiveDialogs int
+	// AvailableDialogs the number of further dialogs the server can accept
+	AvailableDialogs int
+}
+
+func parseCapacity(res *sip.Response) Capacity {
+	capacity := Capacity{ActiveDialogs: -1, AvailableDialogs: -1}
+	if h := res.GetHeader(HeaderActiveDialogs); h != nil {
+		if n, err := strconv.Atoi(h.Value()); err == nil {
+			capacity.ActiveDialogs = n
+		}
+	}
+	if h := res.GetHeader(HeaderAvailableDialogs); h != nil {
+		if n, err := strconv.Atoi(h.Value()); err == nil {
+			capacity.AvailableDialogs = n
+		}
+	}
+	return capacity
+}
+
+// newOptionsResponse returns the 200 response to OPTIONS with the supported methods and bodies.
+func newOptionsResponse(req *sip.Request) *sip.Response {
+	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	res.AppendHeader(sip.NewHeader("Allow", allowedMethods))
+	res.AppendHeader(sip.NewHeader("Accept", "application/sdp"))
+	res.AppendHeader(sip.NewHeader("Supported", "timer"))
+	return res
+}
+
+// Capacity returns the load of the server.
+func (s *Server) Capacity() Capacity {
+	var capacity Capacity
+	s.dialogs.Range(func(_, _ any) bool {
+		capacity.ActiveDialogs++
+		return true
+	})
+	if s.porter != nil {
+		capacity.AvailableDialogs = s.porter.available()
+	}
+	if s.MaxDialogs > 0 {
+		s.admission.mu.Lock()
+		capacity.AvailableDialogs = min(capacity.AvailableDialogs, max(0, s.MaxDialogs-s.admission.dialogs))
+		s.admission.mu.Unlock()
+	}
+	return capacity
+}
+
+func (s *Server) onOptions(req *sip.Request, tx sip.ServerTransaction) {
+	capacity := s.Capacity()
+	res := newOptionsResponse(req)
+	res.AppendHeader(sip.NewHeader(HeaderActiveDialogs, strconv.Itoa(capacity.ActiveDialogs)))
+	res.AppendHeader(sip.NewHeader(HeaderAvailableDialogs, strconv.Itoa(capacity.AvailableDialogs)))
+	if err := tx.Respond(res); err != nil {
+		s.Logger.Warn("failed to respond OPTIONS request", "callId", req.CallID(), "error", err)
+	}
+}
+
+func (c *Client) onOptions(req *sip.Request, tx sip.ServerTransaction) {
+	if err := tx.Respond(newOptionsResponse(req)); err != nil {
+		c.Logger.Error("failed to respond to options", "callId", req.CallID().Value(), "error", err)
+	}
+}
+
+// Ping sends OPTIONS to the SIP server at raddr, returns its capacity if it answers 200.
+func (c *Client) Ping(ctx context.Context, raddr string) (Capacity, error) {
+	capacity := Capacity{ActiveDialogs: -1, AvailableDialogs: -1}
+	rhost, rport, err := sip.ParseAddr(raddr)
+	if err != nil {
+		return capacity, err
+	}
+
+	req := sip.NewRequest(sip.OPTIONS, sip.Uri{Host: rhost, Port: rport})
+	req.AppendHeader(sip.NewHeader("Accept", "application/sdp"))
+	res, err := c.ua.Client.Do(ctx, req)
+	if err != nil {
+		return capacity, fmt.Errorf("failed to send sip options: %w", err)
+	}
+	if !res.IsSuccess() {
+		return capacity, fmt.Errorf("sip options rejected: %d %s", res.StatusCode, res.Reason)
+	}
+	return parseCapacity(res), nil
+}
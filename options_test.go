@@ -0,0 +1,66 @@
+package mrcp
+
+import (
+	"github.com/emiago/sipgo/sip"
+	"reflect"
+	"testing"
+)
+
+func TestServer_Capacity(t *testing.T) {
+	porter, err := newPorter(20000, 20010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{porter: porter}
+	if _, err := porter.get(); err != nil {
+		t.Fatal(err)
+	}
+	s.dialogs.Store("a", &DialogServer{})
+
+	want := Capacity{ActiveDialogs: 1, AvailableDialogs: 4}
+	if got := s.Capacity(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Capacity() got = %v, want %v", got, want)
+	}
+}
+
+func Test_parseCapacity(t *testing.T) {
+	req := sip.NewRequest(sip.OPTIONS, sip.Uri{Host: "127.0.0.1"})
+	req.AppendHeader(&sip.ToHeader{Address: req.Recipient, Params: sip.NewParams()})
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    Capacity
+	}{
+		{
+			name: "capacity",
+			headers: map[string]string{
+				HeaderActiveDialogs:    "3",
+				HeaderAvailableDialogs: "97",
+			},
+			want: Capacity{ActiveDialogs: 3, AvailableDialogs: 97},
+		},
+		{
+			name: "unknown",
+			want: Capacity{ActiveDialogs: -1, AvailableDialogs: -1},
+		},
+		{
+			name:    "invalid",
+			headers: map[string]string{HeaderActiveDialogs: "x"},
+			want:    Capacity{ActiveDialogs: -1, AvailableDialogs: -1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := newOptionsResponse(req)
+			for k, v := range tt.headers {
+				res.AppendHeader(sip.NewHeader(k, v))
+			}
+			if got := parseCapacity(res); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCapacity() got = %v, want %v", got, tt.want)
+			}
+			if got := res.GetHeader("Allow").Value(); got != allowedMethods {
+				t.Errorf("Allow got = %v, want %v", got, allowedMethods)
+			}
+		})
+	}
+}
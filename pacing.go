@@ -0,0 +1,92 @@
+package mrcp
+
+import (
+	"time"
+)
+
+// pacingMaxLate the maximum lateness caught up by sending packets back-to-back,
+// the sender skips the missed packets beyond it
+const pacingMaxLate = 100 * time.Millisecond
+
+// UnderrunFill what is sent when the MediaHandler has no packet ready
+type UnderrunFill int
+
+const (
+	// UnderrunGap nothing is sent, the timestamp advances and the next packet has the marker bit set
+	UnderrunGap UnderrunFill = iota
+	// UnderrunSilence silence frames of the audio codec are sent
+	UnderrunSilence
+	// UnderrunComfortNoise CN packets are sent at the start of and periodically during the silence,
+	// falls back to UnderrunSilence if CN is not negotiated
+	UnderrunComfortNoise
+)
+
+// mediaClock maps the RTP timestamps of the outgoing stream to the monotonic clock.
+type mediaClock struct {
+	rate  int
+	start time.Time
+	// elapsed the timestamp units sent since start
+	elapsed int64
+}
+
+func newMediaClock(rate int, start time.Time) *mediaClock {
+	return &mediaClock{rate: rate, start: start}
+}
+
+// deadline returns the time the next packet is due.
+func (c *mediaClock) deadline() time.Time {
+	return c.start.Add(time.Duration(c.elapsed * int64(time.Second) / int64(c.rate)))
+}
+
+func (c *mediaClock) advance(units int) {
+	c.elapsed += int64(units)
+}
+
+// SetUnderrunFill sets what is sent when the MediaHandler has no packet ready,
+// it must be called before the media starts, e.g. in DialogHandler.OnMediaOpen.
+// Default: UnderrunGap
+func (m *Media) SetUnderrunFill(fill UnderrunFill) {
+	m.underrunFill = fill
+}
+
+// SetRewriteRTPHeaders sets whether the sequence numbers, timestamps and SSRC of the packets
+// read from the MediaHandler are assigned by Media, always the case with NewMediaFrameHandler.
+// Otherwise the header of the MediaHandler is kept, e.g. to relay media with its original timing,
+// the sequence numbers are shifted by the packets inserted by Media, e.g. comfort noise,
+// and the inserted packets follow the timestamps and SSRC of the MediaHandler.
+// It must be called before the media starts, e.g. in DialogHandler.OnMediaOpen.
+// Default: false
+func (m *Media) SetRewriteRTPHeaders(rewrite bool) {
+	m.rewriteHeaders = rewrite
+}
+
+// silenceFrame returns the payload of a silent packet, nil if the audio codec is not supported.
+func (m *Media) silenceFrame() []byte {
+	if m.silence == nil {
+		transcoder, err := newAudioTranscoder(m.audioCodec)
+		if err != nil {
+			return nil
+		}
+		if m.silence, err = transcoder.Encode(make([]byte, 2*m.audioSamplesPerPacket())); err != nil {
+			return nil
+		}
+	}
+	return m.silence
+}
+
+// packetSamples returns the duration of an audio payload in timestamp units.
+func (m *Media) packetSamples(payload []byte) int {
+	if len(payload) == 0 {
+		return m.samplesPerPacket()
+	}
+	switch m.audioCodec.Name {
+	case "PCMU", "PCMA":
+		return len(payload)
+	case "L16":
+		return len(payload) / 2
+	case "G722":
+		// two 16 kHz samples per byte at the 8000 Hz clock rate
+		return len(payload)
+	}
+	return m.samplesPerPacket()
+}
@@ -0,0 +1,141 @@
+package pcm
+
+import (
+	"math"
+)
+
+const (
+	// dtmfBlock the number of samples analysed at a time at 8000 Hz, about 12.75ms
+	dtmfBlock = 102
+	// dtmfMinLevel the minimum level of each tone in dBov
+	dtmfMinLevel = -36.0
+	// dtmfNormalTwist the maximum level of the row tone above the column tone in dB
+	dtmfNormalTwist = 8.0
+	// dtmfReverseTwist the maximum level of the column tone above the row tone in dB
+	dtmfReverseTwist = 4.0
+	// dtmfRelativePeak the minimum level of a tone above the other tones of its group in dB
+	dtmfRelativePeak = 8.0
+	// dtmfToneRatio the minimum share of the two tones in the energy of the block,
+	// speech and music spread their energy over many frequencies
+	dtmfToneRatio = 0.7
+)
+
+var (
+	dtmfRows    = [4]float64{697, 770, 852, 941}
+	dtmfColumns = [4]float64{1209, 1336, 1477, 1633}
+	dtmfDigits  = [4][4]byte{
+		{'1', '2', '3', 'A'},
+		{'4', '5', '6', 'B'},
+		{'7', '8', '9', 'C'},
+		{'*', '0', '#', 'D'},
+	}
+)
+
+// DTMFDetector detects in-band DTMF digits in 16-bit little-endian PCM with the Goertzel algorithm.
+// A digit is reported once when it has been detected in two consecutive blocks,
+// it is reported again only after the tone has stopped for two blocks.
+type DTMFDetector struct {
+	blockSize int
+	// rowCoeffs colCoeffs the Goertzel coefficients of the row and column frequencies
+	rowCoeffs, colCoeffs [4]float64
+	// minEnergy the minimum energy of each tone in a block
+	minEnergy float64
+	// last the digit detected in the previous block, current the digit being reported, 0 if none
+	last, current byte
+	pending       []float64
+}
+
+func NewDTMFDetector(sampleRate int) *DTMFDetector {
+	if sampleRate <= 0 {
+		sampleRate = 8000
+	}
+	d := &DTMFDetector{blockSize: dtmfBlock * sampleRate / 8000}
+	for i := range dtmfRows {
+		d.rowCoeffs[i] = 2 * math.Cos(2*math.Pi*dtmfRows[i]/float64(sampleRate))
+		d.colCoeffs[i] = 2 * math.Cos(2*math.Pi*dtmfColumns[i]/float64(sampleRate))
+	}
+	// the energy of a sine wave at the minimum level
+	amplitude := math.MaxInt16 * math.Pow(10, dtmfMinLevel/20) * math.Sqrt2
+	d.minEnergy = amplitude * amplitude / 2 * float64(d.blockSize)
+	return d
+}
+
+// Process analyses the samples, returns the newly detected digits.
+// Samples are buffered until a complete block is available.
+func (d *DTMFDetector) Process(samples []byte) []byte {
+	for i := 0; i+1 < len(samples); i += 2 {
+		d.pending = append(d.pending, float64(int16(samples[i])|int16(samples[i+1])<<8))
+	}
+	var digits []byte
+	off := 0
+	for ; off+d.blockSize <= len(d.pending); off += d.blockSize {
+		hit := d.detect(d.pending[off : off+d.blockSize])
+		if hit == d.last && hit != d.current {
+			d.current = hit
+			if hit != 0 {
+				digits = append(digits, hit)
+			}
+		}
+		d.last = hit
+	}
+	d.pending = append(d.pending[:0], d.pending[off:]...)
+	return digits
+}
+
+// goertzel returns the energy of the block at the frequency of coeff,
+// scaled to the energy of the samples of a sine wave at that frequency.
+func goertzel(block []float64, coeff float64) float64 {
+	var s1, s2 float64
+	for _, x := range block {
+		s1, s2 = x+coeff*s1-s2, s1
+	}
+	return (s1*s1 + s2*s2 - coeff*s1*s2) * 2 / float64(len(block))
+}
+
+// detect returns the digit of the block, 0 if none.
+func (d *DTMFDetector) detect(block []float64) byte {
+	var total float64
+	for _, x := range block {
+		total += x * x
+	}
+
+	var rows, cols [4]float64
+	row, col := 0, 0
+	for i := range rows {
+		rows[i] = goertzel(block, d.rowCoeffs[i])
+		cols[i] = goertzel(block, d.colCoeffs[i])
+		if rows[i] > rows[row] {
+			row = i
+		}
+		if cols[i] > cols[col] {
+			col = i
+		}
+	}
+
+	// energy
+	if rows[row] < d.minEnergy || cols[col] < d.minEnergy {
+		return 0
+	}
+	// twist
+	if rows[row] > cols[col]*dbToRatio(dtmfNormalTwist) || cols[col] > rows[row]*dbToRatio(dtmfReverseTwist) {
+		return 0
+	}
+	// relative peaks, a single tone in each group
+	for i := range rows {
+		if i != row && rows[i]*dbToRatio(dtmfRelativePeak) > rows[row] {
+			return 0
+		}
+		if i != col && cols[i]*dbToRatio(dtmfRelativePeak) > cols[col] {
+			return 0
+		}
+	}
+	// talk-off protection, the two tones carry most of the energy
+	if rows[row]+cols[col] < dtmfToneRatio*total {
+		return 0
+	}
+	return dtmfDigits[row][col]
+}
+
+func dbToRatio(db float64) float64 {
+	return math.Pow(10, db/10)
+}
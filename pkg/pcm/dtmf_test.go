@@ -0,0 +1,129 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// dtmfTone is a tone of the signal generated by dtmfSignal, a zero row is a pause
+type dtmfTone struct {
+	row, col float64
+	// rowLevel colLevel in dBov
+	rowLevel, colLevel float64
+	// others the frequencies of other tones at the row level
+	others []float64
+	ms     int
+}
+
+// dtmfSignal returns PCM of the tones with noise at -50 dBov.
+func dtmfSignal(rate int, tones []dtmfTone) []byte {
+	r := rand.New(rand.NewSource(1))
+	noise := math.MaxInt16 * math.Pow(10, -50.0/20) * math.Sqrt(3)
+	var samples []byte
+	for _, tone := range tones {
+		rowAmp := math.MaxInt16 * math.Pow(10, tone.rowLevel/20) * math.Sqrt2
+		colAmp := math.MaxInt16 * math.Pow(10, tone.colLevel/20) * math.Sqrt2
+		for i := 0; i < rate*tone.ms/1000; i++ {
+			t := float64(i) / float64(rate)
+			v := (2*r.Float64() - 1) * noise
+			if tone.row > 0 {
+				v += rowAmp*math.Sin(2*math.Pi*tone.row*t) + colAmp*math.Sin(2*math.Pi*tone.col*t)
+			}
+			for _, f := range tone.others {
+				v += rowAmp * math.Sin(2*math.Pi*f*t)
+			}
+			samples = binary.LittleEndian.AppendUint16(samples, uint16(int16(v)))
+		}
+	}
+	return samples
+}
+
+func TestDTMFDetector_Process(t *testing.T) {
+	pause := dtmfTone{ms: 50}
+	tests := []struct {
+		name  string
+		rate  int
+		tones []dtmfTone
+		want  []byte
+	}{
+		{
+			name: "digits",
+			rate: 8000,
+			tones: []dtmfTone{
+				pause,
+				{row: 697, col: 1209, rowLevel: -10, colLevel: -10, ms: 60}, pause,
+				{row: 941, col: 1336, rowLevel: -10, colLevel: -10, ms: 60}, pause,
+				{row: 852, col: 1477, rowLevel: -10, colLevel: -10, ms: 60}, pause,
+				{row: 941, col: 1477, rowLevel: -10, colLevel: -10, ms: 60}, pause,
+				{row: 770, col: 1633, rowLevel: -10, colLevel: -10, ms: 60}, pause,
+			},
+			want: []byte("109#B"),
+		},
+		{
+			name: "wideband",
+			rate: 16000,
+			tones: []dtmfTone{
+				pause,
+				{row: 770, col: 1336, rowLevel: -20, colLevel: -18, ms: 60}, pause,
+				{row: 770, col: 1336, rowLevel: -20, colLevel: -18, ms: 60}, pause,
+			},
+			want: []byte("55"),
+		},
+		{
+			name: "long tone reported once",
+			rate: 8000,
+			tones: []dtmfTone{
+				{row: 852, col: 1209, rowLevel: -10, colLevel: -10, ms: 500},
+			},
+			want: []byte("7"),
+		},
+		{
+			name: "too quiet",
+			rate: 8000,
+			tones: []dtmfTone{
+				{row: 697, col: 1209, rowLevel: -45, colLevel: -45, ms: 100},
+			},
+		},
+		{
+			name: "twist",
+			rate: 8000,
+			tones: []dtmfTone{
+				{row: 697, col: 1209, rowLevel: -5, colLevel: -20, ms: 100}, pause,
+				{row: 697, col: 1209, rowLevel: -20, colLevel: -10, ms: 100},
+			},
+		},
+		{
+			name: "too short",
+			rate: 8000,
+			tones: []dtmfTone{
+				pause, {row: 697, col: 1209, rowLevel: -10, colLevel: -10, ms: 15}, pause,
+			},
+		},
+		{
+			name: "talk-off",
+			rate: 8000,
+			tones: []dtmfTone{
+				// a voiced sound with both frequencies among other strong harmonics
+				{row: 697, col: 1209, rowLevel: -15, colLevel: -15, others: []float64{400, 1000}, ms: 100},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := dtmfSignal(tt.rate, tt.tones)
+			d := NewDTMFDetector(tt.rate)
+			var got []byte
+			// 20ms packets
+			size := 2 * tt.rate / 50
+			for i := 0; i < len(samples); i += size {
+				got = append(got, d.Process(samples[i:min(i+size, len(samples))])...)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Process() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestG722(t *testing.T) {
+	// 4ms of a 1 kHz tone at 16 kHz, the codes and the decoded samples of the
+	// spandsp implementation derived from the ITU-T reference code
+	samples := sine(16000, 64, 1000, 8000)
+	wantCodes := []byte{
+		0xFA, 0x94, 0x25, 0x88, 0x21, 0x91, 0xA0, 0xA0,
+		0x60, 0xAF, 0xC8, 0xC6, 0xD1, 0xDE, 0xF0, 0xEB,
+		0xEE, 0x7B, 0xD4, 0xD0, 0xD3, 0xBE, 0xB0, 0xEC,
+		0xF0, 0xFC, 0x55, 0xD1, 0x94, 0xFE, 0xF1, 0xAD,
+	}
+	wantSamples := []int16{
+		0, -1, -1, 0, 0, -1, -1, 0,
+		0, -3, 0, 8, -2, -21, -3, 39,
+		11, -77, -26, 105, 79, -121, -62, 256,
+		458, 675, 1244, 2130, 2795, 2368, 275, -2941,
+		-5966, -7821, -8246, -7383, -5495, -2952, -2, 2991,
+		5522, 7189, 7832, 7327, 5665, 3072, -24, -3102,
+		-5569, -7131, -7771, -7377, -5689, -2947, 153, 3130,
+		5735, 7584, 8123, 7270, 5424, 2913, -74, -3112,
+	}
+
+	codes := make([]byte, len(samples)/4)
+	if err := NewG722Encoder().Encode(samples, codes); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(codes, wantCodes) {
+		t.Errorf("Encode() got = % X, want % X", codes, wantCodes)
+	}
+
+	decoded := make([]byte, 4*len(wantCodes))
+	if err := NewG722Decoder().Decode(wantCodes, decoded); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]int16, len(decoded)/2)
+	for i := range got {
+		got[i] = int16(binary.LittleEndian.Uint16(decoded[2*i:]))
+	}
+	if !reflect.DeepEqual(got, wantSamples) {
+		t.Errorf("Decode() got = %v, want %v", got, wantSamples)
+	}
+}
+
+func TestG722_frames(t *testing.T) {
+	// a 300 Hz tone at 16 kHz survives encoding with the delay of the QMF filters
+	const n, delay = 3200, 22
+	samples := sine(16000, n, 300, 8000)
+	enc, dec := NewG722Encoder(), NewG722Decoder()
+	var decoded []byte
+	// frame by frame, the state is kept across frames
+	for i := 0; i < len(samples); i += 640 {
+		codes := make([]byte, 160)
+		if err := enc.Encode(samples[i:i+640], codes); err != nil {
+			t.Fatal(err)
+		}
+		frame := make([]byte, 640)
+		if err := dec.Decode(codes, frame); err != nil {
+			t.Fatal(err)
+		}
+		decoded = append(decoded, frame...)
+	}
+
+	var signal, noise float64
+	for i := 400; i < n-delay; i++ {
+		want := float64(int16(binary.LittleEndian.Uint16(samples[2*i:])))
+		got := float64(int16(binary.LittleEndian.Uint16(decoded[2*(i+delay):])))
+		signal += want * want
+		noise += (want - got) * (want - got)
+	}
+	if snr := 10 * math.Log10(signal/noise); snr < 40 {
+		t.Errorf("snr got = %.1f dB, want >= 40 dB", snr)
+	}
+}
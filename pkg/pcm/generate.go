@@ -0,0 +1,104 @@
+package pcm
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ToneSegment a part of a tone sequence, silence if Freqs is empty
+type ToneSegment struct {
+	// Freqs the frequencies of the sine waves mixed in the segment in Hz
+	Freqs    []float64
+	Duration time.Duration
+	// Level the level of each sine wave in dBov, e.g. -10
+	Level float64
+}
+
+// Beep a 1 kHz beep of 200ms, e.g. played before recording starts
+var Beep = ToneSegment{Freqs: []float64{1000}, Duration: 200 * time.Millisecond, Level: -10}
+
+func samplesOf(sampleRate int, duration time.Duration) int {
+	return int(int64(sampleRate) * int64(duration) / int64(time.Second))
+}
+
+// amplitudeOf returns the peak amplitude of a sine wave at level dBov.
+func amplitudeOf(level float64) float64 {
+	return math.MaxInt16 * math.Pow(10, level/20) * math.Sqrt2
+}
+
+func putSample(dst []byte, v float64) {
+	s := int16(max(min(math.Round(v), math.MaxInt16), math.MinInt16))
+	dst[0] = byte(s)
+	dst[1] = byte(s >> 8)
+}
+
+// Silence returns 16-bit little-endian PCM of silence.
+func Silence(sampleRate int, duration time.Duration) []byte {
+	return make([]byte, 2*samplesOf(sampleRate, duration))
+}
+
+// Tone returns 16-bit little-endian PCM of the sum of sine waves of the frequencies, each at level dBov.
+func Tone(sampleRate int, duration time.Duration, level float64, freqs ...float64) []byte {
+	samples := Silence(sampleRate, duration)
+	amplitude := amplitudeOf(level)
+	for i := 0; i < len(samples)/2; i++ {
+		t := float64(i) / float64(sampleRate)
+		var v float64
+		for _, f := range freqs {
+			v += amplitude * math.Sin(2*math.Pi*f*t)
+		}
+		putSample(samples[2*i:], v)
+	}
+	return samples
+}
+
+// WhiteNoise returns 16-bit little-endian PCM of uniform white noise with an RMS level of level dBov.
+func WhiteNoise(sampleRate int, duration time.Duration, level float64) []byte {
+	samples := Silence(sampleRate, duration)
+	// the RMS of uniform noise in [-a, a] is a/sqrt(3)
+	amplitude := math.MaxInt16 * math.Pow(10, level/20) * math.Sqrt(3)
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := 0; i < len(samples); i += 2 {
+		putSample(samples[i:], (2*r.Float64()-1)*amplitude)
+	}
+	return samples
+}
+
+// DTMFSegment returns the segment of the tone of a DTMF digit, 0-9, *, # or A-D.
+func DTMFSegment(digit byte, duration time.Duration, level float64) (ToneSegment, error) {
+	if digit >= 'a' && digit <= 'd' {
+		digit -= 'a' - 'A'
+	}
+	for row := range dtmfDigits {
+		for col := range dtmfDigits[row] {
+			if dtmfDigits[row][col] == digit {
+				return ToneSegment{
+					Freqs:    []float64{dtmfRows[row], dtmfColumns[col]},
+					Duration: duration,
+					Level:    level,
+				}, nil
+			}
+		}
+	}
+	return ToneSegment{}, errors.New("invalid dtmf digit")
+}
+
+// DTMFTone returns 16-bit little-endian PCM of the tone of a DTMF digit.
+func DTMFTone(sampleRate int, digit byte, duration time.Duration, level float64) ([]byte, error) {
+	segment, err := DTMFSegment(digit, duration, level)
+	if err != nil {
+		return nil, err
+	}
+	return Tone(sampleRate, segment.Duration, segment.Level, segment.Freqs...), nil
+}
+
+// ToneSequence returns 16-bit little-endian PCM of the segments played one after the other.
+func ToneSequence(sampleRate int, segments ...ToneSegment) []byte {
+	var samples []byte
+	for _, segment := range segments {
+		samples = append(samples, Tone(sampleRate, segment.Duration, segment.Level, segment.Freqs...)...)
+	}
+	return samples
+}
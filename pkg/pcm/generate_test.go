@@ -0,0 +1,72 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// levelOf returns the RMS level of the PCM in dBov.
+func levelOf(samples []byte) float64 {
+	var sum float64
+	for i := 0; i+1 < len(samples); i += 2 {
+		v := float64(int16(binary.LittleEndian.Uint16(samples[i:])))
+		sum += v * v
+	}
+	return 10*math.Log10(sum/float64(len(samples)/2)) - 20*math.Log10(math.MaxInt16)
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		name      string
+		samples   []byte
+		wantLen   int
+		wantLevel float64
+	}{
+		{name: "silence", samples: Silence(8000, 100*time.Millisecond), wantLen: 1600, wantLevel: math.Inf(-1)},
+		{name: "tone", samples: Tone(16000, time.Second, -10, 1000), wantLen: 32000, wantLevel: -10},
+		{name: "dual tone", samples: Tone(8000, time.Second, -10, 697, 1209), wantLen: 16000, wantLevel: -7},
+		{name: "white noise", samples: WhiteNoise(48000, time.Second, -20), wantLen: 96000, wantLevel: -20},
+		{
+			name:      "sequence",
+			samples:   ToneSequence(8000, Beep, ToneSegment{Duration: 200 * time.Millisecond}),
+			wantLen:   6400,
+			wantLevel: -13,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.samples) != tt.wantLen {
+				t.Errorf("len() got = %v, want %v", len(tt.samples), tt.wantLen)
+			}
+			got := levelOf(tt.samples)
+			if math.IsInf(tt.wantLevel, -1) && !math.IsInf(got, -1) || math.Abs(got-tt.wantLevel) > 0.2 {
+				t.Errorf("level got = %.2f dBov, want %.2f dBov", got, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestDTMFTone(t *testing.T) {
+	for _, rate := range []int{8000, 16000} {
+		var samples []byte
+		for _, digit := range []byte("159#*d") {
+			tone, err := DTMFTone(rate, digit, 80*time.Millisecond, -10)
+			if err != nil {
+				t.Errorf("DTMFTone() error = %v", err)
+				return
+			}
+			samples = append(samples, tone...)
+			samples = append(samples, Silence(rate, 80*time.Millisecond)...)
+		}
+		got := NewDTMFDetector(rate).Process(samples)
+		if want := []byte("159#*D"); !reflect.DeepEqual(got, want) {
+			t.Errorf("DTMFTone() at %d Hz detected %q, want %q", rate, got, want)
+		}
+	}
+	if _, err := DTMFTone(8000, 'x', time.Second, -10); err == nil {
+		t.Errorf("DTMFTone() error = nil, want invalid digit")
+	}
+}
@@ -0,0 +1,29 @@
+package pcm
+
+import (
+	"errors"
+)
+
+// LinearToL16 converts 16-bit little-endian PCM to L16 in network byte order, see RFC 3551 section 4.5.11
+func LinearToL16(samples []byte, l16s []byte) error {
+	if len(l16s) < len(samples)&^1 {
+		return errors.New("data size too small")
+	}
+	for i := 0; i+1 < len(samples); i += 2 {
+		l16s[i] = samples[i+1]
+		l16s[i+1] = samples[i]
+	}
+	return nil
+}
+
+// L16ToLinear converts L16 in network byte order to 16-bit little-endian PCM
+func L16ToLinear(l16s []byte, samples []byte) error {
+	if len(samples) < len(l16s)&^1 {
+		return errors.New("data size too small")
+	}
+	for i := 0; i+1 < len(l16s); i += 2 {
+		samples[i] = l16s[i+1]
+		samples[i+1] = l16s[i]
+	}
+	return nil
+}
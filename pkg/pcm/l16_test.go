@@ -0,0 +1,31 @@
+package pcm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestL16(t *testing.T) {
+	samples := []byte{0x00, 0x00, 0xE8, 0x03, 0x18, 0xFC, 0x10}
+	want := []byte{0x00, 0x00, 0x03, 0xE8, 0xFC, 0x18}
+
+	l16s := make([]byte, len(want))
+	if err := LinearToL16(samples, l16s); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(l16s, want) {
+		t.Errorf("LinearToL16() got = % X, want % X", l16s, want)
+	}
+
+	got := make([]byte, len(l16s))
+	if err := L16ToLinear(l16s, got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, samples[:6]) {
+		t.Errorf("L16ToLinear() got = % X, want % X", got, samples[:6])
+	}
+
+	if err := LinearToL16(samples, make([]byte, 4)); err == nil {
+		t.Errorf("LinearToL16() error = %v, wantErr %v", err, true)
+	}
+}
@@ -0,0 +1,130 @@
+package pcm
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	// resampleZeros the number of zero crossings of the sinc on each side of the filter
+	resampleZeros = 16
+	// resampleRolloff the cutoff relative to the lower Nyquist frequency
+	resampleRolloff = 0.92
+	// resampleKaiserBeta the shape of the Kaiser window, about 80 dB stopband attenuation
+	resampleKaiserBeta = 8.0
+)
+
+// SupportedSampleRates the sample rates supported by Resampler
+var SupportedSampleRates = []int{8000, 16000, 22050, 24000, 32000, 48000}
+
+var ErrUnsupportedSampleRate = errors.New("unsupported sample rate")
+
+// Resampler converts the sample rate of 16-bit little-endian PCM with a windowed sinc filter,
+// the state is kept across frames.
+type Resampler struct {
+	// up down the reduced ratio of the output and input rates
+	up, down int
+	half     int
+	// coeffs the filter taps of each phase
+	coeffs [][]float64
+	// buf the input history, index the position of the next output sample in buf
+	buf   []float64
+	index int
+	phase int
+}
+
+func supportedSampleRate(rate int) bool {
+	for _, r := range SupportedSampleRates {
+		if r == rate {
+			return true
+		}
+	}
+	return false
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// besselI0 the modified Bessel function of the first kind of order zero
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 50; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+		if term < sum*1e-12 {
+			break
+		}
+	}
+	return sum
+}
+
+func NewResampler(inRate, outRate int) (*Resampler, error) {
+	if !supportedSampleRate(inRate) || !supportedSampleRate(outRate) {
+		return nil, ErrUnsupportedSampleRate
+	}
+	g := gcd(inRate, outRate)
+	r := &Resampler{up: outRate / g, down: inRate / g}
+
+	// the cutoff in cycles per input sample
+	cutoff := 0.5 * resampleRolloff * math.Min(1, float64(outRate)/float64(inRate))
+	width := resampleZeros / (2 * cutoff)
+	r.half = int(math.Ceil(width))
+	r.coeffs = make([][]float64, r.up)
+	for p := range r.coeffs {
+		frac := float64(p) / float64(r.up)
+		taps := make([]float64, 2*r.half)
+		for k := range taps {
+			x := frac + float64(r.half-1-k)
+			if math.Abs(x) > width {
+				continue
+			}
+			v := 2 * cutoff
+			if x != 0 {
+				v = math.Sin(2*math.Pi*cutoff*x) / (math.Pi * x)
+			}
+			w := x / width
+			taps[k] = v * besselI0(resampleKaiserBeta*math.Sqrt(1-w*w)) / besselI0(resampleKaiserBeta)
+		}
+		r.coeffs[p] = taps
+	}
+
+	// the first output sample is aligned with the first input sample
+	r.buf = make([]float64, r.half-1)
+	r.index = r.half - 1
+	return r, nil
+}
+
+// Resample converts the samples, returns the output available so far.
+// The output lags the input by the length of the filter, an odd trailing byte is ignored.
+func (r *Resampler) Resample(samples []byte) []byte {
+	for i := 0; i+1 < len(samples); i += 2 {
+		r.buf = append(r.buf, float64(int16(samples[i])|int16(samples[i+1])<<8))
+	}
+
+	var out []byte
+	for r.index+r.half < len(r.buf) {
+		window := r.buf[r.index-r.half+1 : r.index+r.half+1]
+		var sum float64
+		for k, c := range r.coeffs[r.phase] {
+			sum += c * window[k]
+		}
+		v := int16(max(min(math.Round(sum), math.MaxInt16), math.MinInt16))
+		out = append(out, byte(v), byte(v>>8))
+
+		r.phase += r.down
+		r.index += r.phase / r.up
+		r.phase %= r.up
+	}
+
+	// drop the history no longer needed
+	if drop := r.index - r.half + 1; drop > 0 {
+		drop = min(drop, len(r.buf))
+		r.buf = append(r.buf[:0], r.buf[drop:]...)
+		r.index -= drop
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// sine returns n samples of 16-bit little-endian PCM of a sine wave of freq Hz at rate.
+func sine(rate, n int, freq, amplitude float64) []byte {
+	samples := make([]byte, 2*n)
+	for i := 0; i < n; i++ {
+		binary.LittleEndian.PutUint16(samples[2*i:], uint16(int16(amplitude*math.Sin(2*math.Pi*freq*float64(i)/float64(rate)))))
+	}
+	return samples
+}
+
+func TestResampler_Resample(t *testing.T) {
+	tests := []struct {
+		inRate, outRate int
+	}{
+		{inRate: 8000, outRate: 16000},
+		{inRate: 16000, outRate: 8000},
+		{inRate: 8000, outRate: 22050},
+		{inRate: 48000, outRate: 8000},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d to %d", tt.inRate, tt.outRate), func(t *testing.T) {
+			r, err := NewResampler(tt.inRate, tt.outRate)
+			if err != nil {
+				t.Errorf("NewResampler() error = %v", err)
+				return
+			}
+			// one second of a 1 kHz tone, frame by frame, the state is kept across frames
+			samples := sine(tt.inRate, tt.inRate, 1000, 10000)
+			frame := 2 * tt.inRate / 50
+			var got []byte
+			for i := 0; i < len(samples); i += frame {
+				got = append(got, r.Resample(samples[i:i+frame])...)
+			}
+			n := len(got) / 2
+			if n < tt.outRate*9/10 || n > tt.outRate {
+				t.Fatalf("Resample() got %d samples, want about %d", n, tt.outRate)
+			}
+
+			// the first output sample is aligned with the first input sample
+			want := sine(tt.outRate, n, 1000, 10000)
+			var signal, noise float64
+			for i := n / 4; i < 3*n/4; i++ {
+				w := float64(int16(binary.LittleEndian.Uint16(want[2*i:])))
+				v := float64(int16(binary.LittleEndian.Uint16(got[2*i:])))
+				signal += w * w
+				noise += (w - v) * (w - v)
+			}
+			if snr := 10 * math.Log10(signal/noise); snr < 60 {
+				t.Errorf("snr got = %.1f dB, want >= 60 dB", snr)
+			}
+		})
+	}
+}
+
+func TestNewResampler(t *testing.T) {
+	for _, rates := range [][2]int{{8000, 11025}, {0, 8000}, {16000, 96000}} {
+		if _, err := NewResampler(rates[0], rates[1]); err != ErrUnsupportedSampleRate {
+			t.Errorf("NewResampler(%d, %d) error = %v, want %v", rates[0], rates[1], err, ErrUnsupportedSampleRate)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package pcm
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// vadFrame the duration of the analysis frame
+	vadFrame = 10 * time.Millisecond
+	// vadInitialNoise the noise floor in dBov before any audio is analysed
+	vadInitialNoise = -60.0
+	// vadMinNoise the lowest noise floor in dBov, digital silence would make any sound speech
+	vadMinNoise = -75.0
+	// vadNoisyZeroCrossing the zero-crossing rate per sample above which a frame is noise-like
+	vadNoisyZeroCrossing = 0.45
+	// vadNoisyMargin the extra energy in dB required for noise-like frames
+	vadNoisyMargin = 6.0
+)
+
+// VADEvent a change of the speech state detected by VAD
+type VADEvent int
+
+const (
+	VADSpeechStart VADEvent = 1 + iota
+	VADSpeechEnd
+)
+
+func (e VADEvent) String() string {
+	switch e {
+	case VADSpeechStart:
+		return "speech-start"
+	case VADSpeechEnd:
+		return "speech-end"
+	default:
+		return "unknown"
+	}
+}
+
+type VADConfig struct {
+	// SampleRate the sample rate of the analysed PCM
+	// Default: 8000
+	SampleRate int
+	// Sensitivity from 0.0 to 1.0, the higher the more likely sound is detected as speech,
+	// as the MRCP Sensitivity-Level header, values out of range are clamped
+	// Default: 0.5 if nil
+	Sensitivity *float64
+	// StartDuration the duration of speech before speech start is detected
+	// Default: 60ms
+	StartDuration time.Duration
+	// Hangover the duration of silence before speech end is detected
+	// Default: 300ms
+	Hangover time.Duration
+}
+
+// VAD detects speech in 16-bit little-endian PCM by the energy and the zero-crossing rate of 10ms frames
+// compared to an adaptive noise floor.
+type VAD struct {
+	frameSize   int
+	sensitivity float64
+	// startFrames hangoverFrames the durations of the config in frames
+	startFrames, hangoverFrames int
+	// noise the noise floor in dBov
+	noise    float64
+	speaking bool
+	// run the number of consecutive frames disagreeing with the speech state
+	run     int
+	pending []byte
+}
+
+func NewVAD(config VADConfig) *VAD {
+	if config.SampleRate <= 0 {
+		config.SampleRate = 8000
+	}
+	sensitivity := 0.5
+	if config.Sensitivity != nil {
+		sensitivity = *config.Sensitivity
+	}
+	if config.StartDuration <= 0 {
+		config.StartDuration = 60 * time.Millisecond
+	}
+	if config.Hangover <= 0 {
+		config.Hangover = 300 * time.Millisecond
+	}
+	v := &VAD{
+		frameSize:      2 * config.SampleRate * int(vadFrame/time.Millisecond) / 1000,
+		startFrames:    max(1, int(config.StartDuration/vadFrame)),
+		hangoverFrames: max(1, int(config.Hangover/vadFrame)),
+		noise:          vadInitialNoise,
+	}
+	v.SetSensitivity(sensitivity)
+	return v
+}
+
+// SetSensitivity sets the sensitivity from 0.0 to 1.0, e.g. from the MRCP Sensitivity-Level header,
+// values out of range are clamped.
+func (v *VAD) SetSensitivity(sensitivity float64) {
+	v.sensitivity = max(0, min(sensitivity, 1))
+}
+
+// Speaking returns whether speech is in progress.
+func (v *VAD) Speaking() bool { return v.speaking }
+
+// Process analyses the samples, returns the speech state changes in order.
+// Samples are buffered until a complete frame is available.
+func (v *VAD) Process(samples []byte) []VADEvent {
+	v.pending = append(v.pending, samples...)
+	var events []VADEvent
+	off := 0
+	for ; off+v.frameSize <= len(v.pending); off += v.frameSize {
+		if event := v.processFrame(v.pending[off : off+v.frameSize]); event != 0 {
+			events = append(events, event)
+		}
+	}
+	v.pending = append(v.pending[:0], v.pending[off:]...)
+	return events
+}
+
+// Reset forgets the speech state, the noise floor is kept.
+func (v *VAD) Reset() {
+	v.speaking = false
+	v.run = 0
+	v.pending = nil
+}
+
+func (v *VAD) processFrame(frame []byte) VADEvent {
+	var sum float64
+	var crossings int
+	var prev int16
+	for i := 0; i+1 < len(frame); i += 2 {
+		s := int16(frame[i]) | int16(frame[i+1])<<8
+		sum += float64(s) * float64(s)
+		if i > 0 && (s >= 0) != (prev >= 0) {
+			crossings++
+		}
+		prev = s
+	}
+	n := len(frame) / 2
+	energy := 10*math.Log10(sum/float64(n)+1) - 20*math.Log10(math.MaxInt16)
+	zcr := float64(crossings) / float64(n)
+
+	// the margin above the noise floor, 20 dB at sensitivity 0 down to 6 dB at sensitivity 1
+	threshold := v.noise + 20 - 14*v.sensitivity
+	if zcr > vadNoisyZeroCrossing {
+		threshold += vadNoisyMargin
+	}
+	speech := energy > threshold
+
+	// follow the noise down quickly and up slowly,
+	// very slowly during speech so that a persistent change of the noise is learned
+	rate := 0.05
+	if speech {
+		rate = 0.0005
+	} else if energy < v.noise {
+		rate = 0.5
+	}
+	v.noise = max(v.noise+(energy-v.noise)*rate, vadMinNoise)
+
+	if speech == v.speaking {
+		v.run = 0
+		return 0
+	}
+	v.run++
+	if !v.speaking && v.run >= v.startFrames {
+		v.speaking, v.run = true, 0
+		return VADSpeechStart
+	}
+	if v.speaking && v.run >= v.hangoverFrames {
+		v.speaking, v.run = false, 0
+		return VADSpeechEnd
+	}
+	return 0
+}
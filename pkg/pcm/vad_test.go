@@ -0,0 +1,105 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// speechSignal returns 8 kHz PCM of noise at -60 dBov with a 300 Hz tone at level dBov in [from, to).
+func speechSignal(duration, from, to time.Duration, level float64) []byte {
+	r := rand.New(rand.NewSource(1))
+	n := int(duration.Seconds() * 8000)
+	start, end := int(from.Seconds()*8000), int(to.Seconds()*8000)
+	noise := math.MaxInt16 * math.Pow(10, -60.0/20) * math.Sqrt(3)
+	tone := math.MaxInt16 * math.Pow(10, level/20) * math.Sqrt2
+	samples := make([]byte, 2*n)
+	for i := 0; i < n; i++ {
+		v := (2*r.Float64() - 1) * noise
+		if i >= start && i < end {
+			v += tone * math.Sin(2*math.Pi*300*float64(i)/8000)
+		}
+		binary.LittleEndian.PutUint16(samples[2*i:], uint16(int16(v)))
+	}
+	return samples
+}
+
+func TestVAD_Process(t *testing.T) {
+	type args struct {
+		sensitivity float64
+		level       float64
+	}
+	tests := []struct {
+		name string
+		args args
+		want []VADEvent
+		// wantAt the time of the events in frames of 10ms
+		wantAt []int
+	}{
+		{
+			name:   "speech",
+			args:   args{sensitivity: 0.5, level: -20},
+			want:   []VADEvent{VADSpeechStart, VADSpeechEnd},
+			wantAt: []int{105, 230},
+		},
+		{
+			name:   "quiet speech, high sensitivity",
+			args:   args{sensitivity: 1, level: -48},
+			want:   []VADEvent{VADSpeechStart, VADSpeechEnd},
+			wantAt: []int{105, 230},
+		},
+		{
+			name: "quiet speech, low sensitivity",
+			args: args{sensitivity: 0.01, level: -48},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewVAD(VADConfig{SampleRate: 8000, Sensitivity: &tt.args.sensitivity})
+			samples := speechSignal(3*time.Second, time.Second, 2*time.Second, tt.args.level)
+			var got []VADEvent
+			var gotAt []int
+			// 20ms packets
+			for i := 0; i < len(samples); i += 320 {
+				for _, event := range v.Process(samples[i : i+320]) {
+					got = append(got, event)
+					gotAt = append(gotAt, i/160)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Process() got = %v, want %v", got, tt.want)
+				return
+			}
+			// within a packet of the expected time
+			for i := range gotAt {
+				if gotAt[i] < tt.wantAt[i]-2 || gotAt[i] > tt.wantAt[i]+2 {
+					t.Errorf("Process() got %v at %d, want at %d", got[i], gotAt[i], tt.wantAt[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNewVAD(t *testing.T) {
+	level := func(l float64) *float64 { return &l }
+	tests := []struct {
+		name        string
+		sensitivity *float64
+		want        float64
+	}{
+		{name: "default", sensitivity: nil, want: 0.5},
+		{name: "lowest", sensitivity: level(0), want: 0},
+		{name: "level", sensitivity: level(0.3), want: 0.3},
+		{name: "clamped", sensitivity: level(2), want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewVAD(VADConfig{Sensitivity: tt.sensitivity}).sensitivity; got != tt.want {
+				t.Errorf("NewVAD() sensitivity got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
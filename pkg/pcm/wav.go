@@ -0,0 +1,315 @@
+package pcm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// WavFormat the audio format code of the fmt chunk
+type WavFormat uint16
+
+const (
+	WavFormatPCM   WavFormat = 1
+	WavFormatALaw  WavFormat = 6
+	WavFormatMuLaw WavFormat = 7
+)
+
+var (
+	ErrInvalidWav           = errors.New("invalid wav file")
+	ErrUnsupportedWavFormat = errors.New("unsupported wav format")
+)
+
+// wavUnknownSize the size of the RIFF and data chunks of a streamed file
+const wavUnknownSize = 0xFFFFFFFF
+
+// wavHeaderSize the size of the RIFF header, fmt chunk, fact chunk and data chunk header written by WavWriter
+const wavHeaderSize = 12 + 8 + 18 + 12 + 8
+
+// WavReader reads the audio of a WAV file as 16-bit little-endian PCM,
+// µ-law and A-law are decoded, multiple channels are mixed down to mono.
+type WavReader struct {
+	r          io.Reader
+	Format     WavFormat
+	Channels   int
+	SampleRate int
+	// remaining the number of bytes left in the data chunk
+	remaining int64
+	// frameSize the size of a sample of all channels in bytes
+	frameSize int
+	buf       []byte
+}
+
+// NewWavReader reads the header of the WAV file, r is positioned at the start of the audio.
+// Chunks other than fmt and data are skipped.
+func NewWavReader(r io.Reader) (*WavReader, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, ErrInvalidWav
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, ErrInvalidWav
+	}
+
+	wr := &WavReader{r: r}
+	var hasFmt bool
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, ErrInvalidWav
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, ErrInvalidWav
+			}
+			fmtChunk := make([]byte, size+size&1)
+			if _, err := io.ReadFull(r, fmtChunk); err != nil {
+				return nil, ErrInvalidWav
+			}
+			if err := wr.parseFmt(fmtChunk); err != nil {
+				return nil, err
+			}
+			hasFmt = true
+		case "data":
+			if !hasFmt {
+				return nil, ErrInvalidWav
+			}
+			wr.remaining = size
+			// the size is unknown if the file was streamed, an empty data chunk has no audio
+			if size == wavUnknownSize {
+				wr.remaining = -1
+			}
+			return wr, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, size+size&1); err != nil {
+				return nil, ErrInvalidWav
+			}
+		}
+	}
+}
+
+func (wr *WavReader) parseFmt(chunk []byte) error {
+	wr.Format = WavFormat(binary.LittleEndian.Uint16(chunk[0:2]))
+	wr.Channels = int(binary.LittleEndian.Uint16(chunk[2:4]))
+	wr.SampleRate = int(binary.LittleEndian.Uint32(chunk[4:8]))
+	bits := binary.LittleEndian.Uint16(chunk[14:16])
+	if wr.Channels == 0 || wr.SampleRate == 0 {
+		return ErrInvalidWav
+	}
+	switch {
+	case wr.Format == WavFormatPCM && bits == 16:
+		wr.frameSize = 2 * wr.Channels
+	case (wr.Format == WavFormatALaw || wr.Format == WavFormatMuLaw) && bits == 8:
+		wr.frameSize = wr.Channels
+	default:
+		return ErrUnsupportedWavFormat
+	}
+	return nil
+}
+
+// Read reads PCM at SampleRate, returns io.EOF at the end of the data chunk.
+func (wr *WavReader) Read(p []byte) (int, error) {
+	frames := len(p) / 2
+	if frames == 0 {
+		return 0, nil
+	}
+	size := frames * wr.frameSize
+	if wr.remaining >= 0 {
+		if wr.remaining < int64(wr.frameSize) {
+			return 0, io.EOF
+		}
+		size = min(size, int(wr.remaining/int64(wr.frameSize))*wr.frameSize)
+	}
+	if cap(wr.buf) < size {
+		wr.buf = make([]byte, size)
+	}
+	buf := wr.buf[:size]
+	n, err := io.ReadAtLeast(wr.r, buf, wr.frameSize)
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = io.EOF
+		}
+		return 0, err
+	}
+	// complete a partial frame returned by the reader
+	if rest := n % wr.frameSize; rest != 0 {
+		if _, err := io.ReadFull(wr.r, buf[n:n+wr.frameSize-rest]); err != nil {
+			n -= rest
+		} else {
+			n += wr.frameSize - rest
+		}
+	}
+	if wr.remaining >= 0 {
+		wr.remaining -= int64(n)
+	}
+
+	frames = n / wr.frameSize
+	for i := 0; i < frames; i++ {
+		var sum int
+		for c := 0; c < wr.Channels; c++ {
+			sum += int(wr.decode(buf[i*wr.frameSize:], c))
+		}
+		v := int16(sum / wr.Channels)
+		p[2*i] = byte(v)
+		p[2*i+1] = byte(v >> 8)
+	}
+	return 2 * frames, nil
+}
+
+// decode returns the sample of the channel c of the frame.
+func (wr *WavReader) decode(frame []byte, c int) int16 {
+	switch wr.Format {
+	case WavFormatMuLaw:
+		return muLawDecompressTable[frame[c]]
+	case WavFormatALaw:
+		return aLawDecompressTable[frame[c]]
+	default:
+		return int16(frame[2*c]) | int16(frame[2*c+1])<<8
+	}
+}
+
+// WavWriter writes mono 16-bit little-endian PCM into a WAV file, encoded in the given format.
+type WavWriter struct {
+	w          io.Writer
+	format     WavFormat
+	sampleRate int
+	// size the number of bytes written to the data chunk
+	size int64
+	// odd the trailing byte of a sample split across writes
+	odd    []byte
+	closed bool
+}
+
+// NewWavWriter writes the header of the WAV file.
+// The sizes in the header are updated by Close if w is an io.WriteSeeker,
+// otherwise they are left unknown as in a streamed file.
+func NewWavWriter(w io.Writer, format WavFormat, sampleRate int) (*WavWriter, error) {
+	if !supportedWavFormat(format) {
+		return nil, ErrUnsupportedWavFormat
+	}
+	if _, err := w.Write(wavHeader(format, sampleRate, wavUnknownSize)); err != nil {
+		return nil, err
+	}
+	return &WavWriter{w: w, format: format, sampleRate: sampleRate}, nil
+}
+
+func supportedWavFormat(format WavFormat) bool {
+	return format == WavFormatPCM || format == WavFormatALaw || format == WavFormatMuLaw
+}
+
+// wavHeader returns the header of a mono WAV file with dataSize bytes of audio.
+func wavHeader(format WavFormat, sampleRate int, dataSize uint32) []byte {
+	bits, blockAlign := uint16(16), uint16(2)
+	if format != WavFormatPCM {
+		bits, blockAlign = 8, 1
+	}
+	h := make([]byte, 0, wavHeaderSize)
+	h = append(h, "RIFF"...)
+	riffSize := uint32(wavUnknownSize)
+	if dataSize <= wavUnknownSize-wavHeaderSize {
+		riffSize = dataSize + wavHeaderSize - 8
+	}
+	h = binary.LittleEndian.AppendUint32(h, riffSize)
+	h = append(h, "WAVE"...)
+	h = append(h, "fmt "...)
+	h = binary.LittleEndian.AppendUint32(h, 18)
+	h = binary.LittleEndian.AppendUint16(h, uint16(format))
+	h = binary.LittleEndian.AppendUint16(h, 1)
+	h = binary.LittleEndian.AppendUint32(h, uint32(sampleRate))
+	h = binary.LittleEndian.AppendUint32(h, uint32(sampleRate)*uint32(blockAlign))
+	h = binary.LittleEndian.AppendUint16(h, blockAlign)
+	h = binary.LittleEndian.AppendUint16(h, bits)
+	// cbSize, no extension
+	h = binary.LittleEndian.AppendUint16(h, 0)
+	// the fact chunk is required for non-PCM formats
+	h = append(h, "fact"...)
+	h = binary.LittleEndian.AppendUint32(h, 4)
+	h = binary.LittleEndian.AppendUint32(h, dataSize/uint32(blockAlign))
+	h = append(h, "data"...)
+	h = binary.LittleEndian.AppendUint32(h, dataSize)
+	return h
+}
+
+// Write encodes and writes PCM.
+func (ww *WavWriter) Write(p []byte) (int, error) {
+	if ww.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n := len(p)
+	if len(ww.odd) > 0 {
+		p = append(ww.odd, p...)
+		ww.odd = nil
+	}
+	if len(p)%2 != 0 {
+		ww.odd = []byte{p[len(p)-1]}
+		p = p[:len(p)-1]
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+
+	data, err := encodeWavData(ww.format, p)
+	if err != nil {
+		return 0, err
+	}
+	written, err := ww.w.Write(data)
+	ww.size += int64(written)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Close updates the sizes in the header if the underlying writer is an io.WriteSeeker,
+// the underlying writer is not closed.
+func (ww *WavWriter) Close() error {
+	if ww.closed {
+		return nil
+	}
+	ww.closed = true
+	ws, ok := ww.w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	if _, err := ws.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := ws.Write(wavHeader(ww.format, ww.sampleRate, uint32(ww.size))); err != nil {
+		return err
+	}
+	_, err := ws.Seek(0, io.SeekEnd)
+	return err
+}
+
+func encodeWavData(format WavFormat, samples []byte) ([]byte, error) {
+	switch format {
+	case WavFormatMuLaw:
+		data := make([]byte, len(samples)/2)
+		return data, LinearToMuLaw(samples[:2*len(data)], data)
+	case WavFormatALaw:
+		data := make([]byte, len(samples)/2)
+		return data, LinearToALaw(samples[:2*len(data)], data)
+	default:
+		return samples[:len(samples)&^1], nil
+	}
+}
+
+// EncodeWav returns a mono WAV file of the 16-bit little-endian PCM, encoded in the given format.
+func EncodeWav(samples []byte, format WavFormat, sampleRate int) ([]byte, error) {
+	if !supportedWavFormat(format) {
+		return nil, ErrUnsupportedWavFormat
+	}
+	data, err := encodeWavData(format, samples)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(wavHeaderSize + len(data))
+	buf.Write(wavHeader(format, sampleRate, uint32(len(data))))
+	buf.Write(data)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,172 @@
+package pcm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWavWriter(t *testing.T) {
+	samples := make([]byte, 2*800)
+	for i := 0; i < len(samples); i += 2 {
+		binary.LittleEndian.PutUint16(samples[i:], uint16(int16((i%160-80)*100)))
+	}
+	type args struct {
+		format WavFormat
+		rate   int
+	}
+	tests := []struct {
+		name string
+		args args
+		// want the samples after the roundtrip through the format
+		want func([]byte) []byte
+	}{
+		{
+			name: "pcm",
+			args: args{format: WavFormatPCM, rate: 16000},
+			want: func(s []byte) []byte { return s },
+		},
+		{
+			name: "mulaw",
+			args: args{format: WavFormatMuLaw, rate: 8000},
+			want: func(s []byte) []byte {
+				payload := make([]byte, len(s)/2)
+				_ = LinearToMuLaw(s, payload)
+				out := make([]byte, len(s))
+				_ = MuLawToLiner(payload, out)
+				return out
+			},
+		},
+		{
+			name: "alaw",
+			args: args{format: WavFormatALaw, rate: 8000},
+			want: func(s []byte) []byte {
+				payload := make([]byte, len(s)/2)
+				_ = LinearToALaw(s, payload)
+				out := make([]byte, len(s))
+				_ = ALawToLiner(payload, out)
+				return out
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want(samples)
+
+			// in memory
+			data, err := EncodeWav(samples, tt.args.format, tt.args.rate)
+			if err != nil {
+				t.Errorf("EncodeWav() error = %v", err)
+				return
+			}
+			checkWav(t, bytes.NewReader(data), tt.args.format, tt.args.rate, want)
+
+			// streamed, the sizes are unknown
+			var buf bytes.Buffer
+			w, err := NewWavWriter(&buf, tt.args.format, tt.args.rate)
+			if err != nil {
+				t.Errorf("NewWavWriter() error = %v", err)
+				return
+			}
+			// an odd write splits a sample
+			_, _ = w.Write(samples[:101])
+			_, _ = w.Write(samples[101:])
+			_ = w.Close()
+			checkWav(t, &buf, tt.args.format, tt.args.rate, want)
+
+			// seekable, the sizes are updated on close
+			f, err := os.Create(filepath.Join(t.TempDir(), "test.wav"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			w, _ = NewWavWriter(f, tt.args.format, tt.args.rate)
+			_, _ = w.Write(samples)
+			_ = w.Close()
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := io.ReadAll(f)
+			if !reflect.DeepEqual(got, data) {
+				t.Errorf("WavWriter.Close() got = %d bytes, want the same file as EncodeWav() %d bytes", len(got), len(data))
+			}
+		})
+	}
+}
+
+func checkWav(t *testing.T, r io.Reader, format WavFormat, rate int, want []byte) {
+	t.Helper()
+	wr, err := NewWavReader(r)
+	if err != nil {
+		t.Errorf("NewWavReader() error = %v", err)
+		return
+	}
+	if wr.Format != format || wr.SampleRate != rate || wr.Channels != 1 {
+		t.Errorf("NewWavReader() got = %v %v %v, want %v %v 1", wr.Format, wr.SampleRate, wr.Channels, format, rate)
+	}
+	got, err := io.ReadAll(wr)
+	if err != nil {
+		t.Errorf("Read() error = %v", err)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Read() got = %d bytes, want %d bytes", len(got), len(want))
+	}
+}
+
+func TestNewWavReader(t *testing.T) {
+	// a stereo file with a LIST chunk before the data
+	var data []byte
+	data = append(data, "RIFF"...)
+	data = binary.LittleEndian.AppendUint32(data, 4+24+8+4+8+8)
+	data = append(data, "WAVE"...)
+	data = append(data, "fmt "...)
+	data = binary.LittleEndian.AppendUint32(data, 16)
+	data = binary.LittleEndian.AppendUint16(data, 1)
+	data = binary.LittleEndian.AppendUint16(data, 2)
+	data = binary.LittleEndian.AppendUint32(data, 8000)
+	data = binary.LittleEndian.AppendUint32(data, 32000)
+	data = binary.LittleEndian.AppendUint16(data, 4)
+	data = binary.LittleEndian.AppendUint16(data, 16)
+	data = append(data, "LIST"...)
+	data = binary.LittleEndian.AppendUint32(data, 3)
+	data = append(data, 'a', 'b', 'c', 0)
+	data = append(data, "data"...)
+	data = binary.LittleEndian.AppendUint32(data, 8)
+	for _, v := range []int16{100, 300, -100, -300} {
+		data = binary.LittleEndian.AppendUint16(data, uint16(v))
+	}
+
+	wr, err := NewWavReader(bytes.NewReader(data))
+	if err != nil {
+		t.Errorf("NewWavReader() error = %v", err)
+		return
+	}
+	got, _ := io.ReadAll(wr)
+	want := binary.LittleEndian.AppendUint16(binary.LittleEndian.AppendUint16(nil, 200), uint16(0xFFFF-199))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Read() got = %v, want %v", got, want)
+	}
+
+	// an empty data chunk followed by another chunk
+	data, _ = EncodeWav(nil, WavFormatPCM, 8000)
+	data = append(data, "LIST"...)
+	data = binary.LittleEndian.AppendUint32(data, 4)
+	data = append(data, 1, 2, 3, 4)
+	wr, err = NewWavReader(bytes.NewReader(data))
+	if err != nil {
+		t.Errorf("NewWavReader() error = %v", err)
+		return
+	}
+	if got, _ := io.ReadAll(wr); len(got) != 0 {
+		t.Errorf("Read() got = %v, want no audio", got)
+	}
+
+	if _, err := NewWavReader(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00WAVX"))); err != ErrInvalidWav {
+		t.Errorf("NewWavReader() error = %v, want %v", err, ErrInvalidWav)
+	}
+}
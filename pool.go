@@ -0,0 +1,327 @@
+package mrcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/emiago/sipgo/sip"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoAvailableServer = errors.New("no available server")
+
+// PoolStrategy how a server is picked among the available servers of the highest priority
+type PoolStrategy int
+
+const (
+	// PoolRoundRobin weighted round-robin
+	PoolRoundRobin PoolStrategy = iota
+	// PoolLeastSessions the server with the fewest dialogs of this client relative to its weight
+	PoolLeastSessions
+)
+
+func (s PoolStrategy) String() string {
+	switch s {
+	case PoolRoundRobin:
+		return "round-robin"
+	case PoolLeastSessions:
+		return "least-sessions"
+	default:
+		return "unknown"
+	}
+}
+
+// PoolServer a server of a ServerPool
+type PoolServer struct {
+	// URI the SIP URI of the server, e.g. sip:speechrecog@127.0.0.1:5060, or its address,
+	// the user is the user of the request-URI unless set by WithRequestUser
+	URI string
+	// Weight the share of the dialogs among the servers of the same priority
+	// Default: 1
+	Weight int
+	// Priority the servers of the lowest value are used, the others only when they are unavailable
+	// Default: 0
+	Priority int
+}
+
+// ServerPool the servers of Client.DialPool.
+// A server failing with 503, a timeout or an MRCP connect failure is ejected for EjectBackoff,
+// doubled on each consecutive failure up to MaxEjectBackoff.
+type ServerPool struct {
+	// Servers servers
+	Servers []PoolServer
+	// Strategy how a server is picked
+	// Default: PoolRoundRobin
+	Strategy PoolStrategy
+	// EjectBackoff the duration a failing server is ejected
+	// Default: 5s
+	EjectBackoff time.Duration
+	// MaxEjectBackoff the maximum duration a failing server is ejected
+	// Default: 5m
+	MaxEjectBackoff time.Duration
+	// MaxAttempts the maximum number of servers tried by a dial, 0 tries every server once
+	// Default: 0
+	MaxAttempts int
+	// Prober optional, the servers it probes down are not used
+	Prober *Prober
+
+	// internal
+	mu     sync.Mutex
+	states map[string]*poolState
+}
+
+type poolState struct {
+	server PoolServer
+	raddr  string
+	user   string
+	// sessions the dialogs in progress or established
+	sessions int
+	// current the current weight of the smooth weighted round-robin
+	current int
+	// failures the consecutive failures
+	failures     int
+	ejectedUntil time.Time
+}
+
+// poolAddr returns the address and the user of a SIP URI.
+func poolAddr(uri string) (raddr, user string, err error) {
+	if !strings.HasPrefix(uri, "sip:") && !strings.HasPrefix(uri, "sips:") {
+		if _, _, err := net.SplitHostPort(uri); err != nil {
+			return net.JoinHostPort(uri, "5060"), "", nil
+		}
+		return uri, "", nil
+	}
+	var u sip.Uri
+	if err := sip.ParseUri(uri, &u); err != nil {
+		return "", "", err
+	}
+	if u.Port == 0 {
+		u.Port = 5060
+	}
+	return net.JoinHostPort(u.Host, strconv.Itoa(u.Port)), u.User, nil
+}
+
+func (p *ServerPool) init() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.states != nil {
+		return nil
+	}
+	if len(p.Servers) == 0 {
+		return errors.New("empty server pool")
+	}
+	if p.EjectBackoff == 0 {
+		p.EjectBackoff = 5 * time.Second
+	}
+	if p.MaxEjectBackoff == 0 {
+		p.MaxEjectBackoff = 5 * time.Minute
+	}
+
+	states := make(map[string]*poolState, len(p.Servers))
+	for _, server := range p.Servers {
+		raddr, user, err := poolAddr(server.URI)
+		if err != nil {
+			return fmt.Errorf("invalid server uri %s: %v", server.URI, err)
+		}
+		if server.Weight <= 0 {
+			server.Weight = 1
+		}
+		states[server.URI] = &poolState{server: server, raddr: raddr, user: user}
+	}
+	p.states = states
+	return nil
+}
+
+// available reports whether a server is neither ejected nor probed down.
+func (p *ServerPool) available(s *poolState, now time.Time) bool {
+	if now.Before(s.ejectedUntil) {
+		return false
+	}
+	if p.Prober != nil {
+		if status, ok := p.Prober.Status(s.raddr); ok && !status.Up {
+			return false
+		}
+	}
+	return true
+}
+
+// acquire picks a server not tried yet and counts a session on it.
+// Ejected servers are only picked when no other server is available.
+func (p *ServerPool) acquire(tried map[string]bool) (*poolState, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var candidates, ejected []*poolState
+	for _, server := range p.Servers {
+		s := p.states[server.URI]
+		if tried[server.URI] {
+			continue
+		}
+		if p.available(s, now) {
+			candidates = append(candidates, s)
+		} else {
+			ejected = append(ejected, s)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = ejected
+	}
+	if len(candidates) == 0 {
+		return nil, false
+	}
+
+	// the highest priority
+	priority := candidates[0].server.Priority
+	for _, s := range candidates {
+		priority = min(priority, s.server.Priority)
+	}
+	var group []*poolState
+	for _, s := range candidates {
+		if s.server.Priority == priority {
+			group = append(group, s)
+		}
+	}
+
+	var picked *poolState
+	switch p.Strategy {
+	case PoolLeastSessions:
+		for _, s := range group {
+			// sessions/weight < picked.sessions/picked.weight
+			if picked == nil || s.sessions*picked.server.Weight < picked.sessions*s.server.Weight {
+				picked = s
+			}
+		}
+	default:
+		total := 0
+		for _, s := range group {
+			s.current += s.server.Weight
+			total += s.server.Weight
+			if picked == nil || s.current > picked.current {
+				picked = s
+			}
+		}
+		picked.current -= total
+	}
+	picked.sessions++
+	return picked, true
+}
+
+// release uncounts a session of a server.
+func (p *ServerPool) release(uri string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.states[uri]; ok && s.sessions > 0 {
+		s.sessions--
+	}
+}
+
+// succeed resets the failures of a server.
+func (p *ServerPool) succeed(uri string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.states[uri]; ok {
+		s.failures = 0
+		s.ejectedUntil = time.Time{}
+	}
+}
+
+// eject ejects a failing server, returns the backoff.
+func (p *ServerPool) eject(uri string) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.states[uri]
+	if !ok {
+		return 0
+	}
+	backoff := p.EjectBackoff
+	for i := 0; i < s.failures && backoff < p.MaxEjectBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, p.MaxEjectBackoff)
+	s.failures++
+	s.ejectedUntil = time.Now().Add(backoff)
+	return backoff
+}
+
+// Sessions returns the dialogs of this client in progress or established on a server.
+func (p *ServerPool) Sessions(uri string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.states[uri]; ok {
+		return s.sessions
+	}
+	return 0
+}
+
+// Ejected reports whether a server is ejected after failures.
+func (p *ServerPool) Ejected(uri string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.states[uri]
+	return ok && time.Now().Before(s.ejectedUntil)
+}
+
+// DialPool dials a server of the pool, the next server is tried if it answers 503,
+// times out or the MRCP channel fails to connect. The dial is cancelled when ctx is done,
+// ctx.Err() is returned then and the server is not ejected.
+func (c *Client) DialPool(
+	ctx context.Context,
+	pool *ServerPool,
+	resource Resource,
+	handler DialogHandler,
+	opts ...DialogClientOptionFunc,
+) (*DialogClient, error) {
+	if err := pool.init(); err != nil {
+		return nil, err
+	}
+
+	tried := make(map[string]bool, len(pool.Servers))
+	var lastErr error
+	for attempt := 0; pool.MaxAttempts <= 0 || attempt < pool.MaxAttempts; attempt++ {
+		// the caller gave up, it is not a failure of the servers
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		s, ok := pool.acquire(tried)
+		if !ok {
+			break
+		}
+		uri := s.server.URI
+		tried[uri] = true
+
+		// the session is released on close once the dialog is created, here otherwise
+		created := false
+		dc, retry, err := c.dial(ctx, s.raddr, resource, handler, func(d *DialogClient) {
+			if d.requestUser == "" {
+				d.requestUser = s.user
+			}
+			d.onClose = func() { pool.release(uri) }
+			created = true
+		}, opts...)
+		if !created {
+			pool.release(uri)
+		}
+		if err == nil {
+			pool.succeed(uri)
+			return dc, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if !retry {
+			return nil, err
+		}
+		backoff := pool.eject(uri)
+		c.Logger.Warn("failed to dial server of pool", "uri", uri, "backoff", backoff, "error", err)
+		lastErr = err
+	}
+	if lastErr == nil {
+		return nil, ErrNoAvailableServer
+	}
+	return nil, fmt.Errorf("%w: %v", ErrNoAvailableServer, lastErr)
+}
@@ -0,0 +1,226 @@
+package mrcp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_poolAddr(t *testing.T) {
+	tests := []struct {
+		uri      string
+		want     string
+		wantUser string
+		wantErr  bool
+	}{
+		{uri: "sip:127.0.0.1:5070", want: "127.0.0.1:5070"},
+		{uri: "sip:speechrecog@127.0.0.1", want: "127.0.0.1:5060", wantUser: "speechrecog"},
+		{uri: "127.0.0.1:5070", want: "127.0.0.1:5070"},
+		{uri: "127.0.0.1", want: "127.0.0.1:5060"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			got, user, err := poolAddr(tt.uri)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("poolAddr() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want || user != tt.wantUser {
+				t.Errorf("poolAddr() got = %v %v, want %v %v", got, user, tt.want, tt.wantUser)
+			}
+		})
+	}
+}
+
+func pick(t *testing.T, p *ServerPool, n int, release bool) []string {
+	var got []string
+	for i := 0; i < n; i++ {
+		s, ok := p.acquire(nil)
+		if !ok {
+			t.Fatal("acquire() got no server")
+		}
+		got = append(got, s.server.URI)
+		if release {
+			p.release(s.server.URI)
+		}
+	}
+	return got
+}
+
+func TestServerPool_acquire(t *testing.T) {
+	tests := []struct {
+		name    string
+		pool    *ServerPool
+		ejected []string
+		release bool
+		want    []string
+	}{
+		{
+			name: "weighted round-robin",
+			pool: &ServerPool{Servers: []PoolServer{
+				{URI: "sip:a", Weight: 2},
+				{URI: "sip:b"},
+			}},
+			release: true,
+			want:    []string{"sip:a", "sip:b", "sip:a", "sip:a", "sip:b", "sip:a"},
+		},
+		{
+			name: "least sessions",
+			pool: &ServerPool{Strategy: PoolLeastSessions, Servers: []PoolServer{
+				{URI: "sip:a", Weight: 2},
+				{URI: "sip:b"},
+			}},
+			want: []string{"sip:a", "sip:b", "sip:a", "sip:a", "sip:b", "sip:a"},
+		},
+		{
+			name: "priority",
+			pool: &ServerPool{Servers: []PoolServer{
+				{URI: "sip:backup", Priority: 1},
+				{URI: "sip:a"},
+				{URI: "sip:b"},
+			}},
+			release: true,
+			want:    []string{"sip:a", "sip:b", "sip:a"},
+		},
+		{
+			name: "ejected",
+			pool: &ServerPool{Servers: []PoolServer{
+				{URI: "sip:a"},
+				{URI: "sip:b"},
+				{URI: "sip:backup", Priority: 1},
+			}},
+			ejected: []string{"sip:a", "sip:b"},
+			release: true,
+			want:    []string{"sip:backup", "sip:backup"},
+		},
+		{
+			name: "all ejected",
+			pool: &ServerPool{Servers: []PoolServer{
+				{URI: "sip:a"},
+				{URI: "sip:b"},
+			}},
+			ejected: []string{"sip:a", "sip:b"},
+			release: true,
+			want:    []string{"sip:a", "sip:b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.pool.init(); err != nil {
+				t.Fatal(err)
+			}
+			for _, uri := range tt.ejected {
+				tt.pool.eject(uri)
+			}
+			if got := pick(t, tt.pool, len(tt.want), tt.release); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("acquire() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerPool_eject(t *testing.T) {
+	p := &ServerPool{
+		Servers:         []PoolServer{{URI: "sip:a"}, {URI: "sip:b"}},
+		EjectBackoff:    time.Second,
+		MaxEjectBackoff: 3 * time.Second,
+	}
+	if err := p.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []time.Duration
+	for i := 0; i < 4; i++ {
+		got = append(got, p.eject("sip:a"))
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("eject() got = %v, want %v", got, want)
+	}
+	if !p.Ejected("sip:a") {
+		t.Errorf("Ejected() got = %v, want %v", false, true)
+	}
+
+	// a tried server is not picked again
+	s, ok := p.acquire(map[string]bool{"sip:b": true})
+	if !ok || s.server.URI != "sip:a" {
+		t.Errorf("acquire() got = %v, want %v", s, "sip:a")
+	}
+	if _, ok := p.acquire(map[string]bool{"sip:a": true, "sip:b": true}); ok {
+		t.Errorf("acquire() got = %v, want %v", ok, false)
+	}
+
+	p.succeed("sip:a")
+	if p.Ejected("sip:a") {
+		t.Errorf("Ejected() got = %v, want %v", true, false)
+	}
+	if got := p.eject("sip:a"); got != time.Second {
+		t.Errorf("eject() got = %v, want %v", got, time.Second)
+	}
+}
+
+func TestServerPool_Prober(t *testing.T) {
+	prober := &Prober{statuses: map[string]*ServerStatus{
+		"127.0.0.1:5060": {Up: false},
+		"127.0.0.2:5060": {Up: true},
+	}}
+	p := &ServerPool{
+		Servers: []PoolServer{{URI: "sip:127.0.0.1"}, {URI: "sip:127.0.0.2"}},
+		Prober:  prober,
+	}
+	if err := p.init(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"sip:127.0.0.2", "sip:127.0.0.2"}
+	if got := pick(t, p, 2, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("acquire() got = %v, want %v", got, want)
+	}
+}
+
+func TestClient_DialPool_release(t *testing.T) {
+	porter, err := newPorter(20000, 20002)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := porter.get(); err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{porter: porter, Logger: slog.Default()}
+	p := &ServerPool{Servers: []PoolServer{{URI: "sip:127.0.0.1"}}, Strategy: PoolLeastSessions}
+
+	// the dialog is not created without free ports
+	if _, err := c.DialPool(context.Background(), p, ResourceSpeechsynth, nil); !errors.Is(err, ErrNoFreePorts) {
+		t.Errorf("DialPool() error = %v, want %v", err, ErrNoFreePorts)
+	}
+	if got := p.Sessions("sip:127.0.0.1"); got != 0 {
+		t.Errorf("Sessions() got = %v, want %v", got, 0)
+	}
+}
+
+func TestClient_DialPool_context(t *testing.T) {
+	c := &Client{SIPPort: freePort(t), RtpPortMin: 30200, RtpPortMax: 30300, Logger: slog.Default()}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// the server does not answer, the caller gives up
+	raddr := fakeSIPServer(t, 0, nil)
+	p := &ServerPool{Servers: []PoolServer{{URI: "sip:" + raddr}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := c.DialPool(ctx, p, ResourceSpeechsynth, nil); err != context.DeadlineExceeded {
+		t.Errorf("DialPool() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if p.Ejected("sip:" + raddr) {
+		t.Errorf("Ejected() got = %v, want %v", true, false)
+	}
+
+	// the server is not dialed once the caller gave up
+	if _, err := c.DialPool(ctx, p, ResourceSpeechsynth, nil); err != context.DeadlineExceeded {
+		t.Errorf("DialPool() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,181 @@
+package mrcp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type ProbeHandler interface {
+	// OnStatusChange is called when a server is marked up or down
+	OnStatusChange(raddr string, status ServerStatus)
+}
+
+type ProbeHandlerFunc struct {
+	OnStatusChangeFunc func(raddr string, status ServerStatus)
+}
+
+func (h ProbeHandlerFunc) OnStatusChange(raddr string, status ServerStatus) {
+	if h.OnStatusChangeFunc != nil {
+		h.OnStatusChangeFunc(raddr, status)
+	}
+}
+
+// ServerStatus the health of a server probed with OPTIONS
+type ServerStatus struct {
+	// Up whether the server is available, servers are up until probed down
+	Up bool
+	// Capacity the capacity advertised in the last answer
+	Capacity Capacity
+	// Failures the number of consecutive failed probes
+	Failures int
+	// Err the error of the last failed probe
+	Err error
+	// Probed the time of the last probe
+	Probed time.Time
+}
+
+// Prober probes SIP servers with OPTIONS in the background and marks them up or down.
+type Prober struct {
+	// Client sends the OPTIONS requests, it must be running
+	Client *Client
+	// Addrs the addresses of the SIP servers, e.g. 127.0.0.1:5060
+	Addrs []string
+	// Interval the interval between the probes of a server
+	// Default: 10s
+	Interval time.Duration
+	// Timeout the timeout of a probe
+	// Default: 2s
+	Timeout time.Duration
+	// FailureThreshold the number of consecutive failed probes before a server is marked down,
+	// a single successful probe marks it up again
+	// Default: 2
+	FailureThreshold int
+	// Handler handler
+	Handler ProbeHandler
+
+	// internal
+	ping     func(ctx context.Context, raddr string) (Capacity, error)
+	mu       sync.Mutex
+	statuses map[string]*ServerStatus
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// Run starts probing the servers, it returns immediately.
+func (p *Prober) Run() error {
+	if p.Client == nil {
+		return errors.New("prober client is nil")
+	}
+	if p.Interval == 0 {
+		p.Interval = 10 * time.Second
+	}
+	if p.Timeout == 0 {
+		p.Timeout = 2 * time.Second
+	}
+	if p.FailureThreshold == 0 {
+		p.FailureThreshold = 2
+	}
+	if p.ping == nil {
+		p.ping = p.Client.Ping
+	}
+
+	p.mu.Lock()
+	p.statuses = make(map[string]*ServerStatus, len(p.Addrs))
+	for _, raddr := range p.Addrs {
+		p.statuses[raddr] = &ServerStatus{Up: true}
+	}
+	p.mu.Unlock()
+
+	var ctx context.Context
+	ctx, p.cancel = context.WithCancel(context.Background())
+	for _, raddr := range p.Addrs {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.run(ctx, raddr)
+		}()
+	}
+	return nil
+}
+
+func (p *Prober) run(ctx context.Context, raddr string) {
+	t := time.NewTicker(p.Interval)
+	defer t.Stop()
+	for {
+		p.probe(ctx, raddr)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// probe sends OPTIONS to the server and updates its status.
+func (p *Prober) probe(ctx context.Context, raddr string) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+	capacity, err := p.ping(ctx, raddr)
+	if errors.Is(err, context.Canceled) {
+		// closed
+		return
+	}
+
+	p.mu.Lock()
+	status := p.statuses[raddr]
+	up := status.Up
+	status.Probed = time.Now()
+	status.Capacity = capacity
+	status.Err = err
+	if err != nil {
+		status.Failures++
+		if status.Failures >= p.FailureThreshold {
+			status.Up = false
+		}
+	} else {
+		status.Failures = 0
+		status.Up = true
+	}
+	changed, current := up != status.Up, *status
+	p.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if current.Up {
+		p.Client.Logger.Info("sip server up", "raddr", raddr)
+	} else {
+		p.Client.Logger.Warn("sip server down", "raddr", raddr, "error", err)
+	}
+	if p.Handler != nil {
+		p.Handler.OnStatusChange(raddr, current)
+	}
+}
+
+// Status returns the status of a probed server, false if the server is not probed.
+func (p *Prober) Status(raddr string) (ServerStatus, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status, ok := p.statuses[raddr]
+	if !ok {
+		return ServerStatus{}, false
+	}
+	return *status, true
+}
+
+// Up reports whether a probed server is up.
+func (p *Prober) Up(raddr string) bool {
+	status, ok := p.Status(raddr)
+	return ok && status.Up
+}
+
+// Close stops probing.
+func (p *Prober) Close() error {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.wg.Wait()
+	return nil
+}
@@ -0,0 +1,112 @@
+package mrcp
+
+import (
+	"context"
+	"errors"
+	"github.com/emiago/sipgo/sip"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProber_probe(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		down    = map[string]bool{"b:5060": true}
+		changes []string
+	)
+	p := &Prober{
+		Client:           &Client{Logger: slog.Default()},
+		Addrs:            []string{"a:5060", "b:5060"},
+		Interval:         time.Hour,
+		FailureThreshold: 2,
+		ping: func(ctx context.Context, raddr string) (Capacity, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if down[raddr] {
+				return Capacity{ActiveDialogs: -1, AvailableDialogs: -1}, errors.New("timeout")
+			}
+			return Capacity{ActiveDialogs: 1, AvailableDialogs: 9}, nil
+		},
+		Handler: ProbeHandlerFunc{OnStatusChangeFunc: func(raddr string, status ServerStatus) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, raddr+":"+map[bool]string{true: "up", false: "down"}[status.Up])
+		}},
+	}
+	if err := p.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// the first probes run immediately, a single failure keeps the server up
+	time.Sleep(50 * time.Millisecond)
+	if !p.Up("a:5060") || !p.Up("b:5060") {
+		t.Errorf("Up() got = %v/%v, want %v/%v", p.Up("a:5060"), p.Up("b:5060"), true, true)
+	}
+	if status, _ := p.Status("a:5060"); status.Capacity.AvailableDialogs != 9 {
+		t.Errorf("Capacity got = %v, want %v", status.Capacity.AvailableDialogs, 9)
+	}
+
+	ctx := context.Background()
+	p.probe(ctx, "b:5060")
+	if p.Up("b:5060") {
+		t.Errorf("Up() got = %v, want %v", true, false)
+	}
+	if status, _ := p.Status("b:5060"); status.Failures != 2 || status.Err == nil {
+		t.Errorf("Status() got = %v, want %v failures", status, 2)
+	}
+
+	mu.Lock()
+	down["b:5060"] = false
+	mu.Unlock()
+	p.probe(ctx, "b:5060")
+	if !p.Up("b:5060") {
+		t.Errorf("Up() got = %v, want %v", false, true)
+	}
+	if p.Up("c:5060") {
+		t.Errorf("Up() of unknown server got = %v, want %v", true, false)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"b:5060:down", "b:5060:up"}
+	if len(changes) != len(want) || changes[0] != want[0] || changes[1] != want[1] {
+		t.Errorf("OnStatusChange got = %v, want %v", changes, want)
+	}
+}
+
+func TestProber_Close(t *testing.T) {
+	c := &Client{SIPPort: freePort(t), RtpPortMin: 30000, RtpPortMax: 30100, Logger: slog.Default()}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// a server which never answers
+	raddr := fakeSIPServer(t, 0, make(chan sip.RequestMethod, 10))
+
+	var changes atomic.Int32
+	p := &Prober{
+		Client:           c,
+		Addrs:            []string{raddr},
+		Interval:         time.Hour,
+		Timeout:          5 * time.Second,
+		FailureThreshold: 1,
+		Handler: ProbeHandlerFunc{OnStatusChangeFunc: func(raddr string, status ServerStatus) {
+			changes.Add(1)
+		}},
+	}
+	if err := p.Run(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	// the ping in progress is cancelled, not recorded as a failure
+	_ = p.Close()
+
+	status, _ := p.Status(raddr)
+	if !status.Up || status.Failures != 0 || changes.Load() != 0 {
+		t.Errorf("Status() got = %+v with %d changes, want up", status, changes.Load())
+	}
+}
@@ -0,0 +1,65 @@
+package mrcp
+
+import (
+	"errors"
+	"fmt"
+	"github.com/emiago/sipgo/sip"
+)
+
+var errUnsupportedResource = errors.New("unsupported resource type")
+
+// RejectError rejects the INVITE of a dialog with a final SIP response,
+// ServerHandler.OnDialogCreate may return it, e.g. 486 Busy Here or 403 Forbidden.
+type RejectError struct {
+	// StatusCode the status code of the response, 4xx, 5xx or 6xx
+	StatusCode int
+	// Reason the reason phrase of the response
+	Reason string
+	// Headers optional, the headers of the response, e.g. Retry-After or Warning
+	Headers []sip.Header
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("rejected: %d %s", e.StatusCode, e.Reason)
+}
+
+// rejectError returns the response rejecting the INVITE of a dialog which failed with err:
+// 488 if the offer is not acceptable, 503 with Retry-After if the server is out of capacity,
+// 500 otherwise.
+func (s *Server) rejectError(err error) *RejectError {
+	var re *RejectError
+	switch {
+	case errors.As(err, &re) && re.StatusCode >= 300 && re.StatusCode < 700:
+		return re
+	case errors.Is(err, errUnsupportedResource), errors.Is(err, errNoAudioCodec), errors.Is(err, errNoSRTPCrypto):
+		return &RejectError{StatusCode: int(sip.StatusNotAcceptableHere), Reason: "Not Acceptable Here"}
+	case errors.Is(err, ErrNoFreePorts), errors.Is(err, errOverCapacity):
+		return &RejectError{
+			StatusCode: int(sip.StatusServiceUnavailable),
+			Reason:     "Service Unavailable",
+			Headers:    []sip.Header{sip.NewHeader("Retry-After", formatSeconds(s.RetryAfter))},
+		}
+	default:
+		return &RejectError{StatusCode: int(sip.StatusInternalServerError), Reason: "Internal Server Error"}
+	}
+}
+
+// reject responds the INVITE with the final response of the error.
+func (d *DialogServer) reject(err error) {
+	re := d.ss.rejectError(err)
+	if err := d.session.Respond(sip.StatusCode(re.StatusCode), re.Reason, nil, re.Headers...); err != nil {
+		d.logger.Error("failed to reject INVITE request", "statusCode", re.StatusCode, "error", err)
+	}
+}
+
+// respondReject responds a request outside of a dialog with the final response of the error.
+func (s *Server) respondReject(req *sip.Request, tx sip.ServerTransaction, err error) {
+	re := s.rejectError(err)
+	res := sip.NewResponseFromRequest(req, sip.StatusCode(re.StatusCode), re.Reason, nil)
+	for _, h := range re.Headers {
+		res.AppendHeader(h)
+	}
+	if err := tx.Respond(res); err != nil {
+		s.Logger.Error("failed to reject request", "callId", req.CallID().Value(), "statusCode", re.StatusCode, "error", err)
+	}
+}
@@ -0,0 +1,536 @@
+package mrcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	rtcpTypeSR   = 200
+	rtcpTypeRR   = 201
+	rtcpTypeSDES = 202
+	rtcpTypeBYE  = 203
+
+	rtcpHeaderSize      = 4
+	rtcpReportBlockSize = 24
+	rtcpSDESCNAME       = 1
+
+	// rtcpInterval the average interval between RTCP reports
+	rtcpInterval = 5 * time.Second
+	// ntpEpochOffset seconds between 1900-01-01 and 1970-01-01
+	ntpEpochOffset = 2208988800
+)
+
+var errInvalidRTCPPacket = errors.New("invalid rtcp packet")
+
+// isRTCP reports whether a packet received on a multiplexed port is RTCP, see RFC 5761.
+func isRTCP(buf []byte) bool {
+	return len(buf) >= rtcpHeaderSize && buf[1] >= 192 && buf[1] <= 223
+}
+
+// ntpTime converts t to the 64-bit NTP timestamp format.
+func ntpTime(t time.Time) uint64 {
+	sec := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return sec<<32 | frac
+}
+
+// ntpShort converts t to the middle 32 bits of the NTP timestamp format.
+func ntpShort(t time.Time) uint32 {
+	return uint32(ntpTime(t) >> 16)
+}
+
+// ntpShortDuration converts a duration expressed in units of 1/65536 seconds.
+func ntpShortDuration(d uint32) time.Duration {
+	return time.Duration(uint64(d) * uint64(time.Second) >> 16)
+}
+
+// rtcpReportBlock reception report block of SR and RR
+type rtcpReportBlock struct {
+	ssrc            uint32
+	fractionLost    uint8
+	packetsLost     int32
+	highestSequence uint32
+	jitter          uint32
+	lastSR          uint32
+	delaySinceLSR   uint32
+}
+
+func (b *rtcpReportBlock) marshalTo(buf []byte) {
+	binary.BigEndian.PutUint32(buf, b.ssrc)
+	binary.BigEndian.PutUint32(buf[4:], uint32(b.packetsLost)&0xFFFFFF)
+	buf[4] = b.fractionLost
+	binary.BigEndian.PutUint32(buf[8:], b.highestSequence)
+	binary.BigEndian.PutUint32(buf[12:], b.jitter)
+	binary.BigEndian.PutUint32(buf[16:], b.lastSR)
+	binary.BigEndian.PutUint32(buf[20:], b.delaySinceLSR)
+}
+
+func (b *rtcpReportBlock) unmarshal(buf []byte) {
+	b.ssrc = binary.BigEndian.Uint32(buf)
+	b.fractionLost = buf[4]
+	// sign extend the 24-bit cumulative number of packets lost
+	b.packetsLost = int32(binary.BigEndian.Uint32(buf[4:])<<8) >> 8
+	b.highestSequence = binary.BigEndian.Uint32(buf[8:])
+	b.jitter = binary.BigEndian.Uint32(buf[12:])
+	b.lastSR = binary.BigEndian.Uint32(buf[16:])
+	b.delaySinceLSR = binary.BigEndian.Uint32(buf[20:])
+}
+
+// rtcpSenderInfo the sender information of SR
+type rtcpSenderInfo struct {
+	ntpTime      uint64
+	rtpTime      uint32
+	packetCount  uint32
+	octetCount   uint32
+	reportBlocks []rtcpReportBlock
+}
+
+// appendRTCPHeader appends a RTCP header of a packet with length bytes following the header.
+func appendRTCPHeader(buf []byte, count int, packetType byte, length int) []byte {
+	return append(buf, rtpVersion<<6|byte(count), packetType, byte((length/4)>>8), byte(length/4))
+}
+
+func appendRTCPSenderReport(buf []byte, ssrc uint32, info *rtcpSenderInfo) []byte {
+	buf = appendRTCPHeader(buf, len(info.reportBlocks), rtcpTypeSR, 24+rtcpReportBlockSize*len(info.reportBlocks))
+	buf = binary.BigEndian.AppendUint32(buf, ssrc)
+	buf = binary.BigEndian.AppendUint64(buf, info.ntpTime)
+	buf = binary.BigEndian.AppendUint32(buf, info.rtpTime)
+	buf = binary.BigEndian.AppendUint32(buf, info.packetCount)
+	buf = binary.BigEndian.AppendUint32(buf, info.octetCount)
+	return appendRTCPReportBlocks(buf, info.reportBlocks)
+}
+
+func appendRTCPReceiverReport(buf []byte, ssrc uint32, blocks []rtcpReportBlock) []byte {
+	buf = appendRTCPHeader(buf, len(blocks), rtcpTypeRR, 4+rtcpReportBlockSize*len(blocks))
+	buf = binary.BigEndian.AppendUint32(buf, ssrc)
+	return appendRTCPReportBlocks(buf, blocks)
+}
+
+func appendRTCPReportBlocks(buf []byte, blocks []rtcpReportBlock) []byte {
+	for _, b := range blocks {
+		buf = append(buf, make([]byte, rtcpReportBlockSize)...)
+		b.marshalTo(buf[len(buf)-rtcpReportBlockSize:])
+	}
+	return buf
+}
+
+func appendRTCPSourceDescription(buf []byte, ssrc uint32, cname string) []byte {
+	if len(cname) > 255 {
+		cname = cname[:255]
+	}
+	// ssrc, CNAME item and at least one null octet terminating the item list
+	length := (4 + 2 + len(cname) + 4) &^ 3
+	buf = appendRTCPHeader(buf, 1, rtcpTypeSDES, length)
+	buf = binary.BigEndian.AppendUint32(buf, ssrc)
+	buf = append(buf, rtcpSDESCNAME, byte(len(cname)))
+	buf = append(buf, cname...)
+	return append(buf, make([]byte, length-4-2-len(cname))...)
+}
+
+func appendRTCPGoodbye(buf []byte, ssrc uint32) []byte {
+	buf = appendRTCPHeader(buf, 1, rtcpTypeBYE, 4)
+	return binary.BigEndian.AppendUint32(buf, ssrc)
+}
+
+// rtcpHandler receives the packets of a compound RTCP packet.
+type rtcpHandler interface {
+	onSenderReport(ssrc uint32, info *rtcpSenderInfo, arrival time.Time)
+	onReceiverReport(ssrc uint32, blocks []rtcpReportBlock, arrival time.Time)
+	onGoodbye(ssrcs []uint32)
+}
+
+// parseRTCP parses a compound RTCP packet.
+func parseRTCP(buf []byte, handler rtcpHandler, arrival time.Time) error {
+	for len(buf) > 0 {
+		if len(buf) < rtcpHeaderSize || buf[0]>>6 != rtpVersion {
+			return errInvalidRTCPPacket
+		}
+		count := int(buf[0] & 0x1F)
+		packetType := buf[1]
+		length := 4 * (int(binary.BigEndian.Uint16(buf[2:])) + 1)
+		if len(buf) < length {
+			return errInvalidRTCPPacket
+		}
+		body := buf[rtcpHeaderSize:length]
+		if buf[0]&0x20 != 0 {
+			padding := int(buf[length-1])
+			if padding == 0 || padding > len(body) {
+				return errInvalidRTCPPacket
+			}
+			body = body[:len(body)-padding]
+		}
+		buf = buf[length:]
+
+		switch packetType {
+		case rtcpTypeSR:
+			if len(body) < 24+rtcpReportBlockSize*count {
+				return errInvalidRTCPPacket
+			}
+			info := rtcpSenderInfo{
+				ntpTime:     binary.BigEndian.Uint64(body[4:]),
+				rtpTime:     binary.BigEndian.Uint32(body[12:]),
+				packetCount: binary.BigEndian.Uint32(body[16:]),
+				octetCount:  binary.BigEndian.Uint32(body[20:]),
+			}
+			info.reportBlocks = parseRTCPReportBlocks(body[24:], count)
+			handler.onSenderReport(binary.BigEndian.Uint32(body), &info, arrival)
+		case rtcpTypeRR:
+			if len(body) < 4+rtcpReportBlockSize*count {
+				return errInvalidRTCPPacket
+			}
+			handler.onReceiverReport(binary.BigEndian.Uint32(body), parseRTCPReportBlocks(body[4:], count), arrival)
+		case rtcpTypeBYE:
+			if len(body) < 4*count {
+				return errInvalidRTCPPacket
+			}
+			ssrcs := make([]uint32, count)
+			for i := range ssrcs {
+				ssrcs[i] = binary.BigEndian.Uint32(body[4*i:])
+			}
+			handler.onGoodbye(ssrcs)
+		}
+	}
+	return nil
+}
+
+func parseRTCPReportBlocks(buf []byte, count int) []rtcpReportBlock {
+	blocks := make([]rtcpReportBlock, count)
+	for i := range blocks {
+		blocks[i].unmarshal(buf[i*rtcpReportBlockSize:])
+	}
+	return blocks
+}
+
+// MediaStats statistics of the RTP streams of a Media
+type MediaStats struct {
+	PacketsSent     uint64
+	BytesSent       uint64
+	PacketsReceived uint64
+	BytesReceived   uint64
+	// PacketsLost the cumulative number of packets lost of the received stream
+	PacketsLost int64
+	// FractionLost the fraction of the received stream lost since the previous report
+	FractionLost float64
+	// Jitter the interarrival jitter of the received stream
+	Jitter time.Duration
+	// RemotePacketsLost RemoteFractionLost RemoteJitter the loss and jitter of the sent stream reported by the peer
+	RemotePacketsLost  int64
+	RemoteFractionLost float64
+	RemoteJitter       time.Duration
+	// RTT the round-trip time calculated from the reports of the peer
+	RTT time.Duration
+}
+
+// rtpStats tracks the sent and received RTP streams as specified in RFC 3550 appendix A.
+type rtpStats struct {
+	mu        sync.Mutex
+	clockRate int
+
+	// sender
+	ssrc          uint32
+	packetsSent   uint64
+	octetsSent    uint64
+	lastTimestamp uint32
+	lastSent      time.Time
+	// sending the stream was sent since the previous report
+	sending bool
+
+	// receiver
+	remoteSSRC      uint32
+	receiving       bool
+	baseSeq, maxSeq uint16
+	cycles          uint32
+	packetsReceived uint64
+	bytesReceived   uint64
+	expectedPrior   uint32
+	receivedPrior   uint32
+	fractionLost    uint8
+	jitter          float64
+	lastTransit     int64
+	epoch           time.Time
+	// lastSR the middle 32 bits of the NTP timestamp of the last SR received
+	lastSR     uint32
+	lastSRTime time.Time
+
+	// reports of the peer
+	rtt                time.Duration
+	remotePacketsLost  int64
+	remoteFractionLost float64
+	remoteJitter       time.Duration
+}
+
+func newRTPStats(clockRate int) *rtpStats {
+	return &rtpStats{clockRate: clockRate, ssrc: rand.Uint32(), epoch: time.Now()}
+}
+
+func (s *rtpStats) onSend(packet *RTPPacket, now time.Time) {
+	s.mu.Lock()
+	s.ssrc = packet.SSRC
+	s.packetsSent++
+	s.octetsSent += uint64(len(packet.Payload))
+	s.lastTimestamp = packet.Timestamp
+	s.lastSent = now
+	s.sending = true
+	s.mu.Unlock()
+}
+
+func (s *rtpStats) onReceive(packet *RTPPacket, size int, arrival time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.receiving || packet.SSRC != s.remoteSSRC {
+		s.receiving = true
+		s.remoteSSRC = packet.SSRC
+		s.baseSeq = packet.SequenceNumber
+		s.maxSeq = packet.SequenceNumber
+		s.cycles = 0
+		s.expectedPrior = 0
+		s.receivedPrior = 0
+		s.jitter = 0
+		s.lastTransit = s.transit(packet.Timestamp, arrival)
+	}
+	s.packetsReceived++
+	s.bytesReceived += uint64(size)
+
+	delta := packet.SequenceNumber - s.maxSeq
+	if delta < 0x8000 {
+		if packet.SequenceNumber < s.maxSeq {
+			s.cycles += 0x10000
+		}
+		s.maxSeq = packet.SequenceNumber
+	}
+
+	transit := s.transit(packet.Timestamp, arrival)
+	d := transit - s.lastTransit
+	if d < 0 {
+		d = -d
+	}
+	s.lastTransit = transit
+	s.jitter += (float64(d) - s.jitter) / 16
+}
+
+func (s *rtpStats) transit(timestamp uint32, arrival time.Time) int64 {
+	return int64(arrival.Sub(s.epoch))*int64(s.clockRate)/int64(time.Second) - int64(timestamp)
+}
+
+func (s *rtpStats) expected() uint32 {
+	return s.cycles + uint32(s.maxSeq) - uint32(s.baseSeq) + 1
+}
+
+func (s *rtpStats) packetsLost() int64 {
+	return int64(s.expected()) - int64(s.packetsReceived)
+}
+
+// reportBlocks generates the report block of the received stream.
+func (s *rtpStats) reportBlocks(now time.Time) []rtcpReportBlock {
+	if !s.receiving {
+		return nil
+	}
+
+	expected := s.expected()
+	expectedInterval := expected - s.expectedPrior
+	receivedInterval := uint32(s.packetsReceived) - s.receivedPrior
+	s.expectedPrior = expected
+	s.receivedPrior = uint32(s.packetsReceived)
+	s.fractionLost = 0
+	if lostInterval := int64(expectedInterval) - int64(receivedInterval); expectedInterval > 0 && lostInterval > 0 {
+		s.fractionLost = uint8((lostInterval << 8) / int64(expectedInterval))
+	}
+
+	lost := s.packetsLost()
+	lost = min(max(lost, -0x800000), 0x7FFFFF)
+	block := rtcpReportBlock{
+		ssrc:            s.remoteSSRC,
+		fractionLost:    s.fractionLost,
+		packetsLost:     int32(lost),
+		highestSequence: s.cycles + uint32(s.maxSeq),
+		jitter:          uint32(s.jitter),
+		lastSR:          s.lastSR,
+	}
+	if s.lastSR != 0 {
+		block.delaySinceLSR = uint32(now.Sub(s.lastSRTime) * 65536 / time.Second)
+	}
+	return []rtcpReportBlock{block}
+}
+
+// report generates a compound RTCP packet, SR if the stream was sent since the previous report, RR otherwise.
+func (s *rtpStats) report(cname string, bye bool) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	blocks := s.reportBlocks(now)
+	buf := make([]byte, 0, 128)
+	if s.sending {
+		info := rtcpSenderInfo{
+			ntpTime:      ntpTime(now),
+			rtpTime:      s.lastTimestamp + uint32(now.Sub(s.lastSent)*time.Duration(s.clockRate)/time.Second),
+			packetCount:  uint32(s.packetsSent),
+			octetCount:   uint32(s.octetsSent),
+			reportBlocks: blocks,
+		}
+		buf = appendRTCPSenderReport(buf, s.ssrc, &info)
+		s.sending = false
+	} else {
+		buf = appendRTCPReceiverReport(buf, s.ssrc, blocks)
+	}
+	buf = appendRTCPSourceDescription(buf, s.ssrc, cname)
+	if bye {
+		buf = appendRTCPGoodbye(buf, s.ssrc)
+	}
+	return buf
+}
+
+func (s *rtpStats) onSenderReport(ssrc uint32, info *rtcpSenderInfo, arrival time.Time) {
+	s.mu.Lock()
+	s.lastSR = uint32(info.ntpTime >> 16)
+	s.lastSRTime = arrival
+	s.mu.Unlock()
+	s.onReceiverReport(ssrc, info.reportBlocks, arrival)
+}
+
+func (s *rtpStats) onReceiverReport(_ uint32, blocks []rtcpReportBlock, arrival time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range blocks {
+		if b.ssrc != s.ssrc {
+			continue
+		}
+		s.remotePacketsLost = int64(b.packetsLost)
+		s.remoteFractionLost = float64(b.fractionLost) / 256
+		s.remoteJitter = time.Duration(uint64(b.jitter) * uint64(time.Second) / uint64(s.clockRate))
+		if b.lastSR != 0 {
+			if rtt := ntpShort(arrival) - b.lastSR - b.delaySinceLSR; rtt < 1<<31 {
+				s.rtt = ntpShortDuration(rtt)
+			}
+		}
+	}
+}
+
+func (s *rtpStats) onGoodbye(_ []uint32) {}
+
+func (s *rtpStats) getStats() MediaStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := MediaStats{
+		PacketsSent:        s.packetsSent,
+		BytesSent:          s.octetsSent,
+		PacketsReceived:    s.packetsReceived,
+		BytesReceived:      s.bytesReceived,
+		FractionLost:       float64(s.fractionLost) / 256,
+		Jitter:             time.Duration(s.jitter * float64(time.Second) / float64(s.clockRate)),
+		RemotePacketsLost:  s.remotePacketsLost,
+		RemoteFractionLost: s.remoteFractionLost,
+		RemoteJitter:       s.remoteJitter,
+		RTT:                s.rtt,
+	}
+	if s.receiving {
+		stats.PacketsLost = s.packetsLost()
+	}
+	return stats
+}
+
+// Stats returns the statistics of the RTP streams.
+func (m *Media) Stats() MediaStats {
+	if m.stats == nil {
+		return MediaStats{}
+	}
+	return m.stats.getStats()
+}
+
+// startRTCP opens the RTCP port unless RTCP is multiplexed with RTP.
+func (m *Media) startRTCP() error {
+	port := m.raudioDesc.Port + 1
+	if m.raudioDesc.RTCPPort != 0 {
+		port = m.raudioDesc.RTCPPort
+	}
+	ip := m.remote.IP
+	if m.raudioDesc.RTCPHost != "" {
+		ip = net.ParseIP(m.raudioDesc.RTCPHost)
+	}
+	m.rtcpRemote = &net.UDPAddr{IP: ip, Port: port}
+	if m.rtcpMux {
+		m.rtcpRemote = m.remote
+		return nil
+	}
+
+	var err error
+	m.rtcpConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(m.laudioDesc.Host), Port: m.laudioDesc.Port + 1})
+	if err != nil {
+		return err
+	}
+	go m.startReadRTCP()
+	return nil
+}
+
+func (m *Media) startReadRTCP() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := m.rtcpConn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		if !m.onRTCP(buf[:n]) {
+			continue
+		}
+		m.latchRTCP(addr)
+	}
+}
+
+// onRTCP handles a received compound RTCP packet, returns false if it is not authenticated or invalid.
+func (m *Media) onRTCP(buf []byte) bool {
+	if m.srtpRx != nil {
+		var err error
+		if buf, err = m.srtpRx.unprotectRTCP(buf); err != nil {
+			m.logger.Warn("failed to unprotect rtcp packet", "error", err)
+			return false
+		}
+	}
+	if err := parseRTCP(buf, m.stats, time.Now()); err != nil {
+		m.logger.Warn("failed to parse rtcp packet", "error", err)
+		return false
+	}
+	return true
+}
+
+// startSendRTCP sends reports at randomized intervals until the media is closed.
+func (m *Media) startSendRTCP() {
+	for {
+		interval := rtcpInterval/2 + time.Duration(rand.Int63n(int64(rtcpInterval)))
+		select {
+		case <-m.done:
+			return
+		case <-time.After(interval):
+			m.sendRTCP(false)
+		}
+	}
+}
+
+func (m *Media) sendRTCP(bye bool) {
+	conn := m.rtcpConn
+	if m.rtcpMux {
+		conn = m.conn
+	}
+	if conn == nil {
+		return
+	}
+	m.mu.Lock()
+	remote := m.rtcpRemote
+	m.mu.Unlock()
+	report := m.stats.report(m.laudioDesc.Host, bye)
+	if m.srtpTx != nil {
+		var err error
+		if report, err = m.srtpTx.protectRTCP(report); err != nil {
+			m.logger.Warn("failed to protect rtcp packet", "error", err)
+			return
+		}
+	}
+	if _, err := conn.WriteToUDP(report, remote); err != nil {
+		m.logger.Warn("failed to send rtcp packet", "error", err)
+	}
+}
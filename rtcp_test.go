@@ -0,0 +1,88 @@
+package mrcp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type rtcpRecorder struct {
+	senderReports   []rtcpSenderInfo
+	receiverReports [][]rtcpReportBlock
+	goodbyes        [][]uint32
+}
+
+func (r *rtcpRecorder) onSenderReport(_ uint32, info *rtcpSenderInfo, _ time.Time) {
+	r.senderReports = append(r.senderReports, *info)
+}
+
+func (r *rtcpRecorder) onReceiverReport(_ uint32, blocks []rtcpReportBlock, _ time.Time) {
+	r.receiverReports = append(r.receiverReports, blocks)
+}
+
+func (r *rtcpRecorder) onGoodbye(ssrcs []uint32) {
+	r.goodbyes = append(r.goodbyes, ssrcs)
+}
+
+func Test_parseRTCP(t *testing.T) {
+	block := rtcpReportBlock{
+		ssrc:            2,
+		fractionLost:    25,
+		packetsLost:     -3,
+		highestSequence: 0x10005,
+		jitter:          80,
+		lastSR:          0x12345678,
+		delaySinceLSR:   0x10000,
+	}
+	info := rtcpSenderInfo{
+		ntpTime:      0x0102030405060708,
+		rtpTime:      160,
+		packetCount:  10,
+		octetCount:   1600,
+		reportBlocks: []rtcpReportBlock{block},
+	}
+
+	var buf []byte
+	buf = appendRTCPSenderReport(buf, 1, &info)
+	buf = appendRTCPReceiverReport(buf, 1, []rtcpReportBlock{block})
+	buf = appendRTCPSourceDescription(buf, 1, "go-mrcp")
+	buf = appendRTCPGoodbye(buf, 1)
+
+	var r rtcpRecorder
+	if err := parseRTCP(buf, &r, time.Now()); err != nil {
+		t.Errorf("parseRTCP() error = %v", err)
+		return
+	}
+	want := rtcpRecorder{
+		senderReports:   []rtcpSenderInfo{info},
+		receiverReports: [][]rtcpReportBlock{{block}},
+		goodbyes:        [][]uint32{{1}},
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("parseRTCP() got = %+v, want %+v", r, want)
+	}
+
+	if err := parseRTCP(buf[:len(buf)-2], &r, time.Now()); err == nil {
+		t.Errorf("parseRTCP() error = %v, wantErr %v", err, true)
+	}
+}
+
+func Test_rtpStats_reportBlocks(t *testing.T) {
+	s := newRTPStats(8000)
+	now := time.Now()
+	for _, seq := range []uint16{65534, 65535, 1, 2} {
+		packet := RTPPacket{SequenceNumber: seq, SSRC: 7}
+		s.onReceive(&packet, 172, now)
+	}
+
+	got := s.reportBlocks(now)
+	want := []rtcpReportBlock{{
+		ssrc:            7,
+		fractionLost:    51,
+		packetsLost:     1,
+		highestSequence: 0x10002,
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("reportBlocks() got = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,268 @@
+package mrcp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	rtpVersion    = 2
+	rtpHeaderSize = 12
+)
+
+var (
+	ErrInvalidRTPPacket = errors.New("invalid rtp packet")
+)
+
+// RTPPacket RTP packet defined in RFC 3550
+type RTPPacket struct {
+	Marker         bool
+	PayloadType    uint8
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+	CSRC           []uint32
+	// Extension whether the header extension is present
+	Extension        bool
+	ExtensionProfile uint16
+	// ExtensionPayload header extension data, a multiple of 4 bytes
+	ExtensionPayload []byte
+	Payload          []byte
+	// PaddingSize number of padding bytes including the last byte
+	PaddingSize uint8
+}
+
+// Unmarshal parses a RTP packet, Payload and ExtensionPayload refer to buf.
+func (p *RTPPacket) Unmarshal(buf []byte) error {
+	if len(buf) < rtpHeaderSize {
+		return ErrInvalidRTPPacket
+	}
+	if buf[0]>>6 != rtpVersion {
+		return ErrInvalidRTPPacket
+	}
+
+	padding := buf[0]&0x20 != 0
+	p.Extension = buf[0]&0x10 != 0
+	cc := int(buf[0] & 0x0F)
+	p.Marker = buf[1]&0x80 != 0
+	p.PayloadType = buf[1] & 0x7F
+	p.SequenceNumber = binary.BigEndian.Uint16(buf[2:])
+	p.Timestamp = binary.BigEndian.Uint32(buf[4:])
+	p.SSRC = binary.BigEndian.Uint32(buf[8:])
+
+	n := rtpHeaderSize
+	if len(buf) < n+4*cc {
+		return ErrInvalidRTPPacket
+	}
+	p.CSRC = p.CSRC[:0]
+	for i := 0; i < cc; i++ {
+		p.CSRC = append(p.CSRC, binary.BigEndian.Uint32(buf[n:]))
+		n += 4
+	}
+
+	p.ExtensionProfile = 0
+	p.ExtensionPayload = nil
+	if p.Extension {
+		if len(buf) < n+4 {
+			return ErrInvalidRTPPacket
+		}
+		p.ExtensionProfile = binary.BigEndian.Uint16(buf[n:])
+		length := 4 * int(binary.BigEndian.Uint16(buf[n+2:]))
+		n += 4
+		if len(buf) < n+length {
+			return ErrInvalidRTPPacket
+		}
+		p.ExtensionPayload = buf[n : n+length]
+		n += length
+	}
+
+	end := len(buf)
+	p.PaddingSize = 0
+	if padding {
+		p.PaddingSize = buf[end-1]
+		if p.PaddingSize == 0 || end-int(p.PaddingSize) < n {
+			return ErrInvalidRTPPacket
+		}
+		end -= int(p.PaddingSize)
+	}
+	p.Payload = buf[n:end]
+	return nil
+}
+
+// MarshalSize returns the size of the marshaled packet.
+func (p *RTPPacket) MarshalSize() int {
+	n := rtpHeaderSize + 4*len(p.CSRC) + len(p.Payload) + int(p.PaddingSize)
+	if p.Extension {
+		n += 4 + len(p.ExtensionPayload)
+	}
+	return n
+}
+
+// MarshalTo serializes the packet into buf, returns the number of bytes written.
+func (p *RTPPacket) MarshalTo(buf []byte) (int, error) {
+	size := p.MarshalSize()
+	if len(buf) < size || len(p.CSRC) > 15 || len(p.ExtensionPayload)%4 != 0 {
+		return 0, ErrInvalidRTPPacket
+	}
+
+	buf[0] = rtpVersion<<6 | byte(len(p.CSRC))
+	if p.PaddingSize > 0 {
+		buf[0] |= 0x20
+	}
+	if p.Extension {
+		buf[0] |= 0x10
+	}
+	buf[1] = p.PayloadType & 0x7F
+	if p.Marker {
+		buf[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(buf[2:], p.SequenceNumber)
+	binary.BigEndian.PutUint32(buf[4:], p.Timestamp)
+	binary.BigEndian.PutUint32(buf[8:], p.SSRC)
+
+	n := rtpHeaderSize
+	for _, csrc := range p.CSRC {
+		binary.BigEndian.PutUint32(buf[n:], csrc)
+		n += 4
+	}
+	if p.Extension {
+		binary.BigEndian.PutUint16(buf[n:], p.ExtensionProfile)
+		binary.BigEndian.PutUint16(buf[n+2:], uint16(len(p.ExtensionPayload)/4))
+		n += 4
+		n += copy(buf[n:], p.ExtensionPayload)
+	}
+	n += copy(buf[n:], p.Payload)
+	if p.PaddingSize > 0 {
+		clear(buf[n : size-1])
+		buf[size-1] = p.PaddingSize
+		n = size
+	}
+	return n, nil
+}
+
+// Marshal serializes the packet.
+func (p *RTPPacket) Marshal() ([]byte, error) {
+	buf := make([]byte, p.MarshalSize())
+	n, err := p.MarshalTo(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// MediaFrameHandler is an alternative to MediaHandler dealing in RTP payloads,
+// the RTP header is generated and parsed by the library.
+// Use NewMediaFrameHandler to convert it to a MediaHandler.
+type MediaFrameHandler interface {
+	// StartTx is called when starting to send RTP stream
+	StartTx(m *Media, codec CodecDesc) error
+	// ReadFrame read the payload of a RTP packet from high-level
+	// an empty frame skips the packet
+	// stop sending by returning false
+	ReadFrame(m *Media) ([]byte, bool)
+
+	// StartRx is called when starting to receive RTP stream
+	StartRx(m *Media, codec CodecDesc) error
+	// WriteFrame write the payload of a received audio packet to high-level
+	// stop receiving by returning false
+	WriteFrame(m *Media, frame []byte) bool
+}
+
+type MediaFrameHandlerFunc struct {
+	StartTxFunc    func(m *Media, codec CodecDesc) error
+	ReadFrameFunc  func(m *Media) ([]byte, bool)
+	StartRxFunc    func(m *Media, codec CodecDesc) error
+	WriteFrameFunc func(m *Media, frame []byte) bool
+}
+
+func (h MediaFrameHandlerFunc) StartTx(m *Media, codec CodecDesc) error {
+	if h.StartTxFunc != nil {
+		return h.StartTxFunc(m, codec)
+	}
+	return nil
+}
+
+func (h MediaFrameHandlerFunc) ReadFrame(m *Media) ([]byte, bool) {
+	if h.ReadFrameFunc != nil {
+		return h.ReadFrameFunc(m)
+	}
+	return nil, false
+}
+
+func (h MediaFrameHandlerFunc) StartRx(m *Media, codec CodecDesc) error {
+	if h.StartRxFunc != nil {
+		return h.StartRxFunc(m, codec)
+	}
+	return nil
+}
+
+func (h MediaFrameHandlerFunc) WriteFrame(m *Media, frame []byte) bool {
+	if h.WriteFrameFunc != nil {
+		return h.WriteFrameFunc(m, frame)
+	}
+	return false
+}
+
+// frameHandler packetizes and depacketizes the frames of a MediaFrameHandler.
+type frameHandler struct {
+	handler MediaFrameHandler
+	rx      CodecDesc
+	// marker set the marker bit on the next packet
+	marker bool
+	packet RTPPacket
+	buf    []byte
+}
+
+// NewMediaFrameHandler converts a MediaFrameHandler to a MediaHandler.
+func NewMediaFrameHandler(handler MediaFrameHandler) MediaHandler {
+	return &frameHandler{handler: handler}
+}
+
+func (h *frameHandler) StartTx(m *Media, codec CodecDesc) error {
+	h.marker = true
+	// the sequence number, timestamp and SSRC are set by Media
+	h.packet = RTPPacket{PayloadType: uint8(codec.PayloadType)}
+	return h.handler.StartTx(m, codec)
+}
+
+func (h *frameHandler) ReadRTPPacket(m *Media) ([]byte, bool) {
+	frame, ok := h.handler.ReadFrame(m)
+	if !ok {
+		return nil, false
+	}
+	if len(frame) == 0 {
+		// the next packet starts a talkspurt
+		h.marker = true
+		return nil, true
+	}
+
+	h.packet.Marker = h.marker
+	h.marker = false
+	h.packet.Payload = frame
+	if size := h.packet.MarshalSize(); cap(h.buf) < size {
+		h.buf = make([]byte, size)
+	}
+	n, err := h.packet.MarshalTo(h.buf[:cap(h.buf)])
+	if err != nil {
+		m.logger.Error("failed to marshal rtp packet", "error", err)
+		return nil, true
+	}
+	return h.buf[:n], true
+}
+
+func (h *frameHandler) StartRx(m *Media, codec CodecDesc) error {
+	h.rx = codec
+	return h.handler.StartRx(m, codec)
+}
+
+func (h *frameHandler) WriteRTPPacket(m *Media, rtp []byte) bool {
+	var packet RTPPacket
+	if err := packet.Unmarshal(rtp); err != nil {
+		m.logger.Warn("failed to parse rtp packet", "error", err)
+		return true
+	}
+	if int(packet.PayloadType) != h.rx.PayloadType {
+		return true
+	}
+	return h.handler.WriteFrame(m, packet.Payload)
+}
@@ -0,0 +1,92 @@
+package mrcp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRTPPacket_Unmarshal(t *testing.T) {
+	type args struct {
+		buf []byte
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    RTPPacket
+		wantErr bool
+	}{
+		{
+			name: "header",
+			args: args{buf: []byte{0x80, 0x88, 0x00, 0x01, 0x00, 0x00, 0x00, 0xA0, 0x12, 0x34, 0x56, 0x78, 0xD5, 0xD5}},
+			want: RTPPacket{
+				Marker:         true,
+				PayloadType:    8,
+				SequenceNumber: 1,
+				Timestamp:      160,
+				SSRC:           0x12345678,
+				Payload:        []byte{0xD5, 0xD5},
+			},
+			wantErr: false,
+		},
+		{
+			name: "csrc extension padding",
+			args: args{buf: []byte{
+				0xB1, 0x00, 0x00, 0x02, 0x00, 0x00, 0x01, 0x40, 0x00, 0x00, 0x00, 0x01,
+				0x00, 0x00, 0x00, 0x02,
+				0xBE, 0xDE, 0x00, 0x01, 0x10, 0xAA, 0x00, 0x00,
+				0xFF, 0xFF,
+				0x00, 0x00, 0x03,
+			}},
+			want: RTPPacket{
+				PayloadType:      0,
+				SequenceNumber:   2,
+				Timestamp:        320,
+				SSRC:             1,
+				CSRC:             []uint32{2},
+				Extension:        true,
+				ExtensionProfile: 0xBEDE,
+				ExtensionPayload: []byte{0x10, 0xAA, 0x00, 0x00},
+				Payload:          []byte{0xFF, 0xFF},
+				PaddingSize:      3,
+			},
+			wantErr: false,
+		},
+		{
+			name:    "short",
+			args:    args{buf: []byte{0x80, 0x00, 0x00, 0x01}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid padding",
+			args:    args{buf: []byte{0xA0, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0xA0, 0x12, 0x34, 0x56, 0x78, 0x20}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got RTPPacket
+			err := got.Unmarshal(tt.args.buf)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal() got = %v, want %v", got, tt.want)
+				return
+			}
+
+			buf, err := got.Marshal()
+			if err != nil {
+				t.Errorf("Marshal() error = %v", err)
+				return
+			}
+			if !bytes.Equal(buf, tt.args.buf) {
+				t.Errorf("Marshal() got = %v, want %v", buf, tt.args.buf)
+			}
+		})
+	}
+}
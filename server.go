@@ -2,15 +2,20 @@ package mrcp
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
 	"log/slog"
 	"net"
 	"strconv"
 	"sync"
+	"time"
 )
 
 type ServerHandler interface {
+	// OnDialogCreate is called on a new dialog before it is answered, the INVITE is rejected
+	// with the status code of a returned RejectError, 500 for other errors.
 	OnDialogCreate(d *DialogServer) (DialogHandler, error)
 }
 
@@ -43,6 +48,51 @@ type Server struct {
 	// RtpPortMin RtpPortMax RTP port range
 	// Default: [20000, 40000)
 	RtpPortMin, RtpPortMax uint16
+	// Latching how the destination of the outgoing media is learned from the received packets
+	// Default: LatchFirstPacket
+	Latching LatchMode
+	// MediaTimeout the dialog is closed if no RTP is received for this duration, 0 disables it,
+	// only the dialogs receiving audio are watched, e.g. not speechsynth
+	// Default: 0
+	MediaTimeout time.Duration
+	// ControlIdleTimeout the dialog is closed if no MRCP message is sent or received on the channel
+	// for this duration, it must exceed the longest request, 0 disables it
+	// Default: 0
+	ControlIdleTimeout time.Duration
+	// SessionExpires the session interval of SIP session timers (RFC 4028), the session is refreshed
+	// or closed with a BYE if not refreshed in time, 0 only runs them on request of the peer
+	// Default: 0
+	SessionExpires time.Duration
+	// MinSE the minimum session interval accepted
+	// Default: 90s
+	MinSE time.Duration
+	// SessionRefreshMethod the method of the session refresh requests, sip.UPDATE or sip.INVITE
+	// Default: sip.UPDATE
+	SessionRefreshMethod sip.RequestMethod
+	// MaxDialogs the maximum number of concurrent dialogs, further INVITEs are rejected with 503, 0 is unlimited
+	// Default: 0
+	MaxDialogs int
+	// MaxDialogsPerResource the maximum number of concurrent dialogs of a resource, 0 is unlimited
+	// Default: nil
+	MaxDialogsPerResource map[Resource]int
+	// MaxDialogsPerSource the maximum number of concurrent dialogs from a source IP, 0 is unlimited
+	// Default: 0
+	MaxDialogsPerSource int
+	// InviteRate the maximum rate of new INVITEs per second, further INVITEs are rejected with 503, 0 is unlimited,
+	// the INVITEs challenged by the Authenticator are not counted
+	// Default: 0
+	InviteRate float64
+	// InviteBurst the number of new INVITEs accepted at once above InviteRate
+	// Default: InviteRate, at least 1
+	InviteBurst int
+	// RetryAfter the Retry-After of the 503 responses when the server is out of capacity
+	// Default: 5s
+	RetryAfter time.Duration
+	// Authenticator optional, challenges the INVITEs without valid digest credentials
+	Authenticator Authenticator
+	// Realm the realm of the digest challenges
+	// Default: Host
+	Realm string
 	// Handler handler
 	Handler ServerHandler
 	// Logger
@@ -50,10 +100,13 @@ type Server struct {
 	Logger *slog.Logger
 
 	// internal
-	porter   *porter
-	ua       sipgo.DialogUA
-	dialogs  sync.Map
-	channels sync.Map
+	porter    *porter
+	nonceKey  []byte
+	ua        sipgo.DialogUA
+	dialogs   sync.Map
+	channels  sync.Map
+	admission admission
+	nonces    nonceCounts
 }
 
 func (s *Server) Run() error {
@@ -78,6 +131,21 @@ func (s *Server) Run() error {
 	if s.RtpPortMax == 0 {
 		s.RtpPortMax = defaultRtpPortMax
 	}
+	if s.MinSE == 0 {
+		s.MinSE = defaultMinSE
+	}
+	if s.SessionRefreshMethod == "" {
+		s.SessionRefreshMethod = sip.UPDATE
+	}
+	if s.InviteRate > 0 && s.InviteBurst <= 0 {
+		s.InviteBurst = max(1, int(s.InviteRate))
+	}
+	if s.RetryAfter == 0 {
+		s.RetryAfter = 5 * time.Second
+	}
+	if s.Realm == "" {
+		s.Realm = s.Host
+	}
 	if s.Logger == nil {
 		s.Logger = slog.Default()
 	}
@@ -87,6 +155,10 @@ func (s *Server) Run() error {
 	if err != nil {
 		return err
 	}
+	s.nonceKey, err = newNonceKey()
+	if err != nil {
+		return err
+	}
 
 	ua, err := sipgo.NewUA()
 	if err != nil {
@@ -101,6 +173,9 @@ func (s *Server) Run() error {
 	sipServer.OnInvite(s.onInvite)
 	sipServer.OnAck(s.onAck)
 	sipServer.OnBye(s.onBye)
+	sipServer.OnCancel(s.onCancel)
+	sipServer.OnUpdate(s.onUpdate)
+	sipServer.OnOptions(s.onOptions)
 
 	client, err := sipgo.NewClient(ua, sipgo.WithClientHostname(s.Host), sipgo.WithClientPort(s.SIPPort))
 	if err != nil {
@@ -156,6 +231,15 @@ func (s *Server) onInvite(req *sip.Request, tx sip.ServerTransaction) {
 	got, ok := s.dialogs.Load(callId)
 	if !ok {
 		// new dialog
+		if s.Authenticator != nil && !s.challenge(req, tx) {
+			return
+		}
+		if !s.allowInvite(time.Now()) {
+			err := fmt.Errorf("%w: INVITE rate exceeded", errOverCapacity)
+			s.Logger.Warn("INVITE rejected", "callId", callId, "error", err)
+			s.respondReject(req, tx, err)
+			return
+		}
 		session, err := s.ua.ReadInvite(req, tx)
 		if err != nil {
 			s.Logger.Error("failed to read INVITE request", "callId", callId, "error", err)
@@ -163,6 +247,24 @@ func (s *Server) onInvite(req *sip.Request, tx sip.ServerTransaction) {
 		}
 		dialog := s.newDialog(session)
 		if err := dialog.onInvite(req, tx); err != nil {
+			_ = dialog.Close()
+			if errors.Is(err, ErrInviteCanceled) {
+				s.Logger.Info("INVITE canceled", "callId", callId)
+				return
+			}
+			if errors.Is(err, errSessionIntervalTooSmall) {
+				// retried by the client with our Min-SE
+				return
+			}
+			if errors.Is(err, errOverCapacity) {
+				s.Logger.Warn("INVITE rejected", "callId", callId, "error", err)
+				return
+			}
+			var re *RejectError
+			if errors.As(err, &re) {
+				s.Logger.Info("INVITE rejected", "callId", callId, "statusCode", re.StatusCode, "reason", re.Reason)
+				return
+			}
 			s.Logger.Error("failed to handle INVITE request", "callId", callId, "error", err)
 			return
 		}
@@ -203,6 +305,31 @@ func (s *Server) onBye(req *sip.Request, tx sip.ServerTransaction) {
 	}
 }
 
+// onCancel answers the CANCELs matching no INVITE transaction,
+// the others are answered by the transaction layer which responds 487 to the INVITE.
+func (s *Server) onCancel(req *sip.Request, tx sip.ServerTransaction) {
+	res := sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil)
+	if err := tx.Respond(res); err != nil {
+		s.Logger.Warn("failed to respond CANCEL request", "callId", req.CallID(), "error", err)
+	}
+}
+
+func (s *Server) onUpdate(req *sip.Request, tx sip.ServerTransaction) {
+	got, ok := s.dialogs.Load(req.CallID().Value())
+	if !ok {
+		res := sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil)
+		if err := tx.Respond(res); err != nil {
+			s.Logger.Warn("failed to respond UPDATE request", "callId", req.CallID(), "error", err)
+		}
+		return
+	}
+	dialog := got.(*DialogServer)
+	if err := dialog.onUpdate(req, tx); err != nil {
+		s.Logger.Error("failed to handle UPDATE request", "callId", req.CallID(), "error", err)
+		return
+	}
+}
+
 func (s *Server) onMessage(c *connection, msg Message) {
 	cid := parseChannelId(msg.GetHeader(HeaderChannelIdentifier))
 	got, ok := s.channels.Load(cid.Id)
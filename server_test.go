@@ -0,0 +1,311 @@
+package mrcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/emiago/sipgo/sip"
+	"log/slog"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// runServer runs a server on free ports and waits until it answers OPTIONS.
+func runServer(t *testing.T, c *Client, s *Server) string {
+	s.Host = "127.0.0.1"
+	s.SIPPort = freePort(t)
+	s.MRCPPort = freePort(t)
+	go func() {
+		if err := s.Run(); err != nil {
+			t.Error(err)
+		}
+	}()
+	t.Cleanup(func() { _ = s.Close() })
+
+	raddr := net.JoinHostPort(s.Host, strconv.Itoa(s.SIPPort))
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		_, err := c.Ping(ctx, raddr)
+		cancel()
+		if err == nil {
+			return raddr
+		}
+	}
+	t.Fatal("server not running")
+	return ""
+}
+
+func TestServer_cancel(t *testing.T) {
+	c := &Client{SIPPort: freePort(t), RtpPortMin: 30000, RtpPortMax: 30100, Logger: slog.Default()}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	created := make(chan *DialogServer, 1)
+	aborted := make(chan struct{})
+	s := &Server{
+		RtpPortMin: 31000,
+		RtpPortMax: 31100,
+		Handler: ServerHandlerFunc{OnDialogCreateFunc: func(d *DialogServer) (DialogHandler, error) {
+			if err := d.RespondProvisional(sip.StatusRinging, "Ringing"); err != nil {
+				t.Errorf("RespondProvisional() error = %v", err)
+			}
+			created <- d
+			<-d.Context().Done()
+			close(aborted)
+			return nil, d.Context().Err()
+		}},
+	}
+	raddr := runServer(t, c, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err := c.Dial(ctx, raddr, ResourceSpeechsynth, nil)
+	var timeout *DialTimeoutError
+	if !errors.As(err, &timeout) {
+		t.Errorf("Dial() error = %v, want %T", err, timeout)
+	}
+
+	d := <-created
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Fatal("OnDialogCreate not aborted")
+	}
+	if err := d.RespondProvisional(sip.StatusSessionInProgress, "Session Progress"); !errors.Is(err, ErrInviteCanceled) {
+		t.Errorf("RespondProvisional() error = %v, want %v", err, ErrInviteCanceled)
+	}
+
+	// the dialog is released
+	for i := 0; i < 20; i++ {
+		if got := s.Capacity(); got.ActiveDialogs == 0 && got.AvailableDialogs == 50 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Errorf("Capacity() got = %v, want %v", s.Capacity(), Capacity{ActiveDialogs: 0, AvailableDialogs: 50})
+}
+
+func TestDialogServer_RespondProvisional(t *testing.T) {
+	d := &DialogServer{}
+	for _, code := range []sip.StatusCode{sip.StatusTrying, sip.StatusOK, sip.StatusBusyHere} {
+		if err := d.RespondProvisional(code, ""); err == nil {
+			t.Errorf("RespondProvisional(%d) error = %v, wantErr %v", code, err, true)
+		}
+	}
+}
+
+func Test_porter_free(t *testing.T) {
+	p, err := newPorter(20000, 20010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := p.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.free(port)
+	p.free(port)
+	p.free(0)
+	if got := p.available(); got != 5 {
+		t.Errorf("available() got = %v, want %v", got, 5)
+	}
+}
+
+func TestServer_rejectError(t *testing.T) {
+	s := &Server{RetryAfter: 10 * time.Second}
+	tests := []struct {
+		name string
+		err  error
+		want *RejectError
+	}{
+		{
+			name: "reject error",
+			err:  fmt.Errorf("wrapped: %w", &RejectError{StatusCode: 486, Reason: "Busy Here"}),
+			want: &RejectError{StatusCode: 486, Reason: "Busy Here"},
+		},
+		{
+			name: "invalid status code",
+			err:  &RejectError{StatusCode: 200, Reason: "OK"},
+			want: &RejectError{StatusCode: 500, Reason: "Internal Server Error"},
+		},
+		{
+			name: "no audio codec",
+			err:  errNoAudioCodec,
+			want: &RejectError{StatusCode: 488, Reason: "Not Acceptable Here"},
+		},
+		{
+			name: "no srtp crypto",
+			err:  errNoSRTPCrypto,
+			want: &RejectError{StatusCode: 488, Reason: "Not Acceptable Here"},
+		},
+		{
+			name: "no free ports",
+			err:  ErrNoFreePorts,
+			want: &RejectError{
+				StatusCode: 503,
+				Reason:     "Service Unavailable",
+				Headers:    []sip.Header{sip.NewHeader("Retry-After", "10")},
+			},
+		},
+		{
+			name: "other",
+			err:  errors.New("failed"),
+			want: &RejectError{StatusCode: 500, Reason: "Internal Server Error"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.rejectError(tt.err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rejectError() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_reject(t *testing.T) {
+	c := &Client{SIPPort: freePort(t), RtpPortMin: 30000, RtpPortMax: 30100, Logger: slog.Default()}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := &Server{
+		RtpPortMin: 31000,
+		RtpPortMax: 31100,
+		Handler: ServerHandlerFunc{OnDialogCreateFunc: func(d *DialogServer) (DialogHandler, error) {
+			if d.GetSIPHeader("X-Busy") != "" {
+				return nil, &RejectError{StatusCode: 486, Reason: "Busy Here"}
+			}
+			return nil, nil
+		}},
+	}
+	raddr := runServer(t, c, s)
+
+	tests := []struct {
+		name       string
+		opts       []DialogClientOptionFunc
+		wantStatus int
+	}{
+		{
+			name:       "rejected by handler",
+			opts:       []DialogClientOptionFunc{WithHeader("X-Busy", "1")},
+			wantStatus: 486,
+		},
+		{
+			name:       "no audio codec",
+			opts:       []DialogClientOptionFunc{WithAudioCodecs([]CodecDesc{{PayloadType: 18, Name: "G729", SampleRate: 8000}})},
+			wantStatus: 488,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			_, err := c.Dial(ctx, raddr, ResourceSpeechsynth, nil, tt.opts...)
+			var rejected *DialRejectedError
+			if !errors.As(err, &rejected) || rejected.StatusCode != tt.wantStatus {
+				t.Errorf("Dial() error = %v, want status %v", err, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestServer_MaxDialogs(t *testing.T) {
+	c := &Client{SIPPort: freePort(t), RtpPortMin: 30000, RtpPortMax: 30100, Logger: slog.Default()}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := &Server{RtpPortMin: 31000, RtpPortMax: 31100, MaxDialogs: 1, RetryAfter: 10 * time.Second}
+	raddr := runServer(t, c, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	dc, err := c.Dial(ctx, raddr, ResourceSpeechsynth, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Dial(ctx, raddr, ResourceSpeechsynth, nil)
+	var rejected *DialRejectedError
+	if !errors.As(err, &rejected) || rejected.StatusCode != 503 {
+		t.Fatalf("Dial() error = %v, want status %v", err, 503)
+	}
+	if got := rejected.Response.GetHeader("Retry-After"); got == nil || got.Value() != "10" {
+		t.Errorf("Dial() Retry-After got = %v, want %v", got, "10")
+	}
+
+	_ = dc.Close()
+	for i := 0; i < 20; i++ {
+		if s.Utilization().Dialogs == 0 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Errorf("Utilization() got = %v, want %v", s.Utilization().Dialogs, 0)
+}
+
+func TestServer_sessionIntervalTooSmall(t *testing.T) {
+	c := &Client{SIPPort: freePort(t), RtpPortMin: 30000, RtpPortMax: 30100, SessionExpires: 60 * time.Second, MinSE: 30 * time.Second, Logger: slog.Default()}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := &Server{RtpPortMin: 31000, RtpPortMax: 31100, MinSE: 90 * time.Second}
+	raddr := runServer(t, c, s)
+
+	// the INVITE is answered 422 and retried with the Min-SE of the server
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	dc, err := c.Dial(ctx, raddr, ResourceSpeechsynth, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close()
+	if got := dc.timer.sessionExpires().interval; got != 90*time.Second {
+		t.Errorf("session interval got = %v, want %v", got, 90*time.Second)
+	}
+	if got := s.Utilization().Dialogs; got != 1 {
+		t.Errorf("Utilization() got = %v, want %v", got, 1)
+	}
+}
+
+func TestServer_InviteRate_authenticated(t *testing.T) {
+	c := &Client{SIPPort: freePort(t), RtpPortMin: 30000, RtpPortMax: 30100, Username: "alice", Password: "secret", Logger: slog.Default()}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := &Server{
+		RtpPortMin:  31000,
+		RtpPortMax:  31100,
+		InviteRate:  0.1,
+		InviteBurst: 1,
+		Authenticator: AuthenticatorFunc{PasswordFunc: func(req *sip.Request, username string) (string, bool) {
+			return "secret", username == "alice"
+		}},
+	}
+	raddr := runServer(t, c, s)
+
+	// the challenged INVITE and its authenticated retry take one token
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	dc, err := c.Dial(ctx, raddr, ResourceSpeechsynth, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close()
+
+	_, err = c.Dial(ctx, raddr, ResourceSpeechsynth, nil)
+	var rejected *DialRejectedError
+	if !errors.As(err, &rejected) || rejected.StatusCode != 503 {
+		t.Errorf("Dial() error = %v, want status %v", err, 503)
+	}
+}
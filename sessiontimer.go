@@ -0,0 +1,284 @@
+package mrcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/emiago/sipgo/sip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SIP session timers, see RFC 4028
+
+const (
+	headerSessionExpires = "Session-Expires"
+	headerMinSE          = "Min-SE"
+	// defaultMinSE the lowest session interval allowed by RFC 4028
+	defaultMinSE = 90 * time.Second
+
+	statusSessionIntervalTooSmall sip.StatusCode = 422
+
+	// refresherUAC refresherUAS the refresher parameter, relative to the transaction carrying it
+	refresherUAC = "uac"
+	refresherUAS = "uas"
+)
+
+var errSessionIntervalTooSmall = errors.New("session interval too small")
+
+// sessionExpires the value of a Session-Expires header
+type sessionExpires struct {
+	interval  time.Duration
+	refresher string
+}
+
+func parseSessionExpires(value string) (sessionExpires, error) {
+	var se sessionExpires
+	params := strings.Split(value, ";")
+	seconds, err := strconv.Atoi(strings.TrimSpace(params[0]))
+	if err != nil || seconds <= 0 {
+		return se, fmt.Errorf("invalid Session-Expires: %s", value)
+	}
+	se.interval = time.Duration(seconds) * time.Second
+	for _, param := range params[1:] {
+		k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(strings.TrimSpace(k), "refresher") {
+			se.refresher = strings.ToLower(strings.TrimSpace(v))
+		}
+	}
+	return se, nil
+}
+
+func (se sessionExpires) String() string {
+	s := formatSeconds(se.interval)
+	if se.refresher != "" {
+		s += ";refresher=" + se.refresher
+	}
+	return s
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.Itoa(int(d / time.Second))
+}
+
+type headerGetter interface {
+	GetHeader(name string) sip.Header
+}
+
+// headerValue returns the value of the header by its name or compact form.
+func headerValue(msg headerGetter, name, compact string) string {
+	if h := msg.GetHeader(name); h != nil {
+		return h.Value()
+	}
+	if h := msg.GetHeader(compact); h != nil {
+		return h.Value()
+	}
+	return ""
+}
+
+// getSessionExpires returns the Session-Expires of a message, false if absent or invalid.
+func getSessionExpires(msg headerGetter) (sessionExpires, bool) {
+	value := headerValue(msg, headerSessionExpires, "x")
+	if value == "" {
+		return sessionExpires{}, false
+	}
+	se, err := parseSessionExpires(value)
+	return se, err == nil
+}
+
+// getMinSE returns the Min-SE of a message, 0 if absent or invalid.
+func getMinSE(msg headerGetter) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(strings.Split(headerValue(msg, headerMinSE, headerMinSE), ";")[0]))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// supportsTimer reports whether the sender of a message supports session timers.
+func supportsTimer(msg headerGetter) bool {
+	for _, value := range []string{headerValue(msg, "Supported", "k"), headerValue(msg, "Require", "Require")} {
+		for _, tag := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(tag), "timer") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// negotiateSessionTimer returns the session timer of the 2xx response to an INVITE or UPDATE,
+// an interval of 0 if the session is not timed.
+// local is the preferred interval, 0 if the session is only timed on request of the peer.
+// Returns errSessionIntervalTooSmall if the requested interval is below minSE.
+func negotiateSessionTimer(req *sip.Request, local, minSE time.Duration) (sessionExpires, error) {
+	se, ok := getSessionExpires(req)
+	switch {
+	case !ok:
+		if local <= 0 {
+			return sessionExpires{}, nil
+		}
+		se = sessionExpires{interval: max(local, minSE)}
+	case se.interval < minSE:
+		return se, errSessionIntervalTooSmall
+	case local > 0 && local < se.interval:
+		// the interval may be reduced but not below any Min-SE
+		se.interval = max(local, minSE, getMinSE(req))
+	}
+	if se.refresher == "" {
+		if supportsTimer(req) {
+			se.refresher = refresherUAC
+		} else {
+			se.refresher = refresherUAS
+		}
+	}
+	return se, nil
+}
+
+// sessionTimerHeaders returns the headers of the 2xx response carrying the session timer.
+func sessionTimerHeaders(se sessionExpires) []sip.Header {
+	if se.interval <= 0 {
+		return nil
+	}
+	headers := []sip.Header{
+		sip.NewHeader("Supported", "timer"),
+		sip.NewHeader(headerSessionExpires, se.String()),
+	}
+	if se.refresher == refresherUAC {
+		headers = append(headers, sip.NewHeader("Require", "timer"))
+	}
+	return headers
+}
+
+// respondSessionIntervalTooSmall responds 422 with the Min-SE.
+func respondSessionIntervalTooSmall(req *sip.Request, tx sip.ServerTransaction, minSE time.Duration) error {
+	res := sip.NewResponseFromRequest(req, statusSessionIntervalTooSmall, "Session Interval Too Small", nil)
+	res.AppendHeader(sip.NewHeader(headerMinSE, formatSeconds(minSE)))
+	return tx.Respond(res)
+}
+
+// sessionTimer refreshes the session at half the interval if we are the refresher,
+// otherwise expires it shortly before the end of the interval.
+type sessionTimer struct {
+	mu        sync.Mutex
+	se        sessionExpires
+	timer     *time.Timer
+	stopped   bool
+	onRefresh func()
+	onExpire  func()
+}
+
+// reset restarts the timer after a successful INVITE or session refresh.
+func (t *sessionTimer) reset(se sessionExpires, refresher bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return
+	}
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.se = se
+	if se.interval <= 0 {
+		return
+	}
+	if refresher {
+		t.timer = time.AfterFunc(se.interval/2, t.onRefresh)
+	} else {
+		t.timer = time.AfterFunc(se.interval-min(32*time.Second, se.interval/3), t.onExpire)
+	}
+}
+
+// sessionExpires returns the current session timer.
+func (t *sessionTimer) sessionExpires() sessionExpires {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.se
+}
+
+func (t *sessionTimer) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+}
+
+// dialogSession a dialog of sipgo, client or server side
+type dialogSession interface {
+	Do(ctx context.Context, req *sip.Request) (*sip.Response, error)
+	WriteRequest(req *sip.Request) error
+}
+
+// refreshSession sends a session refresh request to the remote target, an INVITE carries the local SDP.
+// The interval is raised to the Min-SE of a 422 response and the request sent again.
+// Returns the session timer of the 2xx response and whether we remain the refresher.
+func refreshSession(
+	ctx context.Context,
+	session dialogSession,
+	method sip.RequestMethod,
+	target sip.Uri,
+	transport string,
+	sdp []byte,
+	interval, minSE time.Duration,
+) (sessionExpires, bool, error) {
+	se := sessionExpires{interval: interval, refresher: refresherUAC}
+	for retried := false; ; retried = true {
+		req := sip.NewRequest(method, target)
+		req.SetTransport(transport)
+		req.AppendHeader(sip.NewHeader("Supported", "timer"))
+		req.AppendHeader(sip.NewHeader(headerSessionExpires, se.String()))
+		req.AppendHeader(sip.NewHeader(headerMinSE, formatSeconds(minSE)))
+		if method == sip.INVITE {
+			req.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+			req.SetBody(sdp)
+		}
+
+		res, err := session.Do(ctx, req)
+		if err != nil {
+			return se, false, err
+		}
+		if res.StatusCode == statusSessionIntervalTooSmall && !retried {
+			if m := getMinSE(res); m > se.interval {
+				se.interval = m
+				minSE = max(minSE, m)
+				continue
+			}
+		}
+		if !res.IsSuccess() {
+			return se, false, fmt.Errorf("session refresh rejected: %d %s", res.StatusCode, res.Reason)
+		}
+		if method == sip.INVITE {
+			if err := session.WriteRequest(newAckRequest(req, res)); err != nil {
+				return se, false, fmt.Errorf("failed to send ack: %v", err)
+			}
+		}
+
+		// a peer without session timers leaves the refresh to us
+		if got, ok := getSessionExpires(res); ok {
+			se = got
+		}
+		return se, se.refresher != refresherUAS, nil
+	}
+}
+
+// newAckRequest creates the ACK of the 2xx response to a re-INVITE.
+func newAckRequest(req *sip.Request, res *sip.Response) *sip.Request {
+	ack := sip.NewRequest(sip.ACK, *req.Recipient.Clone())
+	ack.SetTransport(req.Transport())
+	ack.AppendHeader(sip.HeaderClone(req.From()))
+	ack.AppendHeader(sip.HeaderClone(res.To()))
+	ack.AppendHeader(sip.HeaderClone(req.CallID()))
+	ack.AppendHeader(&sip.CSeqHeader{SeqNo: req.CSeq().SeqNo, MethodName: sip.ACK})
+	maxForwards := sip.MaxForwardsHeader(70)
+	ack.AppendHeader(&maxForwards)
+	if h := req.Contact(); h != nil {
+		ack.AppendHeader(sip.HeaderClone(h))
+	}
+	return ack
+}
@@ -0,0 +1,156 @@
+package mrcp
+
+import (
+	"github.com/emiago/sipgo/sip"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_parseSessionExpires(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    sessionExpires
+		wantErr bool
+	}{
+		{name: "interval", value: "1800", want: sessionExpires{interval: 1800 * time.Second}},
+		{name: "refresher", value: "1800;refresher=uac", want: sessionExpires{interval: 1800 * time.Second, refresher: refresherUAC}},
+		{name: "spaces", value: " 90 ; Refresher = UAS", want: sessionExpires{interval: 90 * time.Second, refresher: refresherUAS}},
+		{name: "invalid", value: "abc", wantErr: true},
+		{name: "zero", value: "0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSessionExpires(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseSessionExpires() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSessionExpires() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_negotiateSessionTimer(t *testing.T) {
+	newRequest := func(headers ...string) *sip.Request {
+		req := sip.NewRequest(sip.INVITE, sip.Uri{Host: "127.0.0.1"})
+		for i := 0; i < len(headers); i += 2 {
+			req.AppendHeader(sip.NewHeader(headers[i], headers[i+1]))
+		}
+		return req
+	}
+	tests := []struct {
+		name    string
+		req     *sip.Request
+		local   time.Duration
+		want    sessionExpires
+		wantErr error
+	}{
+		{
+			name: "no timer",
+			req:  newRequest("Supported", "timer"),
+		},
+		{
+			name:  "local timer, uac supports",
+			req:   newRequest("Supported", "100rel, timer"),
+			local: 1800 * time.Second,
+			want:  sessionExpires{interval: 1800 * time.Second, refresher: refresherUAC},
+		},
+		{
+			name:  "local timer, uac does not support",
+			req:   newRequest(),
+			local: 1800 * time.Second,
+			want:  sessionExpires{interval: 1800 * time.Second, refresher: refresherUAS},
+		},
+		{
+			name: "requested refresher",
+			req:  newRequest("Supported", "timer", "Session-Expires", "600;refresher=uas"),
+			want: sessionExpires{interval: 600 * time.Second, refresher: refresherUAS},
+		},
+		{
+			name:  "reduced interval",
+			req:   newRequest("Supported", "timer", "Session-Expires", "1800", "Min-SE", "300"),
+			local: 120 * time.Second,
+			want:  sessionExpires{interval: 300 * time.Second, refresher: refresherUAC},
+		},
+		{
+			name:  "longer local interval",
+			req:   newRequest("k", "timer", "x", "600"),
+			local: 1800 * time.Second,
+			want:  sessionExpires{interval: 600 * time.Second, refresher: refresherUAC},
+		},
+		{
+			name:    "too small",
+			req:     newRequest("Supported", "timer", "Session-Expires", "60"),
+			want:    sessionExpires{interval: 60 * time.Second},
+			wantErr: errSessionIntervalTooSmall,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := negotiateSessionTimer(tt.req, tt.local, defaultMinSE)
+			if err != tt.wantErr {
+				t.Errorf("negotiateSessionTimer() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("negotiateSessionTimer() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_sessionTimerHeaders(t *testing.T) {
+	res := sip.NewResponse(sip.StatusOK, "OK")
+	for _, h := range sessionTimerHeaders(sessionExpires{interval: 1800 * time.Second, refresher: refresherUAC}) {
+		res.AppendHeader(h)
+	}
+	if got, ok := getSessionExpires(res); !ok || got.String() != "1800;refresher=uac" {
+		t.Errorf("getSessionExpires() got = %v, want %v", got, "1800;refresher=uac")
+	}
+	if !supportsTimer(res) {
+		t.Errorf("supportsTimer() got = %v, want %v", false, true)
+	}
+	if got := sessionTimerHeaders(sessionExpires{}); got != nil {
+		t.Errorf("sessionTimerHeaders() got = %v, want %v", got, nil)
+	}
+}
+
+func Test_sessionTimer(t *testing.T) {
+	var refreshed, expired atomic.Int32
+	timer := sessionTimer{
+		onRefresh: func() { refreshed.Add(1) },
+		onExpire:  func() { expired.Add(1) },
+	}
+
+	// the refresher refreshes at half the interval
+	timer.reset(sessionExpires{interval: 200 * time.Millisecond}, true)
+	time.Sleep(150 * time.Millisecond)
+	if refreshed.Load() != 1 || expired.Load() != 0 {
+		t.Errorf("refresh got = %d/%d, want %d/%d", refreshed.Load(), expired.Load(), 1, 0)
+	}
+
+	// the other side expires the session before the end of the interval
+	timer.reset(sessionExpires{interval: 300 * time.Millisecond}, false)
+	time.Sleep(150 * time.Millisecond)
+	if expired.Load() != 0 {
+		t.Errorf("expire got = %d, want %d", expired.Load(), 0)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if expired.Load() != 1 {
+		t.Errorf("expire got = %d, want %d", expired.Load(), 1)
+	}
+
+	// no timer after stop
+	timer.reset(sessionExpires{interval: 100 * time.Millisecond}, true)
+	timer.stop()
+	timer.reset(sessionExpires{interval: 100 * time.Millisecond}, true)
+	time.Sleep(100 * time.Millisecond)
+	if refreshed.Load() != 1 {
+		t.Errorf("stop got = %d, want %d", refreshed.Load(), 1)
+	}
+}
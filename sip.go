@@ -59,7 +59,14 @@ func (p *porter) get() (uint16, error) {
 	}
 }
 
+// free releases a port pair got, other ports are ignored.
 func (p *porter) free(port uint16) {
-	p.ports.Delete(port)
-	p.portsUsed.Add(-2)
+	if _, ok := p.ports.LoadAndDelete(port); ok {
+		p.portsUsed.Add(-2)
+	}
+}
+
+// available returns the number of free RTP and RTCP port pairs.
+func (p *porter) available() int {
+	return (int(p.portsRange) - int(p.portsUsed.Load())) / 2
 }
@@ -0,0 +1,419 @@
+package mrcp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type SRTPProfile string
+
+const (
+	SRTPAESCM128HMACSHA180 SRTPProfile = "AES_CM_128_HMAC_SHA1_80"
+	SRTPAESCM128HMACSHA132 SRTPProfile = "AES_CM_128_HMAC_SHA1_32"
+)
+
+const (
+	srtpMasterKeySize  = 16
+	srtpMasterSaltSize = 14
+	srtpAuthKeySize    = 20
+	// srtcpAuthTagSize the authentication tag of SRTCP is 80 bits for both profiles
+	srtcpAuthTagSize = 10
+	srtcpIndexSize   = 4
+	srtpReplayWindow = 64
+)
+
+const (
+	labelRTPEncryption  = 0x00
+	labelRTPAuthTag     = 0x01
+	labelRTPSalt        = 0x02
+	labelRTCPEncryption = 0x03
+	labelRTCPAuthTag    = 0x04
+	labelRTCPSalt       = 0x05
+)
+
+var (
+	errSRTPAuthFailed = errors.New("srtp authentication failed")
+	errSRTPReplayed   = errors.New("srtp packet replayed")
+	errNoSRTPCrypto   = errors.New("no available srtp crypto")
+)
+
+func (p SRTPProfile) authTagSize() int {
+	switch p {
+	case SRTPAESCM128HMACSHA180:
+		return 10
+	case SRTPAESCM128HMACSHA132:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// CryptoDesc the SDP crypto attribute, see RFC 4568
+type CryptoDesc struct {
+	Tag     int
+	Profile SRTPProfile
+	// Key the concatenated master key and master salt
+	Key []byte
+}
+
+func parseCrypto(raw string) (CryptoDesc, error) {
+	fields := strings.Fields(raw)
+	if len(fields) < 3 {
+		return CryptoDesc{}, fmt.Errorf("invalid crypto: %s", raw)
+	}
+	tag, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return CryptoDesc{}, fmt.Errorf("invalid crypto tag: %s", fields[0])
+	}
+	// inline:<key||salt>[|lifetime][|MKI:length], only the first key is used
+	params, _, _ := strings.Cut(fields[2], ";")
+	inline, ok := strings.CutPrefix(params, "inline:")
+	if !ok {
+		return CryptoDesc{}, fmt.Errorf("invalid crypto key params: %s", fields[2])
+	}
+	inline, _, _ = strings.Cut(inline, "|")
+	key, err := base64.StdEncoding.DecodeString(inline)
+	if err != nil {
+		key, err = base64.RawStdEncoding.DecodeString(inline)
+		if err != nil {
+			return CryptoDesc{}, fmt.Errorf("invalid crypto key: %v", err)
+		}
+	}
+	return CryptoDesc{Tag: tag, Profile: SRTPProfile(fields[1]), Key: key}, nil
+}
+
+func (c CryptoDesc) String() string {
+	return strconv.Itoa(c.Tag) + " " + string(c.Profile) + " inline:" + base64.StdEncoding.EncodeToString(c.Key)
+}
+
+// supported reports whether the profile and key are usable.
+func (c CryptoDesc) supported() bool {
+	return c.Profile.authTagSize() > 0 && len(c.Key) == srtpMasterKeySize+srtpMasterSaltSize
+}
+
+// newCryptoDesc generates a crypto attribute with a random master key.
+func newCryptoDesc(tag int, profile SRTPProfile) (CryptoDesc, error) {
+	key := make([]byte, srtpMasterKeySize+srtpMasterSaltSize)
+	if _, err := rand.Read(key); err != nil {
+		return CryptoDesc{}, err
+	}
+	return CryptoDesc{Tag: tag, Profile: profile, Key: key}, nil
+}
+
+// deriveSessionKey derives a session key from the master key as specified in RFC 3711 section 4.3,
+// the key derivation rate is 0.
+func deriveSessionKey(block cipher.Block, masterSalt []byte, label byte, size int) []byte {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, masterSalt)
+	iv[7] ^= label
+	out := make([]byte, size)
+	cipher.NewCTR(block, iv).XORKeyStream(out, out)
+	return out
+}
+
+// srtpSession the keys of one direction of SRTP and SRTCP.
+type srtpSession struct {
+	profile   SRTPProfile
+	rtpBlock  cipher.Block
+	rtpSalt   []byte
+	rtpAuth   hash.Hash
+	rtcpBlock cipher.Block
+	rtcpSalt  []byte
+	rtcpAuth  hash.Hash
+	authTag   int
+	mu        sync.Mutex
+	ssrc      uint32
+	started   bool
+	roc       uint32
+	lastSeq   uint16
+	replay    uint64
+	rtcpIndex uint32
+	// rtcpStarted rtcpLast rtcpReplay the highest received SRTCP index and the replay window below it
+	rtcpStarted bool
+	rtcpLast    uint32
+	rtcpReplay  uint64
+}
+
+func newSRTPSession(crypto CryptoDesc) (*srtpSession, error) {
+	if !crypto.supported() {
+		return nil, fmt.Errorf("unsupported srtp crypto: %s", crypto.Profile)
+	}
+	master, err := aes.NewCipher(crypto.Key[:srtpMasterKeySize])
+	if err != nil {
+		return nil, err
+	}
+	masterSalt := crypto.Key[srtpMasterKeySize:]
+
+	s := &srtpSession{profile: crypto.Profile, authTag: crypto.Profile.authTagSize()}
+	if s.rtpBlock, err = aes.NewCipher(deriveSessionKey(master, masterSalt, labelRTPEncryption, srtpMasterKeySize)); err != nil {
+		return nil, err
+	}
+	s.rtpSalt = deriveSessionKey(master, masterSalt, labelRTPSalt, srtpMasterSaltSize)
+	s.rtpAuth = hmac.New(sha1.New, deriveSessionKey(master, masterSalt, labelRTPAuthTag, srtpAuthKeySize))
+	if s.rtcpBlock, err = aes.NewCipher(deriveSessionKey(master, masterSalt, labelRTCPEncryption, srtpMasterKeySize)); err != nil {
+		return nil, err
+	}
+	s.rtcpSalt = deriveSessionKey(master, masterSalt, labelRTCPSalt, srtpMasterSaltSize)
+	s.rtcpAuth = hmac.New(sha1.New, deriveSessionKey(master, masterSalt, labelRTCPAuthTag, srtpAuthKeySize))
+	return s, nil
+}
+
+// counter returns the AES-CM IV of a packet, see RFC 3711 section 4.1.1:
+// the salt XOR the SSRC at bytes 4-7 XOR the 48-bit index (ROC and SEQ) at bytes 8-13.
+func counter(salt []byte, ssrc uint32, index uint64) []byte {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, salt)
+	var v [8]byte
+	binary.BigEndian.PutUint32(v[:4], ssrc)
+	for i := 0; i < 4; i++ {
+		iv[4+i] ^= v[i]
+	}
+	binary.BigEndian.PutUint64(v[:], index<<16)
+	for i := 0; i < 6; i++ {
+		iv[8+i] ^= v[i]
+	}
+	return iv
+}
+
+func (s *srtpSession) rtpAuthTag(buf []byte, roc uint32) []byte {
+	s.rtpAuth.Reset()
+	s.rtpAuth.Write(buf)
+	_ = binary.Write(s.rtpAuth, binary.BigEndian, roc)
+	return s.rtpAuth.Sum(nil)[:s.authTag]
+}
+
+// rtpHeaderLen returns the size of the RTP header including CSRCs and extension,
+// the payload may be encrypted so padding is not inspected.
+func rtpHeaderLen(buf []byte) (int, error) {
+	if len(buf) < rtpHeaderSize || buf[0]>>6 != rtpVersion {
+		return 0, ErrInvalidRTPPacket
+	}
+	n := rtpHeaderSize + 4*int(buf[0]&0x0F)
+	if buf[0]&0x10 != 0 {
+		if len(buf) < n+4 {
+			return 0, ErrInvalidRTPPacket
+		}
+		n += 4 + 4*int(binary.BigEndian.Uint16(buf[n+2:]))
+	}
+	if len(buf) < n {
+		return 0, ErrInvalidRTPPacket
+	}
+	return n, nil
+}
+
+// protectRTP encrypts and authenticates a RTP packet.
+func (s *srtpSession) protectRTP(buf []byte) ([]byte, error) {
+	headerSize, err := rtpHeaderLen(buf)
+	if err != nil {
+		return nil, err
+	}
+	seq := binary.BigEndian.Uint16(buf[2:])
+	ssrc := binary.BigEndian.Uint32(buf[8:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.started || s.ssrc != ssrc {
+		s.started = true
+		s.ssrc = ssrc
+		s.roc = 0
+	} else if seq < s.lastSeq && s.lastSeq-seq > 0x8000 {
+		s.roc++
+	}
+	s.lastSeq = seq
+	index := uint64(s.roc)<<16 | uint64(seq)
+
+	out := make([]byte, len(buf), len(buf)+s.authTag)
+	copy(out, buf)
+	cipher.NewCTR(s.rtpBlock, counter(s.rtpSalt, ssrc, index)).XORKeyStream(out[headerSize:], out[headerSize:])
+	return append(out, s.rtpAuthTag(out, s.roc)...), nil
+}
+
+// unprotectRTP authenticates and decrypts a SRTP packet.
+func (s *srtpSession) unprotectRTP(buf []byte) ([]byte, error) {
+	if len(buf) < rtpHeaderSize+s.authTag {
+		return nil, ErrInvalidRTPPacket
+	}
+	authenticated := buf[:len(buf)-s.authTag]
+	headerSize, err := rtpHeaderLen(authenticated)
+	if err != nil {
+		return nil, err
+	}
+	seq := binary.BigEndian.Uint16(buf[2:])
+	ssrc := binary.BigEndian.Uint32(buf[8:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	roc, lastSeq, replay := s.roc, s.lastSeq, s.replay
+	if !s.started || s.ssrc != ssrc {
+		// a new stream, the state is replaced once the packet is authenticated
+		roc, lastSeq, replay = 0, seq, 0
+	}
+
+	// estimate the rollover counter, see RFC 3711 section 3.3.1
+	last := uint64(roc)<<16 | uint64(lastSeq)
+	if lastSeq < 0x8000 {
+		if seq > lastSeq && seq-lastSeq > 0x8000 && roc > 0 {
+			roc--
+		}
+	} else if lastSeq-0x8000 > seq {
+		roc++
+	}
+	index := uint64(roc)<<16 | uint64(seq)
+
+	// replay protection
+	if index <= last {
+		delta := last - index
+		if delta >= srtpReplayWindow || replay&(1<<delta) != 0 {
+			return nil, errSRTPReplayed
+		}
+	}
+
+	if subtle.ConstantTimeCompare(s.rtpAuthTag(authenticated, roc), buf[len(authenticated):]) != 1 {
+		return nil, errSRTPAuthFailed
+	}
+
+	if index > last {
+		if shift := index - last; shift < srtpReplayWindow {
+			replay = replay<<shift | 1
+		} else {
+			replay = 1
+		}
+		last = index
+	} else {
+		replay |= 1 << (last - index)
+	}
+	s.started = true
+	s.ssrc = ssrc
+	s.roc = uint32(last >> 16)
+	s.lastSeq = uint16(last)
+	s.replay = replay
+
+	out := make([]byte, len(authenticated))
+	copy(out, authenticated)
+	cipher.NewCTR(s.rtpBlock, counter(s.rtpSalt, ssrc, index)).XORKeyStream(out[headerSize:], out[headerSize:])
+	return out, nil
+}
+
+func (s *srtpSession) rtcpAuthTag(buf []byte) []byte {
+	s.rtcpAuth.Reset()
+	s.rtcpAuth.Write(buf)
+	return s.rtcpAuth.Sum(nil)[:srtcpAuthTagSize]
+}
+
+// protectRTCP encrypts and authenticates a compound RTCP packet.
+func (s *srtpSession) protectRTCP(buf []byte) ([]byte, error) {
+	if len(buf) < 8 {
+		return nil, errInvalidRTCPPacket
+	}
+	ssrc := binary.BigEndian.Uint32(buf[4:])
+
+	s.mu.Lock()
+	s.rtcpIndex = (s.rtcpIndex + 1) & 0x7FFFFFFF
+	index := s.rtcpIndex
+	s.mu.Unlock()
+
+	out := make([]byte, len(buf), len(buf)+srtcpIndexSize+srtcpAuthTagSize)
+	copy(out, buf)
+	cipher.NewCTR(s.rtcpBlock, counter(s.rtcpSalt, ssrc, uint64(index))).XORKeyStream(out[8:], out[8:])
+	// E-flag set, the packet is encrypted
+	out = binary.BigEndian.AppendUint32(out, 1<<31|index)
+	s.mu.Lock()
+	tag := s.rtcpAuthTag(out)
+	s.mu.Unlock()
+	return append(out, tag...), nil
+}
+
+// unprotectRTCP authenticates and decrypts a SRTCP packet.
+func (s *srtpSession) unprotectRTCP(buf []byte) ([]byte, error) {
+	if len(buf) < 8+srtcpIndexSize+srtcpAuthTagSize {
+		return nil, errInvalidRTCPPacket
+	}
+	authenticated := buf[:len(buf)-srtcpAuthTagSize]
+	trailer := binary.BigEndian.Uint32(authenticated[len(authenticated)-srtcpIndexSize:])
+	encrypted := trailer&(1<<31) != 0
+	index := trailer & 0x7FFFFFFF
+	ssrc := binary.BigEndian.Uint32(buf[4:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// replay protection, as SRTP
+	last, replay := s.rtcpLast, s.rtcpReplay
+	if s.rtcpStarted && index <= last {
+		delta := last - index
+		if delta >= srtpReplayWindow || replay&(1<<delta) != 0 {
+			return nil, errSRTPReplayed
+		}
+	}
+	if subtle.ConstantTimeCompare(s.rtcpAuthTag(authenticated), buf[len(authenticated):]) != 1 {
+		return nil, errSRTPAuthFailed
+	}
+	switch {
+	case !s.rtcpStarted:
+		replay, last = 1, index
+	case index > last:
+		if shift := index - last; shift < srtpReplayWindow {
+			replay = replay<<shift | 1
+		} else {
+			replay = 1
+		}
+		last = index
+	default:
+		replay |= 1 << (last - index)
+	}
+	s.rtcpStarted = true
+	s.rtcpLast = last
+	s.rtcpReplay = replay
+
+	out := make([]byte, len(authenticated)-srtcpIndexSize)
+	copy(out, authenticated)
+	if encrypted {
+		cipher.NewCTR(s.rtcpBlock, counter(s.rtcpSalt, ssrc, uint64(index))).XORKeyStream(out[8:], out[8:])
+	}
+	return out, nil
+}
+
+// negotiateCrypto selects the crypto attribute of the answer matching the offer.
+func (m *Media) negotiateCrypto(lcrypto, rcrypto []CryptoDesc) error {
+	if len(lcrypto) == 0 && len(rcrypto) == 0 {
+		return nil
+	}
+	for _, r := range rcrypto {
+		for _, l := range lcrypto {
+			if r.Tag != l.Tag || r.Profile != l.Profile || !r.supported() {
+				continue
+			}
+			var err error
+			if m.srtpTx, err = newSRTPSession(l); err != nil {
+				return err
+			}
+			if m.srtpRx, err = newSRTPSession(r); err != nil {
+				return err
+			}
+			return nil
+		}
+	}
+	return errNoSRTPCrypto
+}
+
+// answerCrypto selects the first supported crypto attribute of the offer,
+// returns the attribute of the answer.
+func answerCrypto(rcrypto []CryptoDesc) (CryptoDesc, error) {
+	for _, r := range rcrypto {
+		if r.supported() {
+			return newCryptoDesc(r.Tag, r.Profile)
+		}
+	}
+	return CryptoDesc{}, errNoSRTPCrypto
+}
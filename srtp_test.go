@@ -0,0 +1,237 @@
+package mrcp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Test_deriveSessionKey uses the test vectors of RFC 3711 appendix B.3
+func Test_deriveSessionKey(t *testing.T) {
+	master, _ := aes.NewCipher(mustHex("E1F97A0D3E018BE0D64FA32C06DE4139"))
+	salt := mustHex("0EC675AD498AFEEBB6960B3AABE6")
+	tests := []struct {
+		name  string
+		label byte
+		size  int
+		want  []byte
+	}{
+		{name: "cipher key", label: labelRTPEncryption, size: 16, want: mustHex("C61E7A93744F39EE10734AFE3FF7A087")},
+		{name: "cipher salt", label: labelRTPSalt, size: 14, want: mustHex("30CBBC08863D8C85D49DB34A9AE1")},
+		{name: "auth key", label: labelRTPAuthTag, size: 20, want: mustHex("CEBE321F6FF7716B6FD4AB49AF256A156D38BAA4")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deriveSessionKey(master, salt, tt.label, tt.size); !bytes.Equal(got, tt.want) {
+				t.Errorf("deriveSessionKey() got = %X, want %X", got, tt.want)
+			}
+		})
+	}
+}
+
+// Test_counter uses the test vector of RFC 3711 appendix B.2
+func Test_counter(t *testing.T) {
+	iv := counter(mustHex("F0F1F2F3F4F5F6F7F8F9FAFBFCFD"), 0, 0)
+	if want := mustHex("F0F1F2F3F4F5F6F7F8F9FAFBFCFD0000"); !bytes.Equal(iv, want) {
+		t.Errorf("counter() got = %X, want %X", iv, want)
+	}
+	block, _ := aes.NewCipher(mustHex("2B7E151628AED2A6ABF7158809CF4F3C"))
+	keystream := make([]byte, 16)
+	block.Encrypt(keystream, iv)
+	if want := mustHex("E03EAD0935C95E80E166B16DD92B4EB4"); !bytes.Equal(keystream, want) {
+		t.Errorf("keystream got = %X, want %X", keystream, want)
+	}
+
+	// SSRC at bytes 4-7, ROC at bytes 8-11 and SEQ at bytes 12-13
+	tests := []struct {
+		ssrc  uint32
+		index uint64
+		want  string
+	}{
+		{ssrc: 0, index: 1, want: "F0F1F2F3F4F5F6F7F8F9FAFBFCFC0000"},
+		{ssrc: 0x11223344, index: 0x55667788<<16 | 0x99AA, want: "F0F1F2F3E5D7C5B3AD9F8D7365570000"},
+	}
+	for _, tt := range tests {
+		if got := counter(mustHex("F0F1F2F3F4F5F6F7F8F9FAFBFCFD"), tt.ssrc, tt.index); !bytes.Equal(got, mustHex(tt.want)) {
+			t.Errorf("counter() got = %X, want %s", got, tt.want)
+		}
+	}
+}
+
+// Test_srtpSession_protectRTP encrypts a packet with a non-zero ROC and SEQ with the keys of
+// RFC 3711 appendix B.3, the expected packet is computed independently with AES-128-CTR and HMAC-SHA1.
+func Test_srtpSession_protectRTP(t *testing.T) {
+	crypto := CryptoDesc{
+		Tag:     1,
+		Profile: SRTPAESCM128HMACSHA180,
+		Key:     mustHex("E1F97A0D3E018BE0D64FA32C06DE4139" + "0EC675AD498AFEEBB6960B3AABE6"),
+	}
+	tx, err := newSRTPSession(crypto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.started, tx.ssrc, tx.roc, tx.lastSeq = true, 0xCAFEBABE, 5, 0x1233
+
+	packet := RTPPacket{PayloadType: 0, SequenceNumber: 0x1234, Timestamp: 0xDECAFBAD, SSRC: 0xCAFEBABE, Payload: bytes.Repeat([]byte{0xAB}, 32)}
+	plain, _ := packet.Marshal()
+	got, err := tx.protectRTP(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := mustHex("80001234DECAFBADCAFEBABE" +
+		"4282814D07ED717D5ED12DB112EB2C1DF0B49E3320E44B756CFFE5D2FB4B7977" +
+		"E3664AECD8F3FB7EF876")
+	if !bytes.Equal(got, want) {
+		t.Errorf("protectRTP() got = %X, want %X", got, want)
+	}
+
+	rx, _ := newSRTPSession(crypto)
+	rx.started, rx.ssrc, rx.roc, rx.lastSeq = true, 0xCAFEBABE, 5, 0x1233
+	if got, err := rx.unprotectRTP(want); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("unprotectRTP() got = %X, %v, want %X", got, err, plain)
+	}
+}
+
+func Test_srtpSession(t *testing.T) {
+	for _, profile := range []SRTPProfile{SRTPAESCM128HMACSHA180, SRTPAESCM128HMACSHA132} {
+		t.Run(string(profile), func(t *testing.T) {
+			crypto, err := newCryptoDesc(1, profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx, err := newSRTPSession(crypto)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rx, _ := newSRTPSession(crypto)
+
+			// crossing the sequence number wrap increments the rollover counter
+			for _, seq := range []uint16{0xFFFE, 0xFFFF, 0, 1} {
+				packet := RTPPacket{PayloadType: 0, SequenceNumber: seq, Timestamp: 160, SSRC: 0x1234, Payload: bytes.Repeat([]byte{0xFF}, 160)}
+				plain, _ := packet.Marshal()
+				protected, err := tx.protectRTP(plain)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(protected) != len(plain)+profile.authTagSize() || bytes.Equal(protected[rtpHeaderSize:len(plain)], plain[rtpHeaderSize:]) {
+					t.Fatalf("protectRTP() payload is not encrypted")
+				}
+				got, err := rx.unprotectRTP(protected)
+				if err != nil {
+					t.Fatalf("unprotectRTP() seq %d error = %v", seq, err)
+				}
+				if !bytes.Equal(got, plain) {
+					t.Errorf("unprotectRTP() got = %X, want %X", got, plain)
+				}
+				if _, err := rx.unprotectRTP(protected); err != errSRTPReplayed {
+					t.Errorf("unprotectRTP() replayed error = %v, want %v", err, errSRTPReplayed)
+				}
+			}
+			if rx.roc != 1 {
+				t.Errorf("roc got = %d, want 1", rx.roc)
+			}
+
+			packet := RTPPacket{SequenceNumber: 2, SSRC: 0x1234, Payload: []byte{1, 2, 3}}
+			plain, _ := packet.Marshal()
+			protected, _ := tx.protectRTP(plain)
+			protected[rtpHeaderSize] ^= 1
+			if _, err := rx.unprotectRTP(protected); err != errSRTPAuthFailed {
+				t.Errorf("unprotectRTP() tampered error = %v, want %v", err, errSRTPAuthFailed)
+			}
+
+			report := appendRTCPGoodbye(appendRTCPReceiverReport(nil, 0x1234, nil), 0x1234)
+			protected, err = tx.protectRTCP(report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := rx.unprotectRTCP(protected)
+			if err != nil {
+				t.Fatalf("unprotectRTCP() error = %v", err)
+			}
+			if !bytes.Equal(got, report) {
+				t.Errorf("unprotectRTCP() got = %X, want %X", got, report)
+			}
+			if _, err := rx.unprotectRTCP(protected); err != errSRTPReplayed {
+				t.Errorf("unprotectRTCP() replayed error = %v, want %v", err, errSRTPReplayed)
+			}
+
+			// reordered packets within the window are accepted once, older packets are rejected
+			var reports [][]byte
+			for i := 0; i < srtpReplayWindow+2; i++ {
+				protected, _ := tx.protectRTCP(report)
+				reports = append(reports, protected)
+			}
+			for _, tt := range []struct {
+				i       int
+				wantErr error
+			}{
+				{i: 1},
+				{i: 0},
+				{i: 0, wantErr: errSRTPReplayed},
+				{i: srtpReplayWindow + 1},
+				{i: 2},
+				{i: 1, wantErr: errSRTPReplayed},
+				{i: srtpReplayWindow},
+				{i: srtpReplayWindow, wantErr: errSRTPReplayed},
+			} {
+				if _, err := rx.unprotectRTCP(reports[tt.i]); err != tt.wantErr {
+					t.Errorf("unprotectRTCP() report %d error = %v, want %v", tt.i, err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func Test_parseCrypto(t *testing.T) {
+	key := mustHex("E1F97A0D3E018BE0D64FA32C06DE41390EC675AD498AFEEBB6960B3AABE6")
+	type args struct {
+		raw string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    CryptoDesc
+		wantErr bool
+	}{
+		{
+			name: "crypto",
+			args: args{raw: "1 AES_CM_128_HMAC_SHA1_80 inline:4fl6DT4Bi+DWT6MsBt5BOQ7Gda1Jiv7rtpYLOqvm"},
+			want: CryptoDesc{Tag: 1, Profile: SRTPAESCM128HMACSHA180, Key: key},
+		},
+		{
+			name: "lifetime and mki",
+			args: args{raw: "2 AES_CM_128_HMAC_SHA1_32 inline:4fl6DT4Bi+DWT6MsBt5BOQ7Gda1Jiv7rtpYLOqvm|2^20|1:4 KDR=0"},
+			want: CryptoDesc{Tag: 2, Profile: SRTPAESCM128HMACSHA132, Key: key},
+		},
+		{
+			name:    "invalid",
+			args:    args{raw: "1 AES_CM_128_HMAC_SHA1_80"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCrypto(tt.args.raw)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseCrypto() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCrypto() got = %v, want %v", got, tt.want)
+			}
+			if !tt.wantErr && tt.name == "crypto" && got.String() != tt.args.raw {
+				t.Errorf("String() got = %v, want %v", got.String(), tt.args.raw)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package mrcp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Timeout the kind of inactivity detected on a dialog
+type Timeout int
+
+const (
+	// TimeoutMedia no RTP was received
+	TimeoutMedia Timeout = iota
+	// TimeoutControl no MRCP message was sent or received on the channel
+	TimeoutControl
+	// TimeoutSession the SIP session was not refreshed before its session timer expired
+	TimeoutSession
+)
+
+func (t Timeout) String() string {
+	switch t {
+	case TimeoutMedia:
+		return "media"
+	case TimeoutControl:
+		return "control"
+	case TimeoutSession:
+		return "session"
+	default:
+		return "unknown"
+	}
+}
+
+// TimeoutHandler can be implemented by a DialogHandler to be notified before
+// a dialog is closed on inactivity, see Server.MediaTimeout, Server.ControlIdleTimeout and Server.SessionExpires.
+type TimeoutHandler interface {
+	OnTimeout(timeout Timeout)
+}
+
+// epoch the origin of the monotonic times of the activity
+var epoch = time.Now()
+
+// activity records the monotonic time of the last activity.
+type activity struct {
+	last atomic.Int64
+}
+
+func (a *activity) touch() {
+	a.last.Store(int64(time.Since(epoch)))
+}
+
+// idle returns the duration since the last activity.
+func (a *activity) idle() time.Duration {
+	return time.Since(epoch) - time.Duration(a.last.Load())
+}
+
+// watchIdle calls onTimeout once the activity is idle for timeout, returns when done is closed.
+func watchIdle(a *activity, timeout time.Duration, done <-chan struct{}, onTimeout func()) {
+	interval := min(timeout/4, time.Second)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		if a.idle() >= timeout {
+			onTimeout()
+			return
+		}
+	}
+}
+
+// SetInactivityTimeout calls fn once if no RTP packet is accepted for timeout, 0 disables it.
+// RTCP is not activity, a remote may keep sending reports after its media stopped.
+// Only a media receiving audio (recvonly or sendrecv) is watched.
+// It must be called before the media starts, e.g. in DialogHandler.OnMediaOpen.
+// Default: disabled, see Server.MediaTimeout
+func (m *Media) SetInactivityTimeout(timeout time.Duration, fn func(m *Media)) {
+	m.inactivityTimeout = timeout
+	m.onInactive = fn
+}
+
+// startWatchInactivity is called when the media starts.
+func (m *Media) startWatchInactivity() {
+	m.rxActivity.touch()
+	go watchIdle(&m.rxActivity, m.inactivityTimeout, m.done, func() {
+		m.logger.Warn("media inactivity timeout", "timeout", m.inactivityTimeout)
+		if m.onInactive != nil {
+			m.onInactive(m)
+		}
+	})
+}
+
+// onTimeout closes the dialog on inactivity.
+func (d *DialogServer) onTimeout(timeout Timeout) {
+	d.logger.Warn("dialog timeout", "timeout", timeout.String())
+	if h, ok := d.handler.(TimeoutHandler); ok {
+		h.OnTimeout(timeout)
+	}
+	if err := d.Close(); err != nil {
+		d.logger.Error("failed to close dialog server", "error", err)
+	}
+}
+
+// startWatchChannel closes the dialog if the channel is idle for Server.ControlIdleTimeout.
+func (d *DialogServer) startWatchChannel() {
+	if d.ss.ControlIdleTimeout <= 0 || d.channel == nil {
+		return
+	}
+	d.channel.activity.touch()
+	go watchIdle(&d.channel.activity, d.ss.ControlIdleTimeout, d.ctx.Done(), func() {
+		d.onTimeout(TimeoutControl)
+	})
+}
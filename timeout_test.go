@@ -0,0 +1,162 @@
+package mrcp
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMedia_SetInactivityTimeout(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	m := &Media{
+		conn:       conn,
+		remote:     sender.LocalAddr().(*net.UDPAddr),
+		audioCodec: CodecDesc{PayloadType: 0, Name: "PCMU", SampleRate: 8000},
+		handler:    MediaHandlerFunc{},
+		done:       make(chan struct{}),
+		stats:      newRTPStats(8000),
+		logger:     slog.Default(),
+	}
+	defer m.Close()
+	timeout := make(chan time.Time, 1)
+	m.SetInactivityTimeout(100*time.Millisecond, func(*Media) { timeout <- time.Now() })
+	m.startWatchInactivity()
+	go m.startReadMedia()
+
+	// the media is active for 300ms
+	start := time.Now()
+	for i := 0; time.Since(start) < 300*time.Millisecond; i++ {
+		packet := RTPPacket{PayloadType: 0, SequenceNumber: uint16(i), Payload: make([]byte, 160)}
+		data, _ := packet.Marshal()
+		_, _ = sender.Write(data)
+		time.Sleep(20 * time.Millisecond)
+	}
+	stopped := time.Now()
+
+	// the reports received after the media stopped do not keep it active
+	go func() {
+		for {
+			select {
+			case <-m.done:
+				return
+			case <-time.After(20 * time.Millisecond):
+				m.onRTCP(appendRTCPReceiverReport(nil, 1, nil))
+			}
+		}
+	}()
+
+	select {
+	case got := <-timeout:
+		if got.Before(stopped) {
+			t.Errorf("timeout %v before the media stopped", stopped.Sub(got))
+		} else if got.Sub(stopped) > 200*time.Millisecond {
+			t.Errorf("timeout got %v after the media stopped, want about 100ms", got.Sub(stopped))
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timeout not called")
+	}
+}
+
+func TestMedia_SetInactivityTimeout_sendonly(t *testing.T) {
+	m := &Media{
+		remote:     &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9},
+		laudioDesc: MediaDesc{Host: "127.0.0.1", Direction: DirectionSendonly, RTCPMux: true},
+		raudioDesc: MediaDesc{Host: "127.0.0.1", Port: 9, RTCPMux: true},
+		audioCodec: CodecDesc{PayloadType: 0, Name: "PCMU", SampleRate: 8000},
+		handler:    MediaHandlerFunc{},
+		logger:     slog.Default(),
+	}
+	timeout := make(chan time.Time, 1)
+	m.SetInactivityTimeout(50*time.Millisecond, func(*Media) { timeout <- time.Now() })
+	if err := m.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// nothing is received by a send-only media
+	select {
+	case <-timeout:
+		t.Errorf("timeout called on a send-only media")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestMedia_onRTCP(t *testing.T) {
+	m := &Media{stats: newRTPStats(8000), logger: slog.Default()}
+	if m.onRTCP([]byte{0x80, 0xC9, 0x00}) {
+		t.Errorf("onRTCP() got = %v, want %v", true, false)
+	}
+	// a valid report is not activity either
+	if !m.onRTCP(appendRTCPReceiverReport(nil, 1, nil)) {
+		t.Errorf("onRTCP() got = %v, want %v", false, true)
+	}
+	if got := m.rxActivity.last.Load(); got != 0 {
+		t.Errorf("activity got = %v, want %v", got, 0)
+	}
+}
+
+func TestDialogServer_startWatchChannel(t *testing.T) {
+	s := &Server{ControlIdleTimeout: 100 * time.Millisecond, Logger: slog.Default()}
+	var err error
+	if s.porter, err = newPorter(20000, 20010); err != nil {
+		t.Fatal(err)
+	}
+	port, _ := s.porter.get()
+
+	var timeouts atomic.Int32
+	var got Timeout
+	closed := make(chan struct{})
+	d := &DialogServer{
+		callId: "call",
+		ss:     s,
+		handler: DialogHandlerFunc{
+			OnTimeoutFunc: func(timeout Timeout) { got = timeout; timeouts.Add(1) },
+			OnCloseFunc:   func() { close(closed) },
+		},
+		logger: slog.Default(),
+	}
+	d.ldesc.AudioDesc.Port = int(port)
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	d.channel = &Channel{id: ChannelId{Id: "channel", Resource: ResourceSpeechrecog}, logger: slog.Default()}
+	s.dialogs.Store(d.callId, d)
+	s.channels.Store(d.channel.id.Id, d.channel)
+
+	d.startWatchChannel()
+	// a message keeps the channel active
+	time.Sleep(60 * time.Millisecond)
+	d.channel.onMessage(Message{messageType: MessageTypeRequest})
+	time.Sleep(60 * time.Millisecond)
+	if timeouts.Load() != 0 {
+		t.Errorf("OnTimeout() called while the channel is active")
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("dialog not closed")
+	}
+	if timeouts.Load() != 1 || got != TimeoutControl {
+		t.Errorf("OnTimeout() got = %v %d times, want %v once", got, timeouts.Load(), TimeoutControl)
+	}
+	if _, ok := s.dialogs.Load(d.callId); ok {
+		t.Errorf("dialog not removed")
+	}
+	if _, ok := s.channels.Load(d.channel.id.Id); ok {
+		t.Errorf("channel not removed")
+	}
+	if used := s.porter.portsUsed.Load(); used != 0 {
+		t.Errorf("ports used got = %d, want 0", used)
+	}
+}
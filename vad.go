@@ -0,0 +1,103 @@
+package mrcp
+
+import (
+	"github.com/hateeyan/go-mrcp/pkg/pcm"
+	"sync"
+)
+
+// SpeechHandler is notified when speech starts and ends on the received stream, e.g. to raise
+// START-OF-INPUT, detect barge-in or run the Speech-Complete-Timeout.
+// The methods are called from the receiving goroutine and must not block.
+type SpeechHandler interface {
+	OnSpeechStart(m *Media)
+	OnSpeechEnd(m *Media)
+}
+
+type SpeechHandlerFunc struct {
+	OnSpeechStartFunc func(m *Media)
+	OnSpeechEndFunc   func(m *Media)
+}
+
+func (h SpeechHandlerFunc) OnSpeechStart(m *Media) {
+	if h.OnSpeechStartFunc != nil {
+		h.OnSpeechStartFunc(m)
+	}
+}
+
+func (h SpeechHandlerFunc) OnSpeechEnd(m *Media) {
+	if h.OnSpeechEndFunc != nil {
+		h.OnSpeechEndFunc(m)
+	}
+}
+
+// speechDetector runs the VAD on the received audio.
+type speechDetector struct {
+	mu         sync.Mutex
+	transcoder audioTranscoder
+	vad        *pcm.VAD
+	handler    SpeechHandler
+}
+
+func (d *speechDetector) detect(m *Media, payload []byte) {
+	d.mu.Lock()
+	samples, err := d.transcoder.Decode(payload)
+	if err != nil {
+		d.mu.Unlock()
+		return
+	}
+	events := d.vad.Process(samples)
+	d.mu.Unlock()
+
+	for _, event := range events {
+		switch event {
+		case pcm.VADSpeechStart:
+			m.logger.Debug("speech start")
+			d.handler.OnSpeechStart(m)
+		case pcm.VADSpeechEnd:
+			m.logger.Debug("speech end")
+			d.handler.OnSpeechEnd(m)
+		}
+	}
+}
+
+// SetSpeechHandler enables voice activity detection on the received stream,
+// config.SampleRate is ignored, the audio rate of the codec is used.
+// It must be called before the media starts, e.g. in DialogHandler.OnMediaOpen.
+func (m *Media) SetSpeechHandler(config pcm.VADConfig, handler SpeechHandler) error {
+	transcoder, err := newAudioTranscoder(m.audioCodec)
+	if err != nil {
+		return err
+	}
+	config.SampleRate = m.audioCodec.AudioRate()
+	m.speech = &speechDetector{transcoder: transcoder, vad: pcm.NewVAD(config), handler: handler}
+	return nil
+}
+
+// SetSpeechSensitivity sets the sensitivity of the voice activity detection from 0.0 to 1.0,
+// e.g. from Message.GetSensitivityLevel of a RECOGNIZE request when present.
+func (m *Media) SetSpeechSensitivity(level float64) {
+	if m.speech == nil {
+		return
+	}
+	m.speech.mu.Lock()
+	m.speech.vad.SetSensitivity(level)
+	m.speech.mu.Unlock()
+}
+
+// Speaking returns whether speech is in progress on the received stream,
+// false if voice activity detection is not enabled.
+func (m *Media) Speaking() bool {
+	if m.speech == nil {
+		return false
+	}
+	m.speech.mu.Lock()
+	defer m.speech.mu.Unlock()
+	return m.speech.vad.Speaking()
+}
+
+// onReceivedAudio is called with the payload of each received audio packet and of the expanded comfort noise.
+func (m *Media) onReceivedAudio(payload []byte) {
+	if m.speech != nil {
+		m.speech.detect(m, payload)
+	}
+}
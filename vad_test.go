@@ -0,0 +1,46 @@
+package mrcp
+
+import (
+	"github.com/hateeyan/go-mrcp/pkg/pcm"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMedia_SetSpeechHandler(t *testing.T) {
+	m := &Media{
+		audioCodec: CodecDesc{Name: "PCMU", PayloadType: 0, SampleRate: 8000},
+		handler:    MediaHandlerFunc{WriteRTPPacketFunc: func(*Media, []byte) bool { return true }},
+		logger:     slog.Default(),
+	}
+	var got []string
+	err := m.SetSpeechHandler(pcm.VADConfig{}, SpeechHandlerFunc{
+		OnSpeechStartFunc: func(m *Media) { got = append(got, "start") },
+		OnSpeechEndFunc:   func(m *Media) { got = append(got, "end") },
+	})
+	if err != nil {
+		t.Errorf("SetSpeechHandler() error = %v", err)
+		return
+	}
+	m.SetSpeechSensitivity(0.7)
+
+	samples := pcm.ToneSequence(8000,
+		pcm.ToneSegment{Duration: 500 * time.Millisecond},
+		pcm.ToneSegment{Freqs: []float64{300}, Duration: 500 * time.Millisecond, Level: -20},
+		pcm.ToneSegment{Duration: time.Second},
+	)
+	payload := make([]byte, 160)
+	for i := 0; i < len(samples); i += 320 {
+		_ = pcm.LinearToMuLaw(samples[i:i+320], payload)
+		packet := RTPPacket{PayloadType: 0, SequenceNumber: uint16(i / 320), Payload: payload}
+		data, _ := packet.Marshal()
+		m.writeRTPPacket(&packet, data)
+		if i/320 == 40 && !m.Speaking() {
+			t.Errorf("Speaking() got = false during speech")
+		}
+	}
+	if want := []string{"start", "end"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SpeechHandler got = %v, want %v", got, want)
+	}
+}
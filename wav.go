@@ -0,0 +1,113 @@
+package mrcp
+
+import (
+	"errors"
+	"github.com/hateeyan/go-mrcp/pkg/pcm"
+	"io"
+	"sync"
+)
+
+// wavRecorder writes the received audio into a WAV file.
+type wavRecorder struct {
+	mu         sync.Mutex
+	transcoder audioTranscoder
+	writer     *pcm.WavWriter
+	closed     bool
+}
+
+func (r *wavRecorder) write(m *Media, payload []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	samples, err := r.transcoder.Decode(payload)
+	if err != nil {
+		return
+	}
+	if _, err := r.writer.Write(samples); err != nil {
+		m.logger.Error("failed to record audio", "error", err)
+		r.closed = true
+	}
+}
+
+func (r *wavRecorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.writer.Close()
+}
+
+// PlayWav writes the audio of a WAV file to Media.AudioWriter, resampled to Media.AudioRate if needed,
+// the audio is sent in place of the packets of a MediaHandler.
+// It returns once the audio is buffered, call AudioWriter.Drain to wait until it has been sent.
+func (m *Media) PlayWav(r io.Reader) error {
+	wr, err := pcm.NewWavReader(r)
+	if err != nil {
+		return err
+	}
+	w := m.AudioWriter()
+	if wr.SampleRate == m.AudioRate() {
+		_, err = io.Copy(w, wr)
+		return err
+	}
+
+	resampler, err := pcm.NewResampler(wr.SampleRate, m.AudioRate())
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 2*wr.SampleRate*m.ptime()/1000)
+	for {
+		n, err := wr.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(resampler.Resample(buf[:n])); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// flush the tail of the audio delayed by the resampler with 10ms of silence
+	_, err = w.Write(resampler.Resample(make([]byte, 2*wr.SampleRate/100)))
+	return err
+}
+
+// RecordWav records the received audio into w as a 16-bit PCM WAV file at the audio rate of the codec,
+// e.g. for debugging. The recording runs until the media is closed,
+// the sizes in the header are updated then if w is an io.WriteSeeker. w is not closed.
+func (m *Media) RecordWav(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return io.ErrClosedPipe
+	}
+	if m.recorder.Load() != nil {
+		return errors.New("media is already recording")
+	}
+
+	transcoder, err := newAudioTranscoder(m.audioCodec)
+	if err != nil {
+		return err
+	}
+	writer, err := pcm.NewWavWriter(w, pcm.WavFormatPCM, m.audioCodec.AudioRate())
+	if err != nil {
+		return err
+	}
+	m.recorder.Store(&wavRecorder{transcoder: transcoder, writer: writer})
+	return nil
+}
+
+// record writes the payload of a received audio packet to the recorder if any.
+func (m *Media) record(packet *RTPPacket) {
+	recorder := m.recorder.Load()
+	if recorder != nil && int(packet.PayloadType) == m.audioCodec.PayloadType {
+		recorder.write(m, packet.Payload)
+	}
+}
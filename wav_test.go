@@ -0,0 +1,142 @@
+package mrcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/hateeyan/go-mrcp/pkg/pcm"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMedia_PlayWav(t *testing.T) {
+	samples := make([]byte, 2*1600)
+	for i := 0; i < len(samples); i += 2 {
+		binary.LittleEndian.PutUint16(samples[i:], 1000)
+	}
+	data, _ := pcm.EncodeWav(samples, pcm.WavFormatPCM, 8000)
+
+	tests := []struct {
+		name      string
+		audioRate int
+		// wantLen the minimum number of bytes written to AudioWriter
+		wantLen int
+	}{
+		{name: "same rate", audioRate: 8000, wantLen: len(samples)},
+		{name: "resampled", audioRate: 16000, wantLen: 2 * len(samples)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Media{audioCodec: CodecDesc{Name: "PCMU", PayloadType: 0, SampleRate: 8000}, logger: slog.Default()}
+			m.SetAudioRate(tt.audioRate)
+			w := m.AudioWriter()
+			w.limit = 1 << 20
+			if err := m.PlayWav(bytes.NewReader(data)); err != nil {
+				t.Errorf("PlayWav() error = %v", err)
+				return
+			}
+			if got := w.pipe.buf.Len(); got < tt.wantLen {
+				t.Errorf("PlayWav() got = %d bytes, want at least %d bytes", got, tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestMedia_PlayWav_mediaHandler(t *testing.T) {
+	receiver, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+
+	// 100ms at 16 kHz, resampled to the 8 kHz audio rate of PCMU
+	data, _ := pcm.EncodeWav(make([]byte, 2*1600), pcm.WavFormatPCM, 16000)
+	m := &Media{
+		remote:     receiver.LocalAddr().(*net.UDPAddr),
+		laudioDesc: MediaDesc{Host: "127.0.0.1", Direction: DirectionSendonly, RTCPMux: true},
+		raudioDesc: MediaDesc{Host: "127.0.0.1", Port: receiver.LocalAddr().(*net.UDPAddr).Port, RTCPMux: true},
+		audioCodec: CodecDesc{PayloadType: 0, Name: "PCMU", SampleRate: 8000},
+		handler:    MediaHandlerFunc{ReadRTPPacketFunc: func(*Media) ([]byte, bool) { return nil, true }},
+		logger:     slog.Default(),
+	}
+	if err := m.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		err := m.PlayWav(bytes.NewReader(data))
+		m.AudioWriter().Drain()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("PlayWav() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("PlayWav() not sent")
+	}
+
+	buf := make([]byte, 1500)
+	_ = receiver.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := receiver.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packet RTPPacket
+	if err := packet.Unmarshal(buf[:n]); err != nil || packet.PayloadType != 0 {
+		t.Errorf("packet got = %v, error = %v", packet, err)
+	}
+}
+
+func TestMedia_RecordWav(t *testing.T) {
+	m := &Media{
+		audioCodec: CodecDesc{Name: "PCMA", PayloadType: 8, SampleRate: 8000},
+		cnCodec:    CodecDesc{Name: CodecCN, PayloadType: 13, SampleRate: 8000},
+		handler:    MediaHandlerFunc{WriteRTPPacketFunc: func(*Media, []byte) bool { return true }},
+		logger:     slog.Default(),
+	}
+	f, err := os.Create(filepath.Join(t.TempDir(), "record.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := m.RecordWav(f); err != nil {
+		t.Errorf("RecordWav() error = %v", err)
+		return
+	}
+	if err := m.RecordWav(io.Discard); err == nil {
+		t.Errorf("RecordWav() error = nil, want already recording")
+	}
+
+	payload := bytes.Repeat([]byte{0xD5}, 160)
+	for i, pt := range []uint8{8, 13, 8} {
+		packet := RTPPacket{PayloadType: pt, SequenceNumber: uint16(i), Payload: payload}
+		data, _ := packet.Marshal()
+		m.writeRTPPacket(&packet, data)
+	}
+	_ = m.Close()
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	wr, err := pcm.NewWavReader(f)
+	if err != nil {
+		t.Errorf("NewWavReader() error = %v", err)
+		return
+	}
+	got, _ := io.ReadAll(wr)
+	// the CN packet is not recorded
+	want := make([]byte, 2*2*len(payload))
+	_ = pcm.ALawToLiner(append(payload, payload...), want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RecordWav() got = %d bytes, want %d bytes", len(got), len(want))
+	}
+}